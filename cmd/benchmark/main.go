@@ -0,0 +1,249 @@
+// Command benchmark compares push and pull dispatch, and the streams
+// backend, on the same workload.
+// It runs a coordinator and workers in process against the given Redis and
+// resets the system state before each run, so point it at a Redis that is
+// not in use, or give it a -namespace of its own. With -broker memory no
+// Redis is needed at all.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/coordinator"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+type Config struct {
+	RedisURL   string
+	Broker     string
+	DataDir    string
+	Namespace  string
+	Modes      string
+	Tasks      int
+	Workers    int
+	PoolSize   int
+	Rate       int
+	Complexity int
+	Warmup     time.Duration
+	Timeout    time.Duration
+	Verbose    bool
+}
+
+type Report struct {
+	Mode       string
+	Completed  int
+	Elapsed    time.Duration
+	Throughput float64
+	P50        time.Duration
+	P95        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+func main() {
+	cfg := &Config{}
+	flag.StringVar(&cfg.RedisURL, "redis", "localhost:6379", "Redis connection URL (its task state is reset)")
+	flag.StringVar(&cfg.Broker, "broker", broker.Redis, "Storage backend ("+strings.Join(broker.Kinds(), ", ")+")")
+	flag.StringVar(&cfg.DataDir, "data-dir", "benchmark-data", "Directory the file broker keeps its state in")
+	flag.StringVar(&cfg.Namespace, "namespace", "", "Key namespace to run in, leaving the rest of the Redis alone")
+	flag.StringVar(&cfg.Modes, "modes", strings.Join(modes(), ","), "Modes to compare ("+strings.Join(modes(), ", ")+")")
+	flag.IntVar(&cfg.Tasks, "tasks", 1000, "Tasks per run")
+	flag.IntVar(&cfg.Workers, "workers", 4, "Number of workers")
+	flag.IntVar(&cfg.PoolSize, "pool-size", 4, "Processors per worker")
+	flag.IntVar(&cfg.Rate, "rate", 0, "Submissions per second (0 submits everything at once)")
+	flag.IntVar(&cfg.Complexity, "complexity", 0, "Seconds each task takes (0 returns immediately)")
+	flag.DurationVar(&cfg.Warmup, "warmup", 2*time.Second, "Time for the workers to join before submitting")
+	flag.DurationVar(&cfg.Timeout, "timeout", 5*time.Minute, "Time allowed for a run to complete")
+	flag.BoolVar(&cfg.Verbose, "v", false, "Show coordinator and worker logs")
+	flag.Parse()
+
+	logger := log.New(os.Stdout, "[Benchmark] ", log.LstdFlags)
+
+	b, err := broker.Open(broker.Options{
+		Kind:      cfg.Broker,
+		RedisURL:  cfg.RedisURL,
+		Dir:       cfg.DataDir,
+		Namespace: cfg.Namespace,
+	})
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	defer b.Close()
+	if err := b.Ping(context.Background()); err != nil {
+		logger.Fatalf("Failed to connect to %s broker: %v", cfg.Broker, err)
+	}
+
+	var reports []*Report
+	for _, mode := range strings.Split(cfg.Modes, ",") {
+		mode = strings.TrimSpace(mode)
+		if mode != queue.Streams {
+			if err := worker.ValidateDispatchMode(mode); err != nil {
+				logger.Fatalf("Invalid configuration: %v", err)
+			}
+		}
+
+		logger.Printf("Running %d tasks in %s mode", cfg.Tasks, mode)
+		report, err := run(cfg, b, mode)
+		if err != nil {
+			logger.Fatalf("Run in %s mode failed: %v", mode, err)
+		}
+		reports = append(reports, report)
+	}
+
+	fmt.Printf("\n%-6s %10s %10s %12s %10s %10s %10s %10s\n",
+		"mode", "completed", "elapsed", "tasks/s", "p50", "p95", "p99", "max")
+	for _, r := range reports {
+		fmt.Printf("%-6s %10d %10s %12.1f %10s %10s %10s %10s\n",
+			r.Mode, r.Completed, r.Elapsed.Round(time.Millisecond), r.Throughput,
+			r.P50.Round(time.Millisecond), r.P95.Round(time.Millisecond),
+			r.P99.Round(time.Millisecond), r.Max.Round(time.Millisecond))
+	}
+}
+
+func run(cfg *Config, b broker.Broker, mode string) (*Report, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Streams are consumed in pull fashion, the other modes use sorted sets
+	backend, dispatch := queue.SortedSets, mode
+	if mode == queue.Streams {
+		backend, dispatch = queue.Streams, worker.DispatchPull
+	}
+
+	output := io.Discard
+	if cfg.Verbose {
+		output = os.Stdout
+	}
+
+	coord := coordinator.New(
+		coordinator.WithLogger(log.New(output, "[Coordinator] ", log.LstdFlags)),
+		coordinator.WithBroker(b),
+		coordinator.WithDispatchMode(dispatch),
+		coordinator.WithQueueBackend(backend),
+	)
+	go coord.Start(ctx)
+
+	// Let the coordinator reset the state before workers join
+	time.Sleep(time.Second)
+	if err := queue.Clear(ctx, b, []string{tenant.Default}); err != nil {
+		return nil, fmt.Errorf("failed to clear queues: %w", err)
+	}
+
+	workers := make([]*worker.Worker, 0, cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		w := worker.NewWorker(
+			worker.WithLogger(log.New(output, fmt.Sprintf("[Worker %d] ", i), log.LstdFlags)),
+			worker.WithBroker(b),
+			worker.WithPoolSize(cfg.PoolSize),
+			worker.WithDispatchMode(dispatch),
+			worker.WithQueueBackend(backend),
+		)
+		workers = append(workers, w)
+		go w.Start(ctx)
+	}
+	defer func() {
+		cancel()
+		for _, w := range workers {
+			<-w.Done()
+		}
+	}()
+
+	time.Sleep(cfg.Warmup)
+
+	start := time.Now()
+	if err := submit(ctx, b, cfg); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(cfg.Timeout)
+	for {
+		done, err := b.HashLen(ctx, tenant.ResultsKey(tenant.Default))
+		if err != nil {
+			return nil, fmt.Errorf("failed to count results: %w", err)
+		}
+		if int(done) >= cfg.Tasks {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("only %d of %d tasks completed within %s", done, cfg.Tasks, cfg.Timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	results, err := b.HashGetAll(ctx, tenant.ResultsKey(tenant.Default))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch results: %w", err)
+	}
+
+	// Latency is from submission until the worker finished the task
+	latencies := make([]time.Duration, 0, len(results))
+	for _, resultStr := range results {
+		var result task.Result
+		if err := json.Unmarshal([]byte(resultStr), &result); err != nil || result.Metrics == nil {
+			continue
+		}
+		latencies = append(latencies, result.Metrics.QueueWaitTime+result.Metrics.ProcessingTime)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return &Report{
+		Mode:       mode,
+		Completed:  len(latencies),
+		Elapsed:    elapsed,
+		Throughput: float64(len(latencies)) / elapsed.Seconds(),
+		P50:        percentile(latencies, 0.50),
+		P95:        percentile(latencies, 0.95),
+		P99:        percentile(latencies, 0.99),
+		Max:        percentile(latencies, 1),
+	}, nil
+}
+
+func submit(ctx context.Context, b broker.Broker, cfg *Config) error {
+	var interval time.Duration
+	if cfg.Rate > 0 {
+		interval = time.Second / time.Duration(cfg.Rate)
+	}
+
+	// Everything at one priority, so only the dispatch mode differs
+	priority := queue.CurrentClasses(ctx, b).Lowest()
+	scheduler := task.NewScheduler(b)
+	for i := 0; i < cfg.Tasks; i++ {
+		t := task.NewTask("benchmark", nil)
+		t.ComplexityScore = cfg.Complexity
+
+		if err := scheduler.ScheduleTask(ctx, t, &task.ScheduleOptions{Priority: priority, MaxRetries: t.MaxRetries}); err != nil {
+			return err
+		}
+
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	return nil
+}
+
+func modes() []string {
+	return append(worker.DispatchModes(), queue.Streams)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// Set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+type Config struct {
+	RedisURL     string            `json:"redis"`
+	PoolSize     int               `json:"poolSize"`
+	EnableSteal  bool              `json:"enableSteal"`
+	MinWorkers   int               `json:"minWorkers"`
+	MaxWorkers   int               `json:"maxWorkers"`
+	Capacity     int               `json:"capacity"`
+	Reserved     int               `json:"reservedSlots"`
+	ReservedPrio int               `json:"reservedPriority"`
+	Labels       map[string]string `json:"labels"`
+	Handlers     []string          `json:"handlers"`
+	DrainTimeout string            `json:"drainTimeout"`
+	Dispatch     string            `json:"dispatch"`
+	Queue        string            `json:"queue"`
+	Group        string            `json:"group"`
+	Namespace    string            `json:"namespace"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		RedisURL:     "localhost:6379",
+		PoolSize:     5,
+		DrainTimeout: "30s",
+	}
+}
+
+// loadFile overlays the values set in a JSON config file.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return nil
+}
+
+// loadEnv overlays the values set through DTPS_* environment variables.
+func (c *Config) loadEnv() error {
+	if v, ok := os.LookupEnv("DTPS_REDIS"); ok {
+		c.RedisURL = v
+	}
+	if v, ok := os.LookupEnv("DTPS_DRAIN_TIMEOUT"); ok {
+		c.DrainTimeout = v
+	}
+	if v, ok := os.LookupEnv("DTPS_DISPATCH"); ok {
+		c.Dispatch = v
+	}
+	if v, ok := os.LookupEnv("DTPS_QUEUE"); ok {
+		c.Queue = v
+	}
+	if v, ok := os.LookupEnv("DTPS_GROUP"); ok {
+		c.Group = v
+	}
+	if v, ok := os.LookupEnv("DTPS_NAMESPACE"); ok {
+		c.Namespace = v
+	}
+	if v, ok := os.LookupEnv("DTPS_LABELS"); ok {
+		labels, err := parseLabels(v)
+		if err != nil {
+			return fmt.Errorf("DTPS_LABELS: %w", err)
+		}
+		c.Labels = labels
+	}
+	if v, ok := os.LookupEnv("DTPS_HANDLERS"); ok {
+		c.Handlers = splitList(v)
+	}
+	if v, ok := os.LookupEnv("DTPS_STEAL"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("DTPS_STEAL: %w", err)
+		}
+		c.EnableSteal = enabled
+	}
+
+	ints := map[string]*int{
+		"DTPS_POOL_SIZE":         &c.PoolSize,
+		"DTPS_MIN_WORKERS":       &c.MinWorkers,
+		"DTPS_MAX_WORKERS":       &c.MaxWorkers,
+		"DTPS_CAPACITY":          &c.Capacity,
+		"DTPS_RESERVED_SLOTS":    &c.Reserved,
+		"DTPS_RESERVED_PRIORITY": &c.ReservedPrio,
+	}
+	for name, target := range ints {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*target = n
+	}
+
+	return nil
+}
+
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range splitList(s) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func main() {
+	var (
+		configPath   string
+		redisURL     string
+		poolSize     int
+		enableSteal  bool
+		minWorkers   int
+		maxWorkers   int
+		capacity     int
+		reserved     int
+		reservedPrio int
+		labels       string
+		handlers     string
+		drainTimeout string
+		dispatch     string
+		queueBackend string
+		group        string
+		namespace    string
+		showVersion  bool
+	)
+	flag.StringVar(&configPath, "config", os.Getenv("DTPS_CONFIG"), "Path to a JSON config file")
+	flag.StringVar(&redisURL, "redis", "", "Redis address list or redis[s]:// URL (Sentinel, Cluster, auth, TLS)")
+	flag.IntVar(&poolSize, "pool-size", 0, "Number of concurrent task processors")
+	flag.BoolVar(&enableSteal, "steal", false, "Steal work from busy workers")
+	flag.IntVar(&minWorkers, "min-workers", 0, "Autoscaling lower bound")
+	flag.IntVar(&maxWorkers, "max-workers", 0, "Autoscaling upper bound (0 disables autoscaling)")
+	flag.IntVar(&capacity, "capacity", 0, "Total task demand held at once (default 10 per slot)")
+	flag.IntVar(&reserved, "reserved-slots", 0, "Slots only tasks at or above -reserved-priority may use")
+	flag.IntVar(&reservedPrio, "reserved-priority", 0, "Priority level the reserved slots are kept for")
+	flag.StringVar(&labels, "labels", "", "Worker labels as key=value,key=value")
+	flag.StringVar(&handlers, "handlers", "", "Handler set as taskType=handler,... (available: simulate, echo)")
+	flag.StringVar(&drainTimeout, "drain-timeout", "", "Time allowed for in-flight tasks when draining")
+	flag.StringVar(&dispatch, "dispatch", "", "Dispatch mode (push, pull); follows the coordinator when unset")
+	flag.StringVar(&queueBackend, "queue", "", "Queue backend (zset, streams); follows the coordinator when unset")
+	flag.StringVar(&group, "group", "", "Consumer group to read task streams in")
+	flag.StringVar(&namespace, "namespace", "", "Key namespace of the cluster to join")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flag.Parse()
+
+	if showVersion {
+		fmt.Println(version)
+		return
+	}
+
+	logger := log.New(os.Stdout, "[Worker] ", log.LstdFlags)
+
+	// Defaults, then config file, then environment, then explicit flags
+	cfg := defaultConfig()
+	if configPath != "" {
+		if err := cfg.loadFile(configPath); err != nil {
+			logger.Fatalf("Invalid configuration: %v", err)
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+
+	var flagErr error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "redis":
+			cfg.RedisURL = redisURL
+		case "pool-size":
+			cfg.PoolSize = poolSize
+		case "steal":
+			cfg.EnableSteal = enableSteal
+		case "min-workers":
+			cfg.MinWorkers = minWorkers
+		case "max-workers":
+			cfg.MaxWorkers = maxWorkers
+		case "capacity":
+			cfg.Capacity = capacity
+		case "reserved-slots":
+			cfg.Reserved = reserved
+		case "reserved-priority":
+			cfg.ReservedPrio = reservedPrio
+		case "labels":
+			cfg.Labels, flagErr = parseLabels(labels)
+		case "handlers":
+			cfg.Handlers = splitList(handlers)
+		case "drain-timeout":
+			cfg.DrainTimeout = drainTimeout
+		case "dispatch":
+			cfg.Dispatch = dispatch
+		case "queue":
+			cfg.Queue = queueBackend
+		case "group":
+			cfg.Group = group
+		case "namespace":
+			cfg.Namespace = namespace
+		}
+	})
+	if flagErr != nil {
+		logger.Fatalf("Invalid configuration: %v", flagErr)
+	}
+
+	timeout, err := time.ParseDuration(cfg.DrainTimeout)
+	if err != nil {
+		logger.Fatalf("Invalid drain timeout %q: %v", cfg.DrainTimeout, err)
+	}
+
+	if cfg.Dispatch != "" {
+		if err := worker.ValidateDispatchMode(cfg.Dispatch); err != nil {
+			logger.Fatalf("Invalid configuration: %v", err)
+		}
+	}
+	if cfg.Queue != "" {
+		if err := queue.ValidateBackend(cfg.Queue); err != nil {
+			logger.Fatalf("Invalid configuration: %v", err)
+		}
+	}
+
+	handlerSet, err := worker.ParseHandlers(cfg.Handlers)
+	if err != nil {
+		logger.Fatalf("Invalid handler set: %v", err)
+	}
+
+	redisOpts, err := broker.ParseRedisURL(cfg.RedisURL)
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := broker.ValidateNamespace(cfg.Namespace); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Test Redis connection before joining the cluster
+	b := broker.WithNamespace(broker.NewRedis(redisOpts), cfg.Namespace)
+	if err := b.Ping(ctx); err != nil {
+		logger.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	opts := []worker.Option{
+		worker.WithLogger(logger),
+		worker.WithBroker(b),
+		worker.WithPoolSize(cfg.PoolSize),
+		worker.WithWorkStealing(cfg.EnableSteal),
+		worker.WithDrainTimeout(timeout),
+		worker.WithLabels(cfg.Labels),
+		worker.WithCapacity(cfg.Capacity),
+		worker.WithReservation(cfg.Reserved, cfg.ReservedPrio),
+		worker.WithVersion(version),
+		worker.WithDispatchMode(cfg.Dispatch),
+		worker.WithQueueBackend(cfg.Queue),
+	}
+	if cfg.Group != "" {
+		opts = append(opts, worker.WithConsumerGroup(cfg.Group))
+	}
+	if cfg.MaxWorkers > 0 {
+		opts = append(opts, worker.WithAutoScaling(cfg.MinWorkers, cfg.MaxWorkers))
+	}
+	for taskType, handler := range handlerSet {
+		opts = append(opts, worker.WithHandler(taskType, handler))
+	}
+
+	w := worker.NewWorker(opts...)
+
+	// First signal drains, a second one exits immediately
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		logger.Println("Shutdown signal received, draining worker...")
+		cancel()
+
+		<-sigChan
+		logger.Println("Second signal received, exiting without draining")
+		os.Exit(1)
+	}()
+
+	logger.Printf("Worker %s (version %s) joining cluster at %s", w.ID(), version, broker.RedactRedisURL(cfg.RedisURL))
+	if err := w.Start(ctx); err != nil && err != context.Canceled {
+		logger.Fatalf("Worker error: %v", err)
+	}
+	logger.Println("Worker stopped")
+}
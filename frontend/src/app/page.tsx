@@ -7,12 +7,18 @@ import { WorkerStatus } from '@/components/dashboard/worker-status';
 import { TaskSubmissionForm } from '@/components/dashboard/task-submission';
 import { SystemManagement } from '@/components/dashboard/system-management';
 
+export interface PriorityClass {
+  name: string;
+  level: number;
+}
+
 interface SystemMetrics {
   activeWorkers: number;
   totalTasks: number;
   processedTasks: number;
   failedTasks: number;
   queueLengths: Record<string, number>;
+  priorityClasses: PriorityClass[];
   workerMetrics: Record<string, any>;
 }
 
@@ -23,6 +29,7 @@ export default function Home() {
     processedTasks: 0,
     failedTasks: 0,
     queueLengths: {},
+    priorityClasses: [],
     workerMetrics: {}
   });
   const [error, setError] = useState<string | null>(null);
@@ -72,13 +79,13 @@ export default function Home() {
                 failedTasks={metrics.failedTasks}
             />
 
-            <QueueChart queueLengths={metrics.queueLengths} />
+            <QueueChart queueLengths={metrics.queueLengths} priorityClasses={metrics.priorityClasses} />
 
             <WorkerStatus workers={metrics.workerMetrics} />
           </div>
 
           <div className="space-y-6">
-            <TaskSubmissionForm onSuccess={fetchMetrics} />
+            <TaskSubmissionForm priorityClasses={metrics.priorityClasses} onSuccess={fetchMetrics} />
             <SystemManagement onSystemReset={fetchMetrics} />
           </div>
         </div>
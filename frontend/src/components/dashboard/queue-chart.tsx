@@ -1,6 +1,7 @@
 import React from 'react';
 import { Card, CardContent, CardHeader, CardTitle } from '../ui/card';
 import { BarChart, Bar, XAxis, YAxis, CartesianGrid, Tooltip, Legend, ResponsiveContainer } from 'recharts';
+import type { PriorityClass } from '@/app/page';
 
 interface QueueData {
     priority: string;
@@ -9,6 +10,7 @@ interface QueueData {
 
 interface QueueChartProps {
     queueLengths?: Record<string, number>;
+    priorityClasses?: PriorityClass[];
 }
 
 const CustomTooltip = ({ active, payload, label }: any) => {
@@ -16,23 +18,24 @@ const CustomTooltip = ({ active, payload, label }: any) => {
         return (
             <div className="bg-[#27272a] border border-[#3f3f46] p-2 rounded-md shadow-lg">
                 <p className="text-[#ec4899] text-sm font-medium">{`Tasks in Queue : ${payload[0].value}`}</p>
-                <p className="text-gray-400 text-xs">{`Priority ${label}`}</p>
+                <p className="text-gray-400 text-xs">{`Class ${label}`}</p>
             </div>
         );
     }
     return null;
 };
 
-export function QueueChart({ queueLengths = {} }: QueueChartProps) {
-    const data: QueueData[] = Object.entries(queueLengths || {}).map(([priority, length]) => ({
-        priority: `P${priority}`,
-        length: length || 0
+export function QueueChart({ queueLengths = {}, priorityClasses = [] }: QueueChartProps) {
+    // Highest class first, named after the class
+    const data: QueueData[] = (priorityClasses || []).map((c) => ({
+        priority: c.name,
+        length: (queueLengths || {})[String(c.level)] || 0
     }));
 
     return (
         <Card className="bg-[#18181b] border-[#3f3f46]">
             <CardHeader>
-                <CardTitle className="text-white">Queue Lengths by Priority</CardTitle>
+                <CardTitle className="text-white">Queue Lengths by Priority Class</CardTitle>
             </CardHeader>
             <CardContent>
                 <div className="h-64">
@@ -1,13 +1,15 @@
 import React, { useState } from 'react';
 import { Card, CardContent, CardHeader, CardTitle } from '../ui/card';
 import { AlertCircle, Pen, Camera } from 'lucide-react';
+import type { PriorityClass } from '@/app/page';
 
 interface TaskSubmissionFormProps {
+    priorityClasses?: PriorityClass[];
     onSuccess?: () => void;
 }
 
-export function TaskSubmissionForm({ onSuccess }: TaskSubmissionFormProps) {
-    const [priority, setPriority] = useState(5);
+export function TaskSubmissionForm({ priorityClasses = [], onSuccess }: TaskSubmissionFormProps) {
+    const [priorityClass, setPriorityClass] = useState('');
     const [deadline, setDeadline] = useState('');
     const [retries, setRetries] = useState(3);
     const [taskType, setTaskType] = useState('test');
@@ -26,7 +28,8 @@ export function TaskSubmissionForm({ onSuccess }: TaskSubmissionFormProps) {
                     'Content-Type': 'application/json',
                 },
                 body: JSON.stringify({
-                    priority,
+                    // Without a choice, the lowest class
+                    priorityClass: priorityClass || priorityClasses[priorityClasses.length - 1]?.name,
                     deadline: deadline || undefined,
                     retries,
                     taskType,
@@ -40,7 +43,7 @@ export function TaskSubmissionForm({ onSuccess }: TaskSubmissionFormProps) {
 
             onSuccess?.();
             // Reset form
-            setPriority(5);
+            setPriorityClass('');
             setDeadline('');
             setRetries(3);
             setTaskType('test');
@@ -71,16 +74,19 @@ export function TaskSubmissionForm({ onSuccess }: TaskSubmissionFormProps) {
                 <div className="space-y-4">
                     <div>
                         <label className={labelClassName}>
-                            Priority (1-10)
+                            Priority Class
                         </label>
-                        <input
-                            type="number"
-                            min="1"
-                            max="10"
-                            value={priority}
-                            onChange={(e) => setPriority(Number(e.target.value))}
+                        <select
+                            value={priorityClass || priorityClasses[priorityClasses.length - 1]?.name || ''}
+                            onChange={(e) => setPriorityClass(e.target.value)}
                             className={inputClassName}
-                        />
+                        >
+                            {priorityClasses.map((c) => (
+                                <option key={c.name} value={c.name}>
+                                    {c.name} ({c.level})
+                                </option>
+                            ))}
+                        </select>
                     </div>
 
                     <div>
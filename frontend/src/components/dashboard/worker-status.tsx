@@ -2,12 +2,23 @@ import React, { useState } from 'react';
 import { Card, CardContent, CardHeader, CardTitle } from '../ui/card';
 import { AlertCircle, Plus, X } from 'lucide-react';
 
+interface WorkerRegistration {
+    hostname: string;
+    pid: number;
+    version: string;
+    poolSize: number;
+    taskTypes: string[];
+    labels?: Record<string, string>;
+    startedAt: string;
+}
+
 interface WorkerMetrics {
     id: string;
     lastSeen: string;
     tasksProcessed: number;
     activeTasks: number;
     status: string;
+    registration?: WorkerRegistration;
 }
 
 interface WorkerStatusProps {
@@ -156,6 +167,22 @@ export function WorkerStatus({ workers = {} }: WorkerStatusProps) {
                                     </span>
                                 </div>
                                 <div className="space-y-1 text-sm text-gray-300">
+                                    {worker.registration && (
+                                        <>
+                                            <div className="flex justify-between">
+                                                <span>Host:</span>
+                                                <span>{worker.registration.hostname} ({worker.registration.pid})</span>
+                                            </div>
+                                            <div className="flex justify-between">
+                                                <span>Version:</span>
+                                                <span>{worker.registration.version}</span>
+                                            </div>
+                                            <div className="flex justify-between">
+                                                <span>Task Types:</span>
+                                                <span>{worker.registration.taskTypes.join(', ')}</span>
+                                            </div>
+                                        </>
+                                    )}
                                     <div className="flex justify-between">
                                         <span>Tasks Processed:</span>
                                         <span>{worker.tasksProcessed}</span>
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/coordinator"
+)
+
+// FlowShare is how many of a priority's tasks went to one flow, next to
+// what its weight entitles it to while every flow has work queued.
+type FlowShare struct {
+	Priority  int     `json:"priority"`
+	Flow      string  `json:"flow"`
+	Weight    float64 `json:"weight"`
+	Assigned  int64   `json:"assigned"`
+	Share     float64 `json:"share"`
+	FairShare float64 `json:"fairShare"`
+}
+
+type FairnessMetrics struct {
+	Mode    string             `json:"mode"`
+	Weights map[string]float64 `json:"weights"`
+	Flows   []FlowShare        `json:"flows"`
+}
+
+type SetWeightRequest struct {
+	Flow   string  `json:"flow"`
+	Weight float64 `json:"weight"`
+}
+
+func (s *Server) collectFairnessMetrics(ctx context.Context) (*FairnessMetrics, error) {
+	metrics := &FairnessMetrics{
+		Weights: make(map[string]float64),
+		Flows:   []FlowShare{},
+	}
+	metrics.Mode, _ = s.broker.Get(ctx, coordinator.FairnessKey)
+
+	weights, err := s.broker.HashGetAll(ctx, coordinator.WeightsKey)
+	if err != nil {
+		return nil, err
+	}
+	for flow, value := range weights {
+		metrics.Weights[flow], _ = strconv.ParseFloat(value, 64)
+	}
+
+	shares, err := s.broker.HashGetAll(ctx, coordinator.SharesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the counters by priority to work out each flow's share
+	assigned := make(map[int]int64)
+	weighed := make(map[int]float64)
+	for field, count := range shares {
+		prefix, flow, ok := strings.Cut(field, "|")
+		priority, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			continue
+		}
+
+		fs := FlowShare{Priority: priority, Flow: flow, Weight: 1}
+		fs.Assigned, _ = strconv.ParseInt(count, 10, 64)
+		if weight, ok := metrics.Weights[flow]; ok {
+			fs.Weight = weight
+		}
+		assigned[priority] += fs.Assigned
+		weighed[priority] += fs.Weight
+		metrics.Flows = append(metrics.Flows, fs)
+	}
+	for i := range metrics.Flows {
+		fs := &metrics.Flows[i]
+		fs.Share = float64(fs.Assigned) / float64(assigned[fs.Priority])
+		fs.FairShare = fs.Weight / weighed[fs.Priority]
+	}
+
+	sort.Slice(metrics.Flows, func(i, j int) bool {
+		if metrics.Flows[i].Priority != metrics.Flows[j].Priority {
+			return metrics.Flows[i].Priority > metrics.Flows[j].Priority
+		}
+		return metrics.Flows[i].Flow < metrics.Flows[j].Flow
+	})
+	return metrics, nil
+}
+
+// handleAdminFairness reports the flows' shares of each priority, or sets
+// a flow's weight.
+func (s *Server) handleAdminFairness(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	ctx := context.Background()
+
+	switch r.Method {
+	case http.MethodGet:
+		metrics, err := s.collectFairnessMetrics(ctx)
+		if err != nil {
+			http.Error(w, "Failed to load fairness metrics", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(metrics)
+
+	case http.MethodPost:
+		var req SetWeightRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Flow == "" {
+			http.Error(w, "Flow is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := coordinator.SetWeight(ctx, s.broker, req.Flow, req.Weight); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid weight: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"flow":   req.Flow,
+			"weight": req.Weight,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
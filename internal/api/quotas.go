@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// QuotaStatus is a tenant's quota and how much of it is in use.
+type QuotaStatus struct {
+	Tenant         string        `json:"tenant"`
+	Quota          *tenant.Quota `json:"quota"`
+	QueuedTasks    int64         `json:"queuedTasks"`
+	RunningTasks   int64         `json:"runningTasks"`
+	CPUUsedSeconds float64       `json:"cpuUsedSeconds"`
+	WindowEnds     *time.Time    `json:"windowEnds,omitempty"`
+	Throttled      string        `json:"throttled,omitempty"`
+}
+
+// TenantUsage is a tenant's usage in total and by task type.
+type TenantUsage struct {
+	tenant.Usage
+	Types map[string]*tenant.Usage `json:"types"`
+}
+
+// UsageReport is what tenants used over a range of days.
+type UsageReport struct {
+	From    string                  `json:"from,omitempty"`
+	To      string                  `json:"to,omitempty"`
+	Tenants map[string]*TenantUsage `json:"tenants"`
+}
+
+func (s *Server) queuedTasks(ctx context.Context, name string) (int64, error) {
+	backend := queue.Current(ctx, s.broker)
+	var queued int64
+	for _, priority := range queue.CurrentClasses(ctx, s.broker).Levels() {
+		length, err := queue.Length(ctx, s.broker, backend, name, priority)
+		if err != nil {
+			return 0, err
+		}
+		queued += length
+	}
+	return queued, nil
+}
+
+// overQuota returns why the tenant may not submit another task, or an
+// empty string if it may. Running tasks only hold back dispatch.
+func (s *Server) overQuota(ctx context.Context, name string) (string, error) {
+	q, err := tenant.GetQuota(ctx, s.broker, name)
+	if err != nil || q == nil {
+		return "", err
+	}
+
+	if q.MaxQueued > 0 {
+		queued, err := s.queuedTasks(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if queued >= q.MaxQueued {
+			return fmt.Sprintf("Queued task quota of %d reached", q.MaxQueued), nil
+		}
+	}
+
+	if q.CPUSeconds > 0 {
+		used, ends, err := tenant.CPUUsed(ctx, s.broker, name, q.Window())
+		if err != nil {
+			return "", err
+		}
+		if used.Seconds() >= q.CPUSeconds {
+			return fmt.Sprintf("CPU budget of %gs per %s used up until %s",
+				q.CPUSeconds, q.Window(), ends.Format(time.RFC3339)), nil
+		}
+	}
+	return "", nil
+}
+
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	q, err := tenant.GetQuota(ctx, s.broker, name)
+	if err != nil {
+		http.Error(w, "Failed to load quota", http.StatusInternalServerError)
+		return
+	}
+	if q == nil {
+		q = &tenant.Quota{}
+	}
+
+	status := QuotaStatus{Tenant: name, Quota: q}
+	status.QueuedTasks, _ = s.queuedTasks(ctx, name)
+	if metrics, ok := s.metrics.Load("current"); ok {
+		status.RunningTasks = metrics.(*AdminMetrics).Tenants[name].RunningTasks
+	}
+	if q.CPUSeconds > 0 {
+		used, ends, err := tenant.CPUUsed(ctx, s.broker, name, q.Window())
+		if err == nil {
+			status.CPUUsedSeconds = used.Seconds()
+			status.WindowEnds = &ends
+		}
+	}
+	throttled, _ := tenant.Throttled(ctx, s.broker)
+	status.Throttled = throttled[name]
+
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
+	}
+
+	s.writeUsage(w, r, []string{name})
+}
+
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	tenants, err := tenant.List(context.Background(), s.broker)
+	if err != nil {
+		http.Error(w, "Failed to load tenants", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeUsage(w, r, tenants)
+}
+
+// writeUsage answers with the tenants' usage over the days in the from
+// and to query parameters.
+func (s *Server) writeUsage(w http.ResponseWriter, r *http.Request, tenants []string) {
+	report := UsageReport{
+		From:    r.URL.Query().Get("from"),
+		To:      r.URL.Query().Get("to"),
+		Tenants: make(map[string]*TenantUsage),
+	}
+	for _, day := range []string{report.From, report.To} {
+		if _, err := time.Parse(tenant.DayFormat, day); day != "" && err != nil {
+			http.Error(w, "Invalid day format, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, name := range tenants {
+		types, err := tenant.LoadUsage(context.Background(), s.broker, name, report.From, report.To)
+		if err != nil {
+			http.Error(w, "Failed to load usage", http.StatusInternalServerError)
+			return
+		}
+
+		usage := &TenantUsage{Types: types}
+		for _, u := range types {
+			usage.Add(*u)
+		}
+		report.Tenants[name] = usage
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleAdminQuotas lists the tenants' quotas, or sets one tenant's.
+func (s *Server) handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	ctx := context.Background()
+
+	switch r.Method {
+	case http.MethodGet:
+		quotas, err := tenant.LoadQuotas(ctx, s.broker)
+		if err != nil {
+			http.Error(w, "Failed to load quotas", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(quotas)
+
+	case http.MethodPost:
+		name := r.URL.Query().Get("tenant")
+		if err := tenant.Validate(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var q tenant.Quota
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := q.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := tenant.SetQuota(ctx, s.broker, name, &q); err != nil {
+			http.Error(w, "Failed to set quota", http.StatusInternalServerError)
+			return
+		}
+		tenant.Register(ctx, s.broker, name)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tenant": name,
+			"quota":  q,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+func newTestServer(b broker.Broker) *Server {
+	s := NewServer(b)
+	s.logger = log.New(io.Discard, "", 0)
+	return s
+}
+
+func submit(s *Server, name string) *httptest.ResponseRecorder {
+	body := `{"taskType":"echo","payload":"x","priority":5}`
+	r := httptest.NewRequest(http.MethodPost, "/api/tasks", strings.NewReader(body))
+	r.Header.Set(TenantHeader, name)
+	w := httptest.NewRecorder()
+	s.handleSubmitTask(w, r)
+	return w
+}
+
+// TestQueuedQuota checks that a tenant cannot queue more tasks than its
+// quota allows, that other tenants are not affected, and that it may
+// submit again once tasks leave its queues.
+func TestQueuedQuota(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+	s := newTestServer(b)
+
+	if err := tenant.SetQuota(ctx, b, "a", &tenant.Quota{MaxQueued: 2}); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := submit(s, "a"); w.Code != http.StatusCreated {
+			t.Fatalf("submission %d: got %d %s, want %d", i+1, w.Code, w.Body, http.StatusCreated)
+		}
+	}
+	w := submit(s, "a")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "Queued task quota of 2 reached") {
+		t.Fatalf("submission over quota: got %d %s, want %d", w.Code, w.Body, http.StatusTooManyRequests)
+	}
+	if w := submit(s, "b"); w.Code != http.StatusCreated {
+		t.Fatalf("other tenant: got %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+
+	if _, err := b.SortedPopMin(ctx, 0, queue.PriorityKey("a", 5)); err != nil {
+		t.Fatalf("taking a task: %v", err)
+	}
+	if w := submit(s, "a"); w.Code != http.StatusCreated {
+		t.Fatalf("submission after a task left: got %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+}
+
+// TestCPUQuota checks that a tenant whose CPU budget is used up cannot
+// submit until its window ends.
+func TestCPUQuota(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+	s := newTestServer(b)
+
+	q := &tenant.Quota{CPUSeconds: 10}
+	if err := tenant.SetQuota(ctx, b, "a", q); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+	if w := submit(s, "a"); w.Code != http.StatusCreated {
+		t.Fatalf("within budget: got %d %s, want %d", w.Code, w.Body, http.StatusCreated)
+	}
+	if err := tenant.AddCPU(ctx, b, "a", q.Window(), 10*time.Second); err != nil {
+		t.Fatalf("AddCPU: %v", err)
+	}
+	if w := submit(s, "a"); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "CPU budget") {
+		t.Fatalf("budget used up: got %d %s, want %d", w.Code, w.Body, http.StatusTooManyRequests)
+	}
+}
+
+// TestUsageReport checks that the usage endpoint reports the caller's
+// usage by task type and in total, within the requested days.
+func TestUsageReport(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+	s := newTestServer(b)
+
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, u := range []struct {
+		name, taskType string
+		at             time.Time
+		usage          tenant.Usage
+	}{
+		{"a", "echo", day, tenant.Usage{Tasks: 2, ProcessingSeconds: 1.5, QueueWaitSeconds: 0.25}},
+		{"a", "report", day, tenant.Usage{Tasks: 1, Failed: 1, ProcessingSeconds: 3}},
+		{"a", "echo", day.AddDate(0, 0, 1), tenant.Usage{Tasks: 4, ProcessingSeconds: 2}},
+		{"b", "echo", day, tenant.Usage{Tasks: 7}},
+	} {
+		if err := tenant.RecordUsage(ctx, b, u.name, u.taskType, u.at, u.usage); err != nil {
+			t.Fatalf("RecordUsage: %v", err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/usage?from=2024-03-10&to=2024-03-10", nil)
+	r.Header.Set(TenantHeader, "a")
+	w := httptest.NewRecorder()
+	s.handleUsage(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+
+	var report UsageReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if _, ok := report.Tenants["b"]; ok || len(report.Tenants) != 1 {
+		t.Fatalf("report covers %v, want only the caller's tenant", report.Tenants)
+	}
+	got := report.Tenants["a"]
+	if got == nil {
+		t.Fatal("no usage reported for the caller's tenant")
+	}
+	want := tenant.Usage{Tasks: 3, Failed: 1, ProcessingSeconds: 4.5, QueueWaitSeconds: 0.25}
+	if got.Usage != want {
+		t.Fatalf("total: got %+v, want %+v", got.Usage, want)
+	}
+	if echo := got.Types["echo"]; echo == nil || echo.Tasks != 2 || echo.ProcessingSeconds != 1.5 {
+		t.Fatalf("echo usage: got %+v", echo)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/ratelimit"
+)
+
+// handleAdminRateLimits lists the rate limits with their throttling, and
+// sets or removes the limit of a task type.
+func (s *Server) handleAdminRateLimits(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	ctx := context.Background()
+
+	switch r.Method {
+	case http.MethodGet:
+		stats, err := ratelimit.LoadStats(ctx, s.broker)
+		if err != nil {
+			http.Error(w, "Failed to load rate limits", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(stats)
+
+	case http.MethodPost:
+		taskType := r.URL.Query().Get("taskType")
+		if taskType == "" {
+			http.Error(w, "Task type required", http.StatusBadRequest)
+			return
+		}
+
+		var l ratelimit.Limit
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := l.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := ratelimit.Set(ctx, s.broker, taskType, &l); err != nil {
+			http.Error(w, "Failed to set rate limit", http.StatusInternalServerError)
+			return
+		}
+		s.logger.Printf("Rate limit of task type %s set to %g/s (burst %d)", taskType, l.Rate, l.BurstSize())
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"taskType": taskType,
+			"limit":    l,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -3,66 +3,150 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/coordinator"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/ratelimit"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
 	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
 	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
-	"github.com/go-redis/redis/v8"
 )
 
 type Server struct {
-	redis   *redis.Client
-	metrics sync.Map
-	workers sync.Map // Track active worker instances
-	logger  *log.Logger
+	broker     broker.Broker
+	scheduler  *task.Scheduler
+	adminToken string
+	metrics    sync.Map // The latest *AdminMetrics under "current"
+	workers    sync.Map // Track active worker instances by ID (*managedWorker)
+	logger     *log.Logger
+	httpServer *http.Server
 }
 
+// SystemMetrics is what one tenant sees: its own tasks, and the workers
+// all tenants share.
 type SystemMetrics struct {
-	ActiveWorkers  int                   `json:"activeWorkers"`
-	TotalTasks     int64                 `json:"totalTasks"`
-	ProcessedTasks int64                 `json:"processedTasks"`
-	FailedTasks    int64                 `json:"failedTasks"`
-	QueueLengths   map[int]int64         `json:"queueLengths"`
-	WorkerMetrics  map[string]WorkerInfo `json:"workerMetrics"`
+	Tenant        string `json:"tenant,omitempty"`
+	ActiveWorkers int    `json:"activeWorkers"`
+	// Names the levels of the queue lengths
+	PriorityClasses queue.Classes `json:"priorityClasses"`
+	TenantMetrics
+	WorkerMetrics map[string]WorkerInfo `json:"workerMetrics"`
+	Scheduling    SchedulingMetrics     `json:"scheduling"`
+}
+
+type SchedulingMetrics struct {
+	Strategy   string                     `json:"strategy"`
+	Strategies map[string]StrategyMetrics `json:"strategies"`
+	// Task types that are rate limited or were throttled
+	RateLimits []ratelimit.Stats `json:"rateLimits"`
+}
+
+// StrategyMetrics summarises the assignments made with one strategy.
+type StrategyMetrics struct {
+	Assigned          int64            `json:"assigned"`
+	Completed         int64            `json:"completed"`
+	Failed            int64            `json:"failed"`
+	Preempted         int64            `json:"preempted"`
+	AvgQueueWaitMs    float64          `json:"avgQueueWaitMs"`
+	AvgProcessingMs   float64          `json:"avgProcessingMs"`
+	AssignedPerWorker map[string]int64 `json:"assignedPerWorker"`
 }
 
 type WorkerInfo struct {
-	ID             string    `json:"id"`
-	LastSeen       time.Time `json:"lastSeen"`
-	TasksProcessed uint64    `json:"tasksProcessed"`
-	ActiveTasks    int       `json:"activeTasks"`
-	Status         string    `json:"status"`
+	ID             string                 `json:"id"`
+	LastSeen       time.Time              `json:"lastSeen"`
+	TasksProcessed uint64                 `json:"tasksProcessed"`
+	ActiveTasks    int                    `json:"activeTasks"`
+	Load           int                    `json:"load"`
+	Capacity       int                    `json:"capacity,omitempty"`
+	Status         string                 `json:"status"`
+	Registration   *registry.WorkerRecord `json:"registration,omitempty"`
+
+	// Capacity units kept for high priorities, and how many of them are
+	// taken
+	Reserved      int `json:"reserved,omitempty"`
+	ReservedInUse int `json:"reservedInUse,omitempty"`
 }
 
 // Request structures
 type StartWorkerRequest struct {
-	PoolSize    int  `json:"poolSize"`
-	EnableSteal bool `json:"enableSteal"`
-	MinWorkers  int  `json:"minWorkers"`
-	MaxWorkers  int  `json:"maxWorkers"`
+	PoolSize    int               `json:"poolSize"`
+	EnableSteal bool              `json:"enableSteal"`
+	MinWorkers  int               `json:"minWorkers"`
+	MaxWorkers  int               `json:"maxWorkers"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Capacity    int               `json:"capacity,omitempty"`
+
+	// Slots only tasks at or above ReservedPriority may use
+	ReservedSlots    int `json:"reservedSlots,omitempty"`
+	ReservedPriority int `json:"reservedPriority,omitempty"`
 }
 
 type SubmitTaskRequest struct {
-	Priority int    `json:"priority"`
-	Deadline string `json:"deadline,omitempty"`
-	Retries  int    `json:"retries"`
-	TaskType string `json:"taskType"`
-	Payload  string `json:"payload"`
+	// Defaults to the tenant the request is made for
+	Tenant       string                  `json:"tenant,omitempty"`
+	Priority     int                     `json:"priority"`
+	Deadline     string                  `json:"deadline,omitempty"`
+	Retries      int                     `json:"retries"`
+	TaskType     string                  `json:"taskType"`
+	Payload      string                  `json:"payload"`
+	Complexity   int                     `json:"complexity,omitempty"`
+	Cost         int                     `json:"cost,omitempty"`
+	NodeSelector map[string]string       `json:"nodeSelector,omitempty"`
+	Affinity     []task.LabelRequirement `json:"affinity,omitempty"`
+	AntiAffinity []string                `json:"antiAffinity,omitempty"`
+
+	// Names the priority instead of its level
+	PriorityClass string `json:"priorityClass,omitempty"`
+	// Defaults to true; false keeps the task from being preempted
+	Preemptible *bool `json:"preemptible,omitempty"`
+	// What happens if the deadline is missed: drop, flag or demote
+	DeadlinePolicy string `json:"deadlinePolicy,omitempty"`
 }
 
-func NewServer(redis *redis.Client) *Server {
-	return &Server{
-		redis:  redis,
-		logger: log.New(os.Stdout, "[API Server] ", log.LstdFlags),
+const (
+	// How often metrics are refreshed without any events
+	metricsInterval = 5 * time.Second
+	// Minimum time between event-driven refreshes
+	metricsMinInterval = 250 * time.Millisecond
+)
+
+type Option func(*Server)
+
+// WithAdminToken enables the admin endpoints for requests carrying token.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
 	}
 }
 
+func NewServer(b broker.Broker, opts ...Option) *Server {
+	s := &Server{
+		broker:    b,
+		scheduler: task.NewScheduler(b),
+		logger:    log.New(os.Stdout, "[API Server] ", log.LstdFlags),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
 func (s *Server) Start(addr string) error {
 	mux := http.NewServeMux()
 
@@ -70,27 +154,46 @@ func (s *Server) Start(addr string) error {
 	mux.Handle("/api/metrics", corsMiddleware(s.handleMetrics))
 	mux.Handle("/api/debug", corsMiddleware(s.handleDebug))
 	mux.Handle("/api/system/reset", corsMiddleware(s.handleReset))
+	mux.Handle("/api/quota", corsMiddleware(s.handleQuota))
+	mux.Handle("/api/usage", corsMiddleware(s.handleUsage))
+
+	// Admin endpoints, across all tenants
+	mux.Handle("/api/admin/metrics", corsMiddleware(s.handleAdminMetrics))
+	mux.Handle("/api/admin/reset", corsMiddleware(s.handleAdminReset))
+	mux.Handle("/api/admin/quotas", corsMiddleware(s.handleAdminQuotas))
+	mux.Handle("/api/admin/usage", corsMiddleware(s.handleAdminUsage))
+	mux.Handle("/api/admin/fairness", corsMiddleware(s.handleAdminFairness))
+	mux.Handle("/api/admin/ratelimits", corsMiddleware(s.handleAdminRateLimits))
 
 	// Worker endpoints
 	mux.Handle("/api/workers", corsMiddleware(s.handleWorkers))
 	mux.Handle("/api/workers/start", corsMiddleware(s.handleStartWorker))
 	mux.Handle("/api/workers/stop", corsMiddleware(s.handleStopWorker))
+	mux.Handle("/api/workers/drain", corsMiddleware(s.handleDrainWorker))
+	mux.Handle("/api/workers/managed", corsMiddleware(s.handleManagedWorkers))
+	mux.Handle("/api/workers/restart", corsMiddleware(s.handleRestartWorker))
+	mux.Handle("/api/workers/reconfigure", corsMiddleware(s.handleReconfigureWorker))
 
 	// Task endpoints
 	mux.Handle("/api/tasks/submit", corsMiddleware(s.handleSubmitTask))
 	mux.Handle("/api/tasks/status", corsMiddleware(s.handleTaskStatus))
+	mux.Handle("/api/tasks/unschedulable", corsMiddleware(s.handleUnschedulable))
 
 	go s.collectMetrics()
 
 	s.logger.Printf("API server starting on %s\n", addr)
-	return http.ListenAndServe(addr, mux)
+	s.httpServer = &http.Server{Addr: addr, Handler: mux}
+	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 func corsMiddleware(next http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+TenantHeader+", "+AdminTokenHeader)
 		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 		w.Header().Set("Content-Type", "application/json")
 
@@ -103,122 +206,129 @@ func corsMiddleware(next http.HandlerFunc) http.Handler {
 	})
 }
 
-func (s *Server) handleStartWorker(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleSubmitTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req StartWorkerRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+	caller, ok := s.tenantOf(w, r)
+	if !ok {
 		return
 	}
 
-	// Create and start new worker
-	newWorker := worker.NewWorker(
-		worker.WithLogger(log.New(os.Stdout, "[Worker] ", log.LstdFlags)),
-		worker.WithRedis(s.redis.Options().Addr),
-		worker.WithPoolSize(req.PoolSize),
-	)
-
-	go func() {
-		if err := newWorker.Start(context.Background()); err != nil {
-			s.logger.Printf("Worker failed: %v", err)
-		}
-	}()
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "Worker started",
-		"config": req,
-	})
-}
-
-func (s *Server) handleStopWorker(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	var req SubmitTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	workerID := r.URL.Query().Get("id")
-	if workerID == "" {
-		http.Error(w, "Worker ID required", http.StatusBadRequest)
+	// Tasks can only be submitted for the tenant the request is made for,
+	// the default tenant when it names none
+	if req.Tenant == "" {
+		req.Tenant = caller
+	}
+	if req.Tenant != caller {
+		http.Error(w, "Task tenant does not match the request's", http.StatusForbidden)
 		return
 	}
 
-	// Remove worker from Redis
-	s.redis.HDel(context.Background(), "workers", workerID)
-
-	// Clean up worker data
-	s.redis.Del(context.Background(),
-		fmt.Sprintf("worker:%s:tasks", workerID),
-		fmt.Sprintf("worker:%s:results", workerID),
-		fmt.Sprintf("worker:%s:processing", workerID),
-	)
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "Worker stopped",
-		"id":     workerID,
-	})
-}
-
-func (s *Server) handleSubmitTask(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Create new task
+	newTask := task.NewTask(req.TaskType, []byte(req.Payload))
+	newTask.Tenant = req.Tenant
+	newTask.ComplexityScore = req.Complexity
+	newTask.Cost = req.Cost
+	newTask.NodeSelector = req.NodeSelector
+	newTask.Affinity = req.Affinity
+	newTask.AntiAffinity = req.AntiAffinity
+	newTask.DeadlinePolicy = req.DeadlinePolicy
+	newTask.NoPreempt = req.Preemptible != nil && !*req.Preemptible
+
+	if err := newTask.ValidatePlacement(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid placement: %v", err), http.StatusBadRequest)
 		return
 	}
-
-	var req SubmitTaskRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+	if err := task.ValidateDeadlinePolicy(req.DeadlinePolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create new task
-	newTask := task.NewTask(req.TaskType, []byte(req.Payload))
-	newTask.Priority = req.Priority
-	newTask.MaxRetries = req.Retries
-
+	ctx := context.Background()
+	opts := &task.ScheduleOptions{
+		Priority:   req.Priority,
+		MaxRetries: req.Retries,
+	}
 	if req.Deadline != "" {
 		deadline, err := time.Parse(time.RFC3339, req.Deadline)
 		if err != nil {
 			http.Error(w, "Invalid deadline format", http.StatusBadRequest)
 			return
 		}
-		newTask.Deadline = &deadline
+		opts.Deadline = &deadline
 	}
 
-	// Queue the task
-	taskBytes, _ := json.Marshal(newTask)
-	queueKey := fmt.Sprintf("tasks:priority:%d", newTask.Priority)
-	err := s.redis.ZAdd(context.Background(), queueKey, &redis.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: taskBytes,
-	}).Err()
+	// A class name stands for its level
+	classes := queue.CurrentClasses(ctx, s.broker)
+	if req.PriorityClass != "" {
+		class, ok := classes.Lookup(req.PriorityClass)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown priority class %q (available: %s)", req.PriorityClass, classes), http.StatusBadRequest)
+			return
+		}
+		if req.Priority != 0 && req.Priority != class.Level {
+			http.Error(w, fmt.Sprintf("Priority %d does not match priority class %q", req.Priority, class.Name), http.StatusBadRequest)
+			return
+		}
+		opts.Priority = class.Level
+	}
+	class, ok := classes.Class(opts.Priority)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%v (available: %s)", task.ErrInvalidPriority, classes), http.StatusBadRequest)
+		return
+	}
 
-	if err != nil {
+	// Refuse tasks beyond the tenant's quota
+	if reason, err := s.overQuota(ctx, newTask.Tenant); err != nil {
+		http.Error(w, "Failed to check quota", http.StatusInternalServerError)
+		return
+	} else if reason != "" {
+		http.Error(w, reason, http.StatusTooManyRequests)
+		return
+	}
+
+	// Queue the task
+	if err := s.scheduler.ScheduleTask(ctx, newTask, opts); errors.Is(err, task.ErrInvalidPriority) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to queue task", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"taskId": newTask.ID,
-		"status": "queued",
+		"taskId":        newTask.ID,
+		"tenant":        newTask.Tenant,
+		"priorityClass": class.Name,
+		"status":        "queued",
 	})
 }
 
 func (s *Server) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
+	}
+
 	taskID := r.URL.Query().Get("id")
 	if taskID == "" {
 		http.Error(w, "Task ID required", http.StatusBadRequest)
 		return
 	}
 
-	// Check results
-	result, err := s.redis.HGet(context.Background(), "results", taskID).Result()
+	// Only the tenant's own stores are searched, other tenants' tasks are
+	// not found
+	result, err := s.broker.HashGet(context.Background(), tenant.ResultsKey(name), taskID)
 	if err == nil {
 		var taskResult task.Result
 		json.Unmarshal([]byte(result), &taskResult)
@@ -227,7 +337,7 @@ func (s *Server) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check failed tasks
-	failed, err := s.redis.HGet(context.Background(), "failed_tasks", taskID).Result()
+	failed, err := s.broker.HashGet(context.Background(), tenant.FailedKey(name), taskID)
 	if err == nil {
 		var taskResult task.Result
 		json.Unmarshal([]byte(failed), &taskResult)
@@ -235,38 +345,135 @@ func (s *Server) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check tasks that are stuck in their queue
+	stuck, err := s.broker.HashGet(context.Background(), tenant.UnschedulableKey(name), taskID)
+	if err == nil {
+		var entry task.Unschedulable
+		json.Unmarshal([]byte(stuck), &entry)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"task_id": entry.TaskID,
+			"status":  "unschedulable",
+			"reason":  entry.Reason,
+			"since":   entry.Since,
+		})
+		return
+	}
+
+	// Check tasks still waiting in a queue or held by a worker
+	if t, status := s.findPendingTask(context.Background(), name, taskID); t != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"task_id":            t.ID,
+			"tenant":             t.Tenant,
+			"type":               t.Type,
+			"status":             status,
+			"priority":           t.Priority,
+			"effective_priority": t.QueuePriority(),
+			"created_at":         t.CreatedAt,
+			"deadline":           t.Deadline,
+			"deadline_missed":    t.DeadlineMissed,
+		})
+		return
+	}
+
 	http.Error(w, "Task not found", http.StatusNotFound)
 }
 
+// findPendingTask looks for a task of the tenant that has not finished,
+// first among the tasks workers hold and then in the tenant's queues.
+func (s *Server) findPendingTask(ctx context.Context, name, taskID string) (*task.Task, string) {
+	decode := func(data string) *task.Task {
+		var t task.Task
+		if err := json.Unmarshal([]byte(data), &t); err != nil || tenant.Name(t.Tenant) != name {
+			return nil
+		}
+		return &t
+	}
+
+	workers, _ := s.broker.HashGetAll(ctx, broker.WorkersKey)
+	for workerID := range workers {
+		if data, err := s.broker.HashGet(ctx, broker.WorkerProcessingKey(workerID), taskID); err == nil {
+			if t := decode(data); t != nil {
+				return t, string(task.StatusProcessing)
+			}
+		}
+		if data, err := s.broker.HashGet(ctx, broker.WorkerTasksKey(workerID), taskID); err == nil {
+			if t := decode(data); t != nil {
+				return t, string(task.StatusAssigned)
+			}
+		}
+	}
+
+	// Stream entries cannot be looked up by task
+	if queue.Current(ctx, s.broker) != queue.SortedSets {
+		return nil, ""
+	}
+	for _, priority := range queue.CurrentClasses(ctx, s.broker).Levels() {
+		queued, err := s.broker.SortedRange(ctx, queue.PriorityKey(name, priority), 0, -1)
+		if err != nil {
+			continue
+		}
+		for _, data := range queued {
+			if !strings.Contains(data, taskID) {
+				continue
+			}
+			if t := decode(data); t != nil && t.ID == taskID {
+				return t, "queued"
+			}
+		}
+	}
+	return nil, ""
+}
+
+func (s *Server) handleUnschedulable(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := s.broker.HashGetAll(r.Context(), tenant.UnschedulableKey(name))
+	if err != nil {
+		http.Error(w, "Failed to load unschedulable tasks", http.StatusInternalServerError)
+		return
+	}
+
+	tasks := []task.Unschedulable{}
+	for _, data := range entries {
+		var entry task.Unschedulable
+		if err := json.Unmarshal([]byte(data), &entry); err == nil {
+			tasks = append(tasks, entry)
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Since.Before(tasks[j].Since)
+	})
+
+	json.NewEncoder(w).Encode(tasks)
+}
+
+// handleReset clears the queues and results of the tenant the request is
+// made for. Workers are shared and left alone, see handleAdminReset.
 func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	ctx := context.Background()
-	pipe := s.redis.Pipeline()
-
-	// Clear all task queues
-	for priority := 1; priority <= 10; priority++ {
-		pipe.Del(ctx, fmt.Sprintf("tasks:priority:%d", priority))
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
 	}
 
-	// Clear worker data
-	workers, _ := s.redis.HGetAll(ctx, "workers").Result()
-	for workerID := range workers {
-		pipe.Del(ctx, fmt.Sprintf("worker:%s:tasks", workerID))
-		pipe.Del(ctx, fmt.Sprintf("worker:%s:results", workerID))
-		pipe.Del(ctx, fmt.Sprintf("worker:%s:processing", workerID))
-	}
+	ctx := context.Background()
 
-	// Clear global keys
-	pipe.Del(ctx, "workers")
-	pipe.Del(ctx, "results")
-	pipe.Del(ctx, "failed_tasks")
+	// Clear the tenant's task queues, including streams and their consumer
+	// groups
+	if err := queue.Clear(ctx, s.broker, []string{name}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reset queues: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-	_, err := pipe.Exec(ctx)
-	if err != nil {
+	if err := s.broker.Delete(ctx, tenant.Keys(name)...); err != nil {
 		http.Error(w, "Failed to reset system", http.StatusInternalServerError)
 		return
 	}
@@ -274,18 +481,23 @@ func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "System reset successful",
+		"tenant": name,
 	})
 }
 
-// Your existing handlers and metrics collection
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
+	}
+
 	metrics, ok := s.metrics.Load("current")
 	if !ok {
 		http.Error(w, "No metrics available", http.StatusNotFound)
 		return
 	}
 
-	json.NewEncoder(w).Encode(metrics)
+	json.NewEncoder(w).Encode(metrics.(*AdminMetrics).forTenant(name))
 }
 
 func (s *Server) handleWorkers(w http.ResponseWriter, r *http.Request) {
@@ -295,118 +507,250 @@ func (s *Server) handleWorkers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if sysMetrics, ok := metrics.(*SystemMetrics); ok {
+	if sysMetrics, ok := metrics.(*AdminMetrics); ok {
 		json.NewEncoder(w).Encode(sysMetrics.WorkerMetrics)
 	}
 }
 
 // Your existing metrics collection method
+// collectMetrics refreshes the metrics snapshot whenever tasks or workers
+// change, and at least every metricsInterval.
 func (s *Server) collectMetrics() {
-	ticker := time.NewTicker(1 * time.Second)
+	ctx := context.Background()
+	listener := events.Listen(ctx, s.broker,
+		events.Channel(events.TaskSubmitted, ""),
+		events.Channel(events.TaskCompleted, ""),
+		events.Channel(events.WorkerJoined, ""),
+		events.Channel(events.WorkerLeft, ""),
+	)
+	defer listener.Close()
+
+	ticker := time.NewTicker(metricsInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		metrics := &SystemMetrics{
-			QueueLengths:  make(map[int]int64),
-			WorkerMetrics: make(map[string]WorkerInfo),
+	s.metrics.Store("current", s.refreshMetrics())
+
+	var last time.Time
+	for {
+		select {
+		case <-ticker.C:
+			metrics := s.refreshMetrics()
+			s.metrics.Store("current", metrics)
+			s.logState(metrics)
+			last = time.Now()
+			continue
+		case <-listener.C:
 		}
 
-		// Collection logic from your existing code
-		total := int64(0)
-		for priority := 1; priority <= 10; priority++ {
-			queueKey := fmt.Sprintf("tasks:priority:%d", priority)
-			length, err := s.redis.ZCard(context.Background(), queueKey).Result()
-			if err == nil {
-				metrics.QueueLengths[priority] = length
-				total += length
-			}
+		// Bursts of events only cause a few refreshes per second
+		if wait := metricsMinInterval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
 		}
-		metrics.TotalTasks = total
-
-		processed, _ := s.redis.HLen(context.Background(), "results").Result()
-		metrics.ProcessedTasks = int64(processed)
-
-		failed, _ := s.redis.HLen(context.Background(), "failed_tasks").Result()
-		metrics.FailedTasks = int64(failed)
-
-		workers, _ := s.redis.HGetAll(context.Background(), "workers").Result()
-		metrics.ActiveWorkers = len(workers)
-
-		for workerID, lastSeenStr := range workers {
-			lastSeen, _ := strconv.ParseInt(lastSeenStr, 10, 64)
-			assignedTasks, _ := s.redis.HGetAll(context.Background(),
-				fmt.Sprintf("worker:%s:tasks", workerID)).Result()
-			processingTasks, _ := s.redis.HGetAll(context.Background(),
-				fmt.Sprintf("worker:%s:processing", workerID)).Result()
-			completedTasks, _ := s.redis.HGetAll(context.Background(),
-				fmt.Sprintf("worker:%s:results", workerID)).Result()
-
-			workerInfo := WorkerInfo{
-				ID:             workerID,
-				LastSeen:       time.Unix(lastSeen, 0),
-				TasksProcessed: uint64(len(completedTasks)),
-				ActiveTasks:    len(assignedTasks) + len(processingTasks),
-				Status:         "active",
+		for more := true; more; {
+			select {
+			case <-listener.C:
+			default:
+				more = false
 			}
+		}
 
-			if time.Since(workerInfo.LastSeen) > 30*time.Second {
-				workerInfo.Status = "inactive"
-			}
+		s.metrics.Store("current", s.refreshMetrics())
+		last = time.Now()
+	}
+}
+
+func (s *Server) refreshMetrics() *AdminMetrics {
+	classes := queue.CurrentClasses(context.Background(), s.broker)
+	metrics := &AdminMetrics{
+		SystemMetrics: SystemMetrics{
+			PriorityClasses: classes,
+			TenantMetrics:   newTenantMetrics(classes.Levels()),
+			WorkerMetrics:   make(map[string]WorkerInfo),
+		},
+		Tenants: make(map[string]TenantMetrics),
+	}
+
+	// Count each tenant's tasks, including those workers hold
+	tenants, err := tenant.List(context.Background(), s.broker)
+	if err != nil {
+		s.logger.Printf("Failed to load tenants: %v", err)
+	}
+	backend := queue.Current(context.Background(), s.broker)
+	collected := make(map[string]TenantMetrics, len(tenants))
+	for _, name := range tenants {
+		collected[name] = s.collectTenantMetrics(context.Background(), backend, classes.Levels(), name)
+	}
+
+	workers, _ := s.broker.HashGetAll(context.Background(), broker.WorkersKey)
+	metrics.ActiveWorkers = len(workers)
+
+	records, err := registry.Load(context.Background(), s.broker)
+	if err != nil {
+		s.logger.Printf("Failed to load worker records: %v", err)
+	}
 
-			metrics.WorkerMetrics[workerID] = workerInfo
+	for workerID, lastSeenStr := range workers {
+		lastSeen, _ := strconv.ParseInt(lastSeenStr, 10, 64)
+		assignedTasks, _ := s.broker.HashGetAll(context.Background(), broker.WorkerTasksKey(workerID))
+		processingTasks, _ := s.broker.HashGetAll(context.Background(), broker.WorkerProcessingKey(workerID))
+		completedTasks, _ := s.broker.HashGetAll(context.Background(), broker.WorkerResultsKey(workerID))
+
+		workerInfo := WorkerInfo{
+			ID:             workerID,
+			LastSeen:       time.Unix(lastSeen, 0),
+			TasksProcessed: uint64(len(completedTasks)),
+			ActiveTasks:    len(assignedTasks) + len(processingTasks),
+			Status:         "active",
+			Registration:   records[workerID],
 		}
 
-		s.metrics.Store("current", metrics)
+		holding := worker.NewHolding()
+		for _, taskStr := range assignedTasks {
+			holding.Add(taskStr)
+		}
+		for _, taskStr := range processingTasks {
+			holding.Add(taskStr)
+		}
+		workerInfo.Load = holding.Load
+		for name, held := range holding.Tenants {
+			tm := collected[name]
+			tm.RunningTasks += int64(held)
+			collected[name] = tm
+		}
+		if record := records[workerID]; record != nil {
+			workerInfo.Capacity = record.CapacityUnits()
+			workerInfo.Reserved = record.ReservedUnits()
+			workerInfo.ReservedInUse = holding.ReservedInUse(record)
+		}
 
-		// Logging current state
-		s.logger.Printf("Current State - Active Workers: %d, Total Tasks: %d, Processed: %d, Failed: %d",
-			metrics.ActiveWorkers,
-			metrics.TotalTasks,
-			metrics.ProcessedTasks,
-			metrics.FailedTasks)
+		if time.Since(workerInfo.LastSeen) > 30*time.Second {
+			workerInfo.Status = "inactive"
+		} else if workerInfo.Registration != nil && workerInfo.Registration.Draining {
+			workerInfo.Status = "draining"
+		}
 
-		for priority, length := range metrics.QueueLengths {
-			if length > 0 {
-				s.logger.Printf("Priority %d queue length: %d", priority, length)
-			}
+		metrics.WorkerMetrics[workerID] = workerInfo
+	}
+
+	for name, tm := range collected {
+		if tm.QueueLengths == nil {
+			tm.QueueLengths = newTenantMetrics(classes.Levels()).QueueLengths
+		}
+		metrics.Tenants[name] = tm
+		metrics.TenantMetrics.add(tm)
+	}
+
+	metrics.Scheduling = s.collectSchedulingMetrics(context.Background())
+
+	return metrics
+}
+
+func (s *Server) logState(metrics *AdminMetrics) {
+	// Logging current state
+	s.logger.Printf("Current State - Active Workers: %d, Total Tasks: %d, Processed: %d, Failed: %d",
+		metrics.ActiveWorkers,
+		metrics.TotalTasks,
+		metrics.ProcessedTasks,
+		metrics.FailedTasks)
+
+	for priority, length := range metrics.QueueLengths {
+		if length > 0 {
+			s.logger.Printf("Priority %d queue length: %d", priority, length)
 		}
 	}
 }
 
+func (s *Server) collectSchedulingMetrics(ctx context.Context) SchedulingMetrics {
+	scheduling := SchedulingMetrics{
+		Strategies: make(map[string]StrategyMetrics),
+	}
+	scheduling.Strategy, _ = s.broker.Get(ctx, coordinator.StrategyKey)
+
+	strategies, _ := s.broker.SetMembers(ctx, coordinator.StrategiesKey)
+	for _, name := range strategies {
+		stats, _ := s.broker.HashGetAll(ctx, coordinator.StrategyStatsKey(name))
+		counter := func(field string) int64 {
+			n, _ := strconv.ParseInt(stats[field], 10, 64)
+			return n
+		}
+
+		sm := StrategyMetrics{
+			Assigned:          counter("assigned"),
+			Completed:         counter("completed"),
+			Failed:            counter("failed"),
+			Preempted:         counter("preempted"),
+			AssignedPerWorker: make(map[string]int64),
+		}
+		if finished := sm.Completed + sm.Failed; finished > 0 {
+			sm.AvgQueueWaitMs = float64(counter("queue_wait_ms")) / float64(finished)
+			sm.AvgProcessingMs = float64(counter("processing_ms")) / float64(finished)
+		}
+
+		perWorker, _ := s.broker.HashGetAll(ctx, coordinator.StrategyAssignmentsKey(name))
+		for workerID, count := range perWorker {
+			sm.AssignedPerWorker[workerID], _ = strconv.ParseInt(count, 10, 64)
+		}
+
+		scheduling.Strategies[name] = sm
+	}
+
+	rateLimits, err := ratelimit.LoadStats(ctx, s.broker)
+	if err != nil {
+		s.logger.Printf("Failed to load rate limits: %v", err)
+	}
+	scheduling.RateLimits = rateLimits
+
+	return scheduling
+}
+
+// handleDebug dumps the raw state of the tenant the request is made for,
+// including its tasks held by workers.
 func (s *Server) handleDebug(w http.ResponseWriter, r *http.Request) {
-	// Your existing debug handler code
+	name, ok := s.tenantOf(w, r)
+	if !ok {
+		return
+	}
+
 	ctx := context.Background()
 	debug := make(map[string]interface{})
+	debug["tenant"] = name
 
-	for priority := 1; priority <= 10; priority++ {
-		queueKey := fmt.Sprintf("tasks:priority:%d", priority)
-		tasks, err := s.redis.ZRange(ctx, queueKey, 0, -1).Result()
+	for _, priority := range queue.CurrentClasses(ctx, s.broker).Levels() {
+		tasks, err := s.broker.SortedRange(ctx, queue.PriorityKey(name, priority), 0, -1)
 		if err == nil {
 			debug[fmt.Sprintf("queue_%d", priority)] = tasks
 		}
+		if length, err := s.broker.StreamLen(ctx, queue.StreamKey(name, priority)); err == nil && length > 0 {
+			debug[fmt.Sprintf("stream_%d_length", priority)] = length
+		}
 	}
 
-	workers, _ := s.redis.HGetAll(ctx, "workers").Result()
+	workers, _ := s.broker.HashGetAll(ctx, broker.WorkersKey)
+	records, _ := registry.Load(ctx, s.broker)
 	workerStates := make(map[string]interface{})
 
 	for workerID := range workers {
 		state := make(map[string]interface{})
-		tasks, _ := s.redis.HGetAll(ctx, fmt.Sprintf("worker:%s:tasks", workerID)).Result()
-		state["assigned_tasks"] = tasks
-		processing, _ := s.redis.HGetAll(ctx, fmt.Sprintf("worker:%s:processing", workerID)).Result()
-		state["processing_tasks"] = processing
-		completed, _ := s.redis.HGetAll(ctx, fmt.Sprintf("worker:%s:results", workerID)).Result()
-		state["completed_tasks"] = completed
+		state["registration"] = records[workerID]
+		tasks, _ := s.broker.HashGetAll(ctx, broker.WorkerTasksKey(workerID))
+		state["assigned_tasks"] = ownedBy(name, tasks)
+		processing, _ := s.broker.HashGetAll(ctx, broker.WorkerProcessingKey(workerID))
+		state["processing_tasks"] = ownedBy(name, processing)
+		completed, _ := s.broker.HashGetAll(ctx, broker.WorkerResultsKey(workerID))
+		state["completed_tasks"] = ownedBy(name, completed)
 		workerStates[workerID] = state
 	}
 	debug["workers"] = workerStates
 
-	results, _ := s.redis.HGetAll(ctx, "results").Result()
+	results, _ := s.broker.HashGetAll(ctx, tenant.ResultsKey(name))
 	debug["results"] = results
 
-	failed, _ := s.redis.HGetAll(ctx, "failed_tasks").Result()
+	failed, _ := s.broker.HashGetAll(ctx, tenant.FailedKey(name))
 	debug["failed_tasks"] = failed
 
+	unschedulable, _ := s.broker.HashGetAll(ctx, tenant.UnschedulableKey(name))
+	debug["unschedulable_tasks"] = unschedulable
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(debug)
 }
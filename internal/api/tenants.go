@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/coordinator"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/ratelimit"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+const (
+	// TenantHeader names the tenant a request is made for. Requests
+	// without it act for the default tenant.
+	TenantHeader = "X-Tenant"
+	// AdminTokenHeader carries the token the admin endpoints require.
+	AdminTokenHeader = "X-Admin-Token"
+)
+
+// TenantMetrics counts one tenant's tasks, or all tenants' in the admin
+// view.
+type TenantMetrics struct {
+	TotalTasks         int64         `json:"totalTasks"`
+	ProcessedTasks     int64         `json:"processedTasks"`
+	FailedTasks        int64         `json:"failedTasks"`
+	UnschedulableTasks int64         `json:"unschedulableTasks"`
+	RunningTasks       int64         `json:"runningTasks"`
+	QueueLengths       map[int]int64 `json:"queueLengths"`
+	// Tasks that missed their deadline, by type and submitted priority
+	DeadlineMisses []tenant.DeadlineMisses `json:"deadlineMisses"`
+}
+
+// AdminMetrics is the cluster view: the totals across tenants, and each
+// tenant's share.
+type AdminMetrics struct {
+	SystemMetrics
+	Tenants map[string]TenantMetrics `json:"tenants"`
+}
+
+// newTenantMetrics starts metrics with an empty queue for each level.
+func newTenantMetrics(levels []int) TenantMetrics {
+	tm := TenantMetrics{
+		QueueLengths:   make(map[int]int64),
+		DeadlineMisses: []tenant.DeadlineMisses{},
+	}
+	for _, priority := range levels {
+		tm.QueueLengths[priority] = 0
+	}
+	return tm
+}
+
+func (tm *TenantMetrics) add(other TenantMetrics) {
+	tm.TotalTasks += other.TotalTasks
+	tm.ProcessedTasks += other.ProcessedTasks
+	tm.FailedTasks += other.FailedTasks
+	tm.UnschedulableTasks += other.UnschedulableTasks
+	tm.RunningTasks += other.RunningTasks
+	for priority, length := range other.QueueLengths {
+		tm.QueueLengths[priority] += length
+	}
+
+	for _, miss := range other.DeadlineMisses {
+		merged := false
+		for i := range tm.DeadlineMisses {
+			m := &tm.DeadlineMisses[i]
+			if m.Type == miss.Type && m.Priority == miss.Priority {
+				m.Expired += miss.Expired
+				m.Demoted += miss.Demoted
+				m.Late += miss.Late
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			tm.DeadlineMisses = append(tm.DeadlineMisses, miss)
+		}
+	}
+	tenant.SortDeadlineMisses(tm.DeadlineMisses)
+}
+
+// forTenant narrows the cluster view down to what one tenant may see.
+// Workers are shared by all tenants, so they are reported as they are.
+func (m *AdminMetrics) forTenant(name string) *SystemMetrics {
+	view := m.SystemMetrics
+	view.Tenant = name
+	view.TenantMetrics = newTenantMetrics(m.PriorityClasses.Levels())
+	if tm, ok := m.Tenants[name]; ok {
+		view.TenantMetrics = tm
+	}
+	return &view
+}
+
+// tenantOf returns the tenant the request is made for, answering the
+// request itself if the name is invalid.
+func (s *Server) tenantOf(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := tenant.Name(r.Header.Get(TenantHeader))
+	if err := tenant.Validate(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// requireAdmin checks the admin token, answering the request itself if
+// it is missing or wrong. Without a configured token the admin endpoints
+// are disabled.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		http.Error(w, "Admin endpoints are disabled, start the server with an admin token", http.StatusForbidden)
+		return false
+	}
+	token := r.Header.Get(AdminTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// requireWorkerAdmin guards the worker lifecycle endpoints, which start,
+// list, stop, drain, restart and reconfigure workers. With an admin token
+// configured they require it like the admin endpoints; without one they
+// stay open, so the workers a server runs can always be stopped.
+func (s *Server) requireWorkerAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		return true
+	}
+	return s.requireAdmin(w, r)
+}
+
+func (s *Server) collectTenantMetrics(ctx context.Context, backend string, levels []int, name string) TenantMetrics {
+	tm := newTenantMetrics(levels)
+	for _, priority := range levels {
+		length, err := queue.Length(ctx, s.broker, backend, name, priority)
+		if err == nil {
+			tm.QueueLengths[priority] = length
+			tm.TotalTasks += length
+		}
+	}
+
+	tm.ProcessedTasks, _ = s.broker.HashLen(ctx, tenant.ResultsKey(name))
+	tm.FailedTasks, _ = s.broker.HashLen(ctx, tenant.FailedKey(name))
+	tm.UnschedulableTasks, _ = s.broker.HashLen(ctx, tenant.UnschedulableKey(name))
+	if misses, err := tenant.LoadDeadlineMisses(ctx, s.broker, name); err == nil {
+		tm.DeadlineMisses = misses
+	}
+	return tm
+}
+
+// ownedBy keeps the tasks or results in entries that belong to a tenant.
+func ownedBy(name string, entries map[string]string) map[string]string {
+	owned := make(map[string]string)
+	for id, data := range entries {
+		var owner struct {
+			Tenant string `json:"tenant"`
+		}
+		if err := json.Unmarshal([]byte(data), &owner); err == nil && tenant.Name(owner.Tenant) == name {
+			owned[id] = data
+		}
+	}
+	return owned
+}
+
+func (s *Server) handleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	metrics, ok := s.metrics.Load("current")
+	if !ok {
+		http.Error(w, "No metrics available", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// handleAdminReset clears the state of every tenant and the workers.
+func (s *Server) handleAdminReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	ctx := context.Background()
+	tenants, err := tenant.List(ctx, s.broker)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reset system: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Clear all task queues, including streams and their consumer groups
+	if err := queue.Clear(ctx, s.broker, tenants); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reset queues: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Clear worker data
+	var keys []string
+	workers, _ := s.broker.HashGetAll(ctx, broker.WorkersKey)
+	for workerID := range workers {
+		keys = append(keys, broker.WorkerKeys(workerID)...)
+	}
+	left, _ := s.broker.SetMembers(ctx, worker.LeftKey)
+	for _, workerID := range left {
+		keys = append(keys, broker.WorkerResultsKey(workerID))
+	}
+
+	// Clear tenant results and usage, but keep their quotas
+	for _, name := range tenants {
+		keys = append(keys, tenant.Keys(name)...)
+		keys = append(keys, tenant.UsageKeys(name)...)
+	}
+
+	// Clear global keys
+	keys = append(keys,
+		tenant.Key,
+		tenant.ThrottledKey,
+		broker.WorkersKey,
+		registry.InfoKey,
+		worker.DrainingKey,
+		worker.LeftKey,
+	)
+
+	// Clear assignment statistics
+	strategies, _ := s.broker.SetMembers(ctx, coordinator.StrategiesKey)
+	for _, name := range strategies {
+		keys = append(keys, coordinator.StrategyStatsKey(name), coordinator.StrategyAssignmentsKey(name))
+	}
+	keys = append(keys, coordinator.StrategiesKey, coordinator.SharesKey)
+
+	// Clear throttling statistics, but keep the rate limits
+	keys = append(keys, ratelimit.StatsKey)
+
+	if err := s.broker.Delete(ctx, keys...); err != nil {
+		http.Error(w, "Failed to reset system", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "System reset successful",
+	})
+}
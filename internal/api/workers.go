@@ -0,0 +1,330 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// managedWorker is a worker running inside the API server process.
+type managedWorker struct {
+	worker    *worker.Worker
+	cancel    context.CancelFunc
+	config    StartWorkerRequest
+	startedAt time.Time
+	stopping  int32
+}
+
+type ManagedWorkerInfo struct {
+	ID        string             `json:"id"`
+	Config    StartWorkerRequest `json:"config"`
+	StartedAt time.Time          `json:"startedAt"`
+	Status    string             `json:"status"`
+}
+
+func (mw *managedWorker) info() ManagedWorkerInfo {
+	status := "running"
+	if mw.worker.Draining() || atomic.LoadInt32(&mw.stopping) == 1 {
+		status = "draining"
+	}
+
+	return ManagedWorkerInfo{
+		ID:        mw.worker.ID(),
+		Config:    mw.config,
+		StartedAt: mw.startedAt,
+		Status:    status,
+	}
+}
+
+// stop cancels the worker, which makes it drain, and reports false if a
+// stop was already in progress.
+func (mw *managedWorker) stop() bool {
+	if !atomic.CompareAndSwapInt32(&mw.stopping, 0, 1) {
+		return false
+	}
+	mw.cancel()
+	return true
+}
+
+func (s *Server) startWorker(id string, cfg StartWorkerRequest) *managedWorker {
+	opts := []worker.Option{
+		worker.WithLogger(log.New(os.Stdout, "[Worker] ", log.LstdFlags)),
+		worker.WithBroker(s.broker),
+		worker.WithPoolSize(cfg.PoolSize),
+		worker.WithWorkStealing(cfg.EnableSteal),
+		worker.WithLabels(cfg.Labels),
+		worker.WithCapacity(cfg.Capacity),
+		worker.WithReservation(cfg.ReservedSlots, cfg.ReservedPriority),
+	}
+	if cfg.MaxWorkers > 0 {
+		opts = append(opts, worker.WithAutoScaling(cfg.MinWorkers, cfg.MaxWorkers))
+	}
+	if id != "" {
+		opts = append(opts, worker.WithID(id))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mw := &managedWorker{
+		worker:    worker.NewWorker(opts...),
+		cancel:    cancel,
+		config:    cfg,
+		startedAt: time.Now(),
+	}
+	s.workers.Store(mw.worker.ID(), mw)
+
+	go func() {
+		defer s.workers.CompareAndDelete(mw.worker.ID(), mw)
+		defer cancel()
+		if err := mw.worker.Start(ctx); err != nil && err != context.Canceled {
+			s.logger.Printf("Worker %s failed: %v", mw.worker.ID(), err)
+		}
+	}()
+
+	return mw
+}
+
+// replaceWorker drains a managed worker and starts it again under the same
+// ID with the given configuration.
+func (s *Server) replaceWorker(mw *managedWorker, cfg StartWorkerRequest) {
+	<-mw.worker.Done()
+	s.startWorker(mw.worker.ID(), cfg)
+	s.logger.Printf("Worker %s restarted with pool size %d", mw.worker.ID(), cfg.PoolSize)
+}
+
+func (s *Server) managedWorker(id string) (*managedWorker, bool) {
+	value, ok := s.workers.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(*managedWorker), true
+}
+
+// Shutdown drains every worker started by this server and then stops
+// accepting API requests.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var wg sync.WaitGroup
+	s.workers.Range(func(key, value interface{}) bool {
+		mw := value.(*managedWorker)
+		mw.stop()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-mw.worker.Done():
+			case <-ctx.Done():
+				s.logger.Printf("Gave up waiting for worker %s to drain", mw.worker.ID())
+			}
+		}()
+		return true
+	})
+	wg.Wait()
+
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
+}
+
+func decodeWorkerConfig(r *http.Request, classes queue.Classes) (StartWorkerRequest, error) {
+	var req StartWorkerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, err
+	}
+
+	if req.PoolSize <= 0 {
+		req.PoolSize = 1
+	}
+	if req.MaxWorkers > 0 && req.MinWorkers > req.MaxWorkers {
+		return req, fmt.Errorf("minWorkers (%d) exceeds maxWorkers (%d)", req.MinWorkers, req.MaxWorkers)
+	}
+	if req.ReservedSlots < 0 || req.ReservedSlots > max(req.PoolSize, req.MaxWorkers) {
+		return req, fmt.Errorf("reservedSlots must be between 0 and the pool size")
+	}
+	if req.ReservedSlots > 0 {
+		if _, ok := classes.Class(req.ReservedPriority); !ok {
+			return req, fmt.Errorf("reservedPriority must be the level of a priority class (%s)", classes)
+		}
+	}
+
+	return req, nil
+}
+
+func (s *Server) handleStartWorker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireWorkerAdmin(w, r) {
+		return
+	}
+
+	req, err := decodeWorkerConfig(r, queue.CurrentClasses(r.Context(), s.broker))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	mw := s.startWorker("", req)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "Worker started",
+		"id":     mw.worker.ID(),
+		"config": req,
+	})
+}
+
+func (s *Server) handleStopWorker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireWorkerAdmin(w, r) {
+		return
+	}
+
+	workerID := r.URL.Query().Get("id")
+	if workerID == "" {
+		http.Error(w, "Worker ID required", http.StatusBadRequest)
+		return
+	}
+
+	mw, ok := s.managedWorker(workerID)
+	if !ok {
+		// Not ours, ask it to drain through Redis
+		if err := worker.RequestDrain(r.Context(), s.broker, workerID); err != nil {
+			http.Error(w, "Failed to request drain", http.StatusInternalServerError)
+			return
+		}
+	} else if !mw.stop() {
+		http.Error(w, "Worker is already stopping", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "Worker stopping",
+		"id":     workerID,
+	})
+}
+
+func (s *Server) handleManagedWorkers(w http.ResponseWriter, r *http.Request) {
+	if !s.requireWorkerAdmin(w, r) {
+		return
+	}
+
+	workers := []ManagedWorkerInfo{}
+	s.workers.Range(func(key, value interface{}) bool {
+		workers = append(workers, value.(*managedWorker).info())
+		return true
+	})
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].StartedAt.Before(workers[j].StartedAt)
+	})
+
+	json.NewEncoder(w).Encode(workers)
+}
+
+func (s *Server) handleRestartWorker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireWorkerAdmin(w, r) {
+		return
+	}
+
+	mw, ok := s.managedWorker(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Managed worker not found", http.StatusNotFound)
+		return
+	}
+
+	if !mw.stop() {
+		http.Error(w, "Worker is already stopping", http.StatusConflict)
+		return
+	}
+	go s.replaceWorker(mw, mw.config)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "Worker restarting",
+		"id":     mw.worker.ID(),
+		"config": mw.config,
+	})
+}
+
+func (s *Server) handleReconfigureWorker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireWorkerAdmin(w, r) {
+		return
+	}
+
+	mw, ok := s.managedWorker(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Managed worker not found", http.StatusNotFound)
+		return
+	}
+
+	req, err := decodeWorkerConfig(r, queue.CurrentClasses(r.Context(), s.broker))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !mw.stop() {
+		http.Error(w, "Worker is already stopping", http.StatusConflict)
+		return
+	}
+	go s.replaceWorker(mw, req)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "Worker reconfiguring",
+		"id":     mw.worker.ID(),
+		"config": req,
+	})
+}
+
+func (s *Server) handleDrainWorker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireWorkerAdmin(w, r) {
+		return
+	}
+
+	workerID := r.URL.Query().Get("id")
+	if workerID == "" {
+		http.Error(w, "Worker ID required", http.StatusBadRequest)
+		return
+	}
+
+	// The worker picks the request up itself, whichever process it runs in
+	if err := worker.RequestDrain(r.Context(), s.broker, workerID); err != nil {
+		http.Error(w, "Failed to request drain", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "Worker draining",
+		"id":     workerID,
+	})
+}
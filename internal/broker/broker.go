@@ -0,0 +1,193 @@
+// Package broker is the storage and messaging layer every component runs
+// on. Redis is the default; the in-memory and file brokers run the whole
+// system inside one process with no external dependencies, the file
+// broker keeping its state across restarts.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	Redis  = "redis"
+	Memory = "memory"
+	File   = "file"
+)
+
+var (
+	// ErrNotFound is returned when a key, field or queued item does not
+	// exist.
+	ErrNotFound = errors.New("not found")
+	// ErrNoGroup is returned when reading a stream through a consumer group
+	// that does not exist, e.g. after the streams were reset.
+	ErrNoGroup = errors.New("consumer group does not exist")
+)
+
+// Broker covers every queue and state operation the system performs.
+// Keys share one namespace across all structures, as in Redis.
+type Broker interface {
+	Ping(ctx context.Context) error
+	Close() error
+
+	// Plain values
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string) error
+	Delete(ctx context.Context, keys ...string) error
+	// NextSeq returns a number greater than any it returned for key
+	// before: the broker's clock in microseconds, or one more than the
+	// last number if the clock has not passed it.
+	NextSeq(ctx context.Context, key string) (int64, error)
+	// Keys returns every key starting with prefix, of any structure.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+
+	// Hashes
+	HashGet(ctx context.Context, key, field string) (string, error)
+	HashGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HashValues returns the values of several hashes in one round trip.
+	HashValues(ctx context.Context, keys ...string) ([][]string, error)
+	HashSet(ctx context.Context, key, field, value string) error
+	// HashSetNew sets field only if it does not exist yet and reports
+	// whether it did.
+	HashSetNew(ctx context.Context, key, field, value string) (bool, error)
+	HashDelete(ctx context.Context, key string, fields ...string) (int64, error)
+	HashLen(ctx context.Context, key string) (int64, error)
+	HashExists(ctx context.Context, key, field string) (bool, error)
+	// HashIncr adds each delta to its field.
+	HashIncr(ctx context.Context, key string, deltas map[string]int64) error
+	// HashMove sets field in to and removes it from from, atomically
+	// where the broker can, otherwise setting before deleting.
+	HashMove(ctx context.Context, from, to, field, value string) error
+
+	// TakeToken takes one token from the bucket at key, which holds up to
+	// burst tokens and refills at rate tokens per second. When it is empty
+	// nothing is taken and the wait until a token is available returned.
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error)
+
+	// Sets
+	SetAdd(ctx context.Context, key string, members ...string) error
+	SetRemove(ctx context.Context, key string, members ...string) error
+	SetMembers(ctx context.Context, key string) ([]string, error)
+	SetContains(ctx context.Context, key, member string) (bool, error)
+
+	// Sorted sets, ordered by score and then by member
+	SortedAdd(ctx context.Context, key, member string, score float64) error
+	// SortedRange returns members by rank, stop -1 meaning the last one.
+	SortedRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	SortedRemove(ctx context.Context, key, member string) (bool, error)
+	// SortedRemoveBelow drops the members scored at most max.
+	SortedRemoveBelow(ctx context.Context, key string, max float64) error
+	SortedLen(ctx context.Context, key string) (int64, error)
+	// SortedPopMin pops the lowest member of the first non-empty key,
+	// waiting up to timeout for one to arrive. Without a timeout it
+	// returns ErrNotFound right away when all keys are empty.
+	SortedPopMin(ctx context.Context, timeout time.Duration, keys ...string) (*Popped, error)
+
+	// Task streams read through consumer groups
+	StreamAdd(ctx context.Context, stream, task string) (string, error)
+	StreamLen(ctx context.Context, stream string) (int64, error)
+	// StreamBacklog returns how many entries wait to be delivered to the
+	// group furthest behind, or the stream length when that is unknown.
+	StreamBacklog(ctx context.Context, stream string) (int64, error)
+	// StreamTrim drops the entries every group has been delivered and
+	// acknowledged, and returns how many it dropped.
+	StreamTrim(ctx context.Context, stream string) (int64, error)
+	// GroupCreate creates a group reading stream from the start, creating
+	// the stream as needed. Existing groups are left alone.
+	GroupCreate(ctx context.Context, stream, group string) error
+	// GroupRead delivers up to count new entries per stream to consumer,
+	// waiting up to block for any to arrive.
+	GroupRead(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error)
+	GroupAck(ctx context.Context, stream, group string, ids ...string) error
+	// GroupClaim resets the idle time of pending entries held by consumer.
+	GroupClaim(ctx context.Context, stream, group, consumer string, ids ...string) error
+	// GroupAutoClaim transfers up to count entries pending for at least
+	// minIdle to consumer. Entries trimmed from the stream come back with
+	// an empty Task.
+	GroupAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error)
+	GroupRemoveConsumer(ctx context.Context, stream, group, consumer string) error
+
+	// Pub/sub
+	Publish(ctx context.Context, channel, message string) error
+	Subscribe(ctx context.Context, channels ...string) Subscription
+}
+
+// Popped is a member taken from a sorted set.
+type Popped struct {
+	Key    string
+	Member string
+	Score  float64
+}
+
+// Entry is a task delivered from a stream.
+type Entry struct {
+	Stream string
+	ID     string
+	Task   string
+}
+
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// Subscription delivers the messages published on its channels until it
+// is closed.
+type Subscription interface {
+	Messages() <-chan Message
+	Close() error
+}
+
+func Kinds() []string {
+	return []string{Redis, Memory, File}
+}
+
+func ValidateKind(kind string) error {
+	switch kind {
+	case Redis, Memory, File:
+		return nil
+	default:
+		return fmt.Errorf("unknown broker %q (available: %s)", kind, strings.Join(Kinds(), ", "))
+	}
+}
+
+// Options selects and configures a broker.
+type Options struct {
+	Kind string
+	// Redis address list or URL, see ParseRedisURL
+	RedisURL string
+	// Directory the file broker keeps its state in
+	Dir string
+	// Prefix for every key and channel, see WithNamespace
+	Namespace string
+}
+
+// Open creates the broker described by opts.
+func Open(opts Options) (Broker, error) {
+	if err := ValidateNamespace(opts.Namespace); err != nil {
+		return nil, err
+	}
+
+	var b Broker
+	switch opts.Kind {
+	case Redis:
+		redisOpts, err := ParseRedisURL(opts.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis connection: %w", err)
+		}
+		b = NewRedis(redisOpts)
+	case Memory:
+		b = NewMemory()
+	case File:
+		var err error
+		if b, err = NewFile(opts.Dir); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ValidateKind(opts.Kind)
+	}
+	return WithNamespace(b, opts.Namespace), nil
+}
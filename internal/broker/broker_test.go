@@ -0,0 +1,461 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// brokers runs test against every broker. Redis is only covered when
+// DTPS_TEST_REDIS names a server, each run in a namespace of its own.
+func brokers(t *testing.T, test func(t *testing.T, b Broker)) {
+	t.Run("memory", func(t *testing.T) {
+		b := NewMemory()
+		defer b.Close()
+		test(t, b)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		b, err := NewFile(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFile: %v", err)
+		}
+		defer b.Close()
+		test(t, b)
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		url := os.Getenv("DTPS_TEST_REDIS")
+		if url == "" {
+			t.Skip("DTPS_TEST_REDIS not set")
+		}
+		opts, err := ParseRedisURL(url)
+		if err != nil {
+			t.Fatalf("ParseRedisURL: %v", err)
+		}
+		raw := NewRedis(opts)
+		defer raw.Close()
+		if err := raw.Ping(context.Background()); err != nil {
+			t.Skipf("Redis unavailable: %v", err)
+		}
+
+		b := WithNamespace(raw, fmt.Sprintf("test-%d", time.Now().UnixNano()))
+		defer func() {
+			ctx := context.Background()
+			if keys, err := b.Keys(ctx, ""); err == nil {
+				b.Delete(ctx, keys...)
+			}
+		}()
+		test(t, b)
+	})
+}
+
+func TestValues(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		if _, err := b.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get of a missing key: got %v, want ErrNotFound", err)
+		}
+		if err := b.Set(ctx, "key", "value"); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if got, err := b.Get(ctx, "key"); err != nil || got != "value" {
+			t.Fatalf("Get: got %q, %v", got, err)
+		}
+
+		keys, err := b.Keys(ctx, "k")
+		if err != nil || !reflect.DeepEqual(keys, []string{"key"}) {
+			t.Fatalf("Keys: got %v, %v", keys, err)
+		}
+
+		if err := b.Delete(ctx, "key"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := b.Get(ctx, "key"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get after Delete: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestNextSeq(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		start := time.Now().Add(-time.Minute).UnixMicro()
+		var last int64
+		for i := 0; i < 100; i++ {
+			seq, err := b.NextSeq(ctx, "seq")
+			if err != nil {
+				t.Fatalf("NextSeq: %v", err)
+			}
+			if seq <= last || seq < start {
+				t.Fatalf("NextSeq: got %d after %d, want more than both it and %d", seq, last, start)
+			}
+			last = seq
+		}
+
+		// Keeps increasing past a clock that falls behind
+		ahead := time.Now().Add(time.Hour).UnixMicro()
+		if err := b.Set(ctx, "seq", fmt.Sprint(ahead)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if seq, err := b.NextSeq(ctx, "seq"); err != nil || seq != ahead+1 {
+			t.Fatalf("NextSeq after the clock: got %d, %v, want %d", seq, err, ahead+1)
+		}
+	})
+}
+
+func TestHashes(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		if _, err := b.HashGet(ctx, "h", "f"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("HashGet of a missing field: got %v, want ErrNotFound", err)
+		}
+		if err := b.HashSet(ctx, "h", "a", "1"); err != nil {
+			t.Fatalf("HashSet: %v", err)
+		}
+
+		set, err := b.HashSetNew(ctx, "h", "a", "2")
+		if err != nil || set {
+			t.Fatalf("HashSetNew of an existing field: got %v, %v", set, err)
+		}
+		set, err = b.HashSetNew(ctx, "h", "b", "2")
+		if err != nil || !set {
+			t.Fatalf("HashSetNew of a new field: got %v, %v", set, err)
+		}
+
+		all, err := b.HashGetAll(ctx, "h")
+		if err != nil || !reflect.DeepEqual(all, map[string]string{"a": "1", "b": "2"}) {
+			t.Fatalf("HashGetAll: got %v, %v", all, err)
+		}
+		if n, err := b.HashLen(ctx, "h"); err != nil || n != 2 {
+			t.Fatalf("HashLen: got %d, %v", n, err)
+		}
+		if ok, err := b.HashExists(ctx, "h", "b"); err != nil || !ok {
+			t.Fatalf("HashExists: got %v, %v", ok, err)
+		}
+
+		if err := b.HashIncr(ctx, "counters", map[string]int64{"x": 2, "y": -1}); err != nil {
+			t.Fatalf("HashIncr: %v", err)
+		}
+		if err := b.HashIncr(ctx, "counters", map[string]int64{"x": 3}); err != nil {
+			t.Fatalf("HashIncr: %v", err)
+		}
+		counters, _ := b.HashGetAll(ctx, "counters")
+		if !reflect.DeepEqual(counters, map[string]string{"x": "5", "y": "-1"}) {
+			t.Fatalf("counters: got %v", counters)
+		}
+
+		if err := b.HashMove(ctx, "h", "other", "a", "moved"); err != nil {
+			t.Fatalf("HashMove: %v", err)
+		}
+		values, err := b.HashValues(ctx, "h", "other", "missing")
+		if err != nil {
+			t.Fatalf("HashValues: %v", err)
+		}
+		want := [][]string{{"2"}, {"moved"}, nil}
+		for i := range want {
+			if len(values[i]) != len(want[i]) || (len(want[i]) > 0 && values[i][0] != want[i][0]) {
+				t.Fatalf("HashValues: got %v, want %v", values, want)
+			}
+		}
+
+		if n, err := b.HashDelete(ctx, "h", "b", "missing"); err != nil || n != 1 {
+			t.Fatalf("HashDelete: got %d, %v", n, err)
+		}
+		if n, _ := b.HashLen(ctx, "h"); n != 0 {
+			t.Fatalf("HashLen after deleting every field: got %d", n)
+		}
+	})
+}
+
+func TestSets(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		if err := b.SetAdd(ctx, "s", "a", "b", "a"); err != nil {
+			t.Fatalf("SetAdd: %v", err)
+		}
+		members, err := b.SetMembers(ctx, "s")
+		sort.Strings(members)
+		if err != nil || !reflect.DeepEqual(members, []string{"a", "b"}) {
+			t.Fatalf("SetMembers: got %v, %v", members, err)
+		}
+		if err := b.SetRemove(ctx, "s", "a"); err != nil {
+			t.Fatalf("SetRemove: %v", err)
+		}
+		if ok, _ := b.SetContains(ctx, "s", "a"); ok {
+			t.Fatal("SetContains after SetRemove: got true")
+		}
+	})
+}
+
+func TestSortedSets(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		// Equal scores are ordered by member
+		for member, score := range map[string]float64{"c": 2, "b": 1, "a": 1, "d": 3} {
+			if err := b.SortedAdd(ctx, "z", member, score); err != nil {
+				t.Fatalf("SortedAdd: %v", err)
+			}
+		}
+		members, err := b.SortedRange(ctx, "z", 0, -1)
+		if err != nil || !reflect.DeepEqual(members, []string{"a", "b", "c", "d"}) {
+			t.Fatalf("SortedRange: got %v, %v", members, err)
+		}
+		if members, _ := b.SortedRange(ctx, "z", 1, 2); !reflect.DeepEqual(members, []string{"b", "c"}) {
+			t.Fatalf("SortedRange 1..2: got %v", members)
+		}
+
+		// Re-adding a member moves it
+		b.SortedAdd(ctx, "z", "a", 4)
+		if members, _ := b.SortedRange(ctx, "z", -1, -1); !reflect.DeepEqual(members, []string{"a"}) {
+			t.Fatalf("last member after rescoring: got %v", members)
+		}
+
+		if removed, err := b.SortedRemove(ctx, "z", "d"); err != nil || !removed {
+			t.Fatalf("SortedRemove: got %v, %v", removed, err)
+		}
+		if removed, _ := b.SortedRemove(ctx, "z", "d"); removed {
+			t.Fatal("SortedRemove of a missing member: got true")
+		}
+		if err := b.SortedRemoveBelow(ctx, "z", 1); err != nil {
+			t.Fatalf("SortedRemoveBelow: %v", err)
+		}
+		if n, err := b.SortedLen(ctx, "z"); err != nil || n != 2 {
+			t.Fatalf("SortedLen: got %d, %v", n, err)
+		}
+	})
+}
+
+func TestSortedPopMin(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		if _, err := b.SortedPopMin(ctx, 0, "empty"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("SortedPopMin of empty keys: got %v, want ErrNotFound", err)
+		}
+
+		// Keys are tried in order, members lowest score first
+		b.SortedAdd(ctx, "low", "x", 1)
+		b.SortedAdd(ctx, "high", "b", 2)
+		b.SortedAdd(ctx, "high", "a", 3)
+		for _, want := range []Popped{{"high", "b", 2}, {"high", "a", 3}, {"low", "x", 1}} {
+			popped, err := b.SortedPopMin(ctx, 0, "high", "low")
+			if err != nil || *popped != want {
+				t.Fatalf("SortedPopMin: got %+v, %v, want %+v", popped, err, want)
+			}
+		}
+
+		// A blocked pop is woken by a new member
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			b.SortedAdd(ctx, "late", "m", 1)
+		}()
+		popped, err := b.SortedPopMin(ctx, 2*time.Second, "late")
+		if err != nil || popped.Member != "m" {
+			t.Fatalf("blocking SortedPopMin: got %+v, %v", popped, err)
+		}
+	})
+}
+
+func TestStreams(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		if _, err := b.GroupRead(ctx, "g", "c1", []string{"s"}, 10, 0); !errors.Is(err, ErrNoGroup) {
+			t.Fatalf("GroupRead without a group: got %v, want ErrNoGroup", err)
+		}
+		if err := b.GroupCreate(ctx, "s", "g"); err != nil {
+			t.Fatalf("GroupCreate: %v", err)
+		}
+		if err := b.GroupCreate(ctx, "s", "g"); err != nil {
+			t.Fatalf("GroupCreate of an existing group: %v", err)
+		}
+
+		for _, task := range []string{"t1", "t2", "t3"} {
+			if _, err := b.StreamAdd(ctx, "s", task); err != nil {
+				t.Fatalf("StreamAdd: %v", err)
+			}
+		}
+		if n, _ := b.StreamLen(ctx, "s"); n != 3 {
+			t.Fatalf("StreamLen: got %d", n)
+		}
+		if n, _ := b.StreamBacklog(ctx, "s"); n != 3 {
+			t.Fatalf("StreamBacklog: got %d", n)
+		}
+
+		entries, err := b.GroupRead(ctx, "g", "c1", []string{"s"}, 2, 0)
+		if err != nil || len(entries) != 2 || entries[0].Task != "t1" || entries[1].Task != "t2" {
+			t.Fatalf("GroupRead: got %+v, %v", entries, err)
+		}
+		if n, _ := b.StreamBacklog(ctx, "s"); n != 1 {
+			t.Fatalf("StreamBacklog after delivery: got %d", n)
+		}
+
+		// Entries are delivered once per group
+		rest, _ := b.GroupRead(ctx, "g", "c2", []string{"s"}, 10, 0)
+		if len(rest) != 1 || rest[0].Task != "t3" {
+			t.Fatalf("second GroupRead: got %+v", rest)
+		}
+
+		if err := b.GroupAck(ctx, "s", "g", entries[0].ID); err != nil {
+			t.Fatalf("GroupAck: %v", err)
+		}
+
+		// Unacknowledged entries can be claimed by another consumer
+		claimed, err := b.GroupAutoClaim(ctx, "s", "g", "c3", 0, 10)
+		if err != nil || len(claimed) != 2 || claimed[0].Task != "t2" || claimed[1].Task != "t3" {
+			t.Fatalf("GroupAutoClaim: got %+v, %v", claimed, err)
+		}
+		if idle, _ := b.GroupAutoClaim(ctx, "s", "g", "c1", time.Hour, 10); len(idle) != 0 {
+			t.Fatalf("GroupAutoClaim of recently claimed entries: got %+v", idle)
+		}
+
+		// Only acknowledged entries are trimmed
+		if n, err := b.StreamTrim(ctx, "s"); err != nil || n != 1 {
+			t.Fatalf("StreamTrim: got %d, %v", n, err)
+		}
+		// The last delivered entry stays, as with XTRIM MINID
+		b.GroupAck(ctx, "s", "g", claimed[0].ID, claimed[1].ID)
+		if n, _ := b.StreamTrim(ctx, "s"); n != 1 {
+			t.Fatalf("StreamTrim after acknowledging: got %d", n)
+		}
+		if n, _ := b.StreamLen(ctx, "s"); n != 1 {
+			t.Fatalf("StreamLen after trimming: got %d", n)
+		}
+	})
+}
+
+func TestPubSub(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		sub := b.Subscribe(ctx, "news")
+		defer sub.Close()
+
+		// Redis subscribes asynchronously, so publish until one arrives
+		timeout := time.After(2 * time.Second)
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			b.Publish(ctx, "other", "ignored")
+			b.Publish(ctx, "news", "hello")
+			select {
+			case msg := <-sub.Messages():
+				if msg.Channel != "news" || msg.Payload != "hello" {
+					t.Fatalf("message: got %+v", msg)
+				}
+				return
+			case <-ticker.C:
+			case <-timeout:
+				t.Fatal("no message received")
+			}
+		}
+	})
+}
+
+func TestTakeToken(t *testing.T) {
+	brokers(t, func(t *testing.T, b Broker) {
+		ctx := context.Background()
+
+		// A full bucket serves the burst, then asks to wait
+		for i := 0; i < 2; i++ {
+			if wait, err := b.TakeToken(ctx, "bucket", 1, 2); err != nil || wait != 0 {
+				t.Fatalf("TakeToken %d: got %v, %v", i, wait, err)
+			}
+		}
+		wait, err := b.TakeToken(ctx, "bucket", 1, 2)
+		if err != nil || wait <= 0 || wait > time.Second {
+			t.Fatalf("TakeToken of an empty bucket: got %v, %v", wait, err)
+		}
+
+		time.Sleep(wait)
+		if wait, err := b.TakeToken(ctx, "bucket", 1, 2); err != nil || wait != 0 {
+			t.Fatalf("TakeToken after refilling: got %v, %v", wait, err)
+		}
+	})
+}
+
+func TestFileBrokerRestores(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	b, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+	b.HashSet(ctx, "h", "f", "v")
+	b.SortedAdd(ctx, "z", "m", 1)
+	b.StreamAdd(ctx, "s", "task")
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	b, err = NewFile(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer b.Close()
+	if v, err := b.HashGet(ctx, "h", "f"); err != nil || v != "v" {
+		t.Fatalf("restored hash: got %q, %v", v, err)
+	}
+	if members, _ := b.SortedRange(ctx, "z", 0, -1); !reflect.DeepEqual(members, []string{"m"}) {
+		t.Fatalf("restored sorted set: got %v", members)
+	}
+	if n, _ := b.StreamLen(ctx, "s"); n != 1 {
+		t.Fatalf("restored stream: got %d entries", n)
+	}
+}
+
+// TestFileBrokerJournal checks that changes are on disk once they return,
+// without a snapshot, and that a change the journal rejects is not applied.
+func TestFileBrokerJournal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	b, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+	b.HashSet(ctx, "h", "f", "v")
+	b.HashIncr(ctx, "counts", map[string]int64{"n": 2})
+	b.GroupCreate(ctx, "s", "g")
+	b.StreamAdd(ctx, "s", "task")
+	if _, err := b.GroupRead(ctx, "g", "c", []string{"s"}, 1, 0); err != nil {
+		t.Fatalf("GroupRead: %v", err)
+	}
+
+	// Replays the journal of the broker that is still open, as after a crash
+	restored, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	if v, err := restored.HashGet(ctx, "h", "f"); err != nil || v != "v" {
+		t.Fatalf("restored hash: got %q, %v", v, err)
+	}
+	if v, _ := restored.HashGet(ctx, "counts", "n"); v != "2" {
+		t.Fatalf("restored counter: got %q", v)
+	}
+	if entries, _ := restored.GroupAutoClaim(ctx, "s", "g", "c", 0, 10); len(entries) != 1 {
+		t.Fatalf("restored pending entries: got %v", entries)
+	}
+	restored.Close()
+
+	b.(*fileBroker).log.Close()
+	if err := b.Set(ctx, "k", "v"); err == nil {
+		t.Fatal("Set succeeded without a journal")
+	}
+	if _, err := b.Get(ctx, "k"); err != ErrNotFound {
+		t.Fatalf("change applied without a journal: %v", err)
+	}
+	b.Close()
+}
@@ -0,0 +1,669 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Journal operations, each recording the effect of one change
+const (
+	opSet                 = "set"     // key value
+	opDelete              = "del"     // keys...
+	opHashSet             = "hset"    // key field value [field value...]
+	opHashDelete          = "hdel"    // key fields...
+	opSetAdd              = "sadd"    // key members...
+	opSetRemove           = "srem"    // key members...
+	opSortedAdd           = "zadd"    // key member score
+	opSortedRemove        = "zrem"    // key members...
+	opStreamAdd           = "xadd"    // stream id task
+	opStreamTrim          = "xtrim"   // stream minID
+	opGroupCreate         = "xgroup"  // stream group
+	opGroupDeliver        = "xread"   // stream group consumer ids...
+	opGroupAck            = "xack"    // stream group ids...
+	opGroupClaim          = "xclaim"  // stream group consumer ids...
+	opGroupRemoveConsumer = "xdelcon" // stream group consumer
+)
+
+const (
+	journalFile  = "journal.log"
+	snapshotFile = "snapshot.json"
+
+	// How often the journal is checked for a snapshot
+	snapshotInterval = time.Second
+	// How many changes the journal collects before a snapshot replaces it
+	snapshotEvery = 10000
+)
+
+type record struct {
+	Seq  uint64   `json:"seq"`
+	Op   string   `json:"op"`
+	Args []string `json:"args"`
+}
+
+type fileBroker struct {
+	*memoryBroker
+	dir string
+
+	// Guarded by the memory broker's mu
+	log         *os.File
+	seq         uint64 // last change written to the journal
+	snapshotSeq uint64 // last change covered by the snapshot
+	err         error  // first journal write error, fails later changes
+
+	// Serialises syncs so that one of them covers every change written
+	// while the previous one ran
+	syncMu sync.Mutex
+	synced uint64 // last change flushed to disk
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFile returns a broker that keeps everything in process, like the
+// memory broker, and persists it to dir so it survives restarts. Every
+// change is appended to a journal before it is applied, and is flushed to
+// disk before the call that made it returns; the journal is folded into a
+// snapshot once it grows long.
+func NewFile(dir string) (Broker, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	f := &fileBroker{
+		memoryBroker: NewMemory().(*memoryBroker),
+		dir:          dir,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := f.replay(); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(f.path(journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	f.log = log
+	f.memoryBroker.journal = f.append
+
+	go f.run()
+	return f, nil
+}
+
+func (f *fileBroker) path(name string) string {
+	return filepath.Join(f.dir, name)
+}
+
+func (f *fileBroker) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close writes a final snapshot so the next start has no journal to
+// replay.
+func (f *fileBroker) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		<-f.done
+
+		f.mu.Lock()
+		err = f.snapshotLocked()
+		f.log.Close()
+		f.mu.Unlock()
+
+		f.memoryBroker.Close()
+	})
+	return err
+}
+
+// append writes a change to the journal. Called with mu held.
+func (f *fileBroker) append(op string, args ...string) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	line, err := json.Marshal(record{Seq: f.seq + 1, Op: op, Args: args})
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record: %w", err)
+	}
+	if _, err := f.log.Write(append(line, '\n')); err != nil {
+		// A gap in the journal would corrupt the replay, so stop here
+		f.err = fmt.Errorf("failed to write journal: %w", err)
+		return f.err
+	}
+	f.seq++
+	return nil
+}
+
+// sync flushes the journal up to the last change written so far. Callers
+// that arrive while a flush runs share the next one, so concurrent changes
+// are committed in groups.
+func (f *fileBroker) sync() error {
+	f.mu.Lock()
+	target := f.seq
+	covered := target <= f.snapshotSeq
+	f.mu.Unlock()
+	if covered {
+		return nil
+	}
+
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+	if f.synced >= target {
+		return nil
+	}
+
+	f.mu.Lock()
+	upto := f.seq
+	f.mu.Unlock()
+	if err := f.log.Sync(); err != nil {
+		f.mu.Lock()
+		if f.err == nil {
+			f.err = fmt.Errorf("failed to sync journal: %w", err)
+		}
+		err = f.err
+		f.mu.Unlock()
+		return err
+	}
+	f.synced = upto
+	return nil
+}
+
+// run takes snapshots until the broker is closed.
+func (f *fileBroker) run() {
+	defer close(f.done)
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		f.mu.Lock()
+		if f.seq-f.snapshotSeq >= snapshotEvery {
+			// Leaves the journal in place to retry next time on failure
+			f.snapshotLocked()
+		}
+		f.mu.Unlock()
+	}
+}
+
+// The changes below return only once they are on disk.
+
+func (f *fileBroker) Set(ctx context.Context, key, value string) error {
+	if err := f.memoryBroker.Set(ctx, key, value); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) NextSeq(ctx context.Context, key string) (int64, error) {
+	seq, err := f.memoryBroker.NextSeq(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return seq, f.sync()
+}
+
+func (f *fileBroker) Delete(ctx context.Context, keys ...string) error {
+	if err := f.memoryBroker.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) HashSet(ctx context.Context, key, field, value string) error {
+	if err := f.memoryBroker.HashSet(ctx, key, field, value); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) HashSetNew(ctx context.Context, key, field, value string) (bool, error) {
+	set, err := f.memoryBroker.HashSetNew(ctx, key, field, value)
+	if err != nil || !set {
+		return set, err
+	}
+	return true, f.sync()
+}
+
+func (f *fileBroker) HashDelete(ctx context.Context, key string, fields ...string) (int64, error) {
+	deleted, err := f.memoryBroker.HashDelete(ctx, key, fields...)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, f.sync()
+}
+
+func (f *fileBroker) HashIncr(ctx context.Context, key string, deltas map[string]int64) error {
+	if err := f.memoryBroker.HashIncr(ctx, key, deltas); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) HashMove(ctx context.Context, from, to, field, value string) error {
+	if err := f.memoryBroker.HashMove(ctx, from, to, field, value); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	wait, err := f.memoryBroker.TakeToken(ctx, key, rate, burst)
+	if err != nil {
+		return 0, err
+	}
+	return wait, f.sync()
+}
+
+func (f *fileBroker) SetAdd(ctx context.Context, key string, members ...string) error {
+	if err := f.memoryBroker.SetAdd(ctx, key, members...); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) SetRemove(ctx context.Context, key string, members ...string) error {
+	if err := f.memoryBroker.SetRemove(ctx, key, members...); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) SortedAdd(ctx context.Context, key, member string, score float64) error {
+	if err := f.memoryBroker.SortedAdd(ctx, key, member, score); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) SortedRemove(ctx context.Context, key, member string) (bool, error) {
+	removed, err := f.memoryBroker.SortedRemove(ctx, key, member)
+	if err != nil || !removed {
+		return removed, err
+	}
+	return true, f.sync()
+}
+
+func (f *fileBroker) SortedRemoveBelow(ctx context.Context, key string, max float64) error {
+	if err := f.memoryBroker.SortedRemoveBelow(ctx, key, max); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) SortedPopMin(ctx context.Context, timeout time.Duration, keys ...string) (*Popped, error) {
+	popped, err := f.memoryBroker.SortedPopMin(ctx, timeout, keys...)
+	if err != nil || popped == nil {
+		return popped, err
+	}
+	if err := f.sync(); err != nil {
+		return nil, err
+	}
+	return popped, nil
+}
+
+func (f *fileBroker) StreamAdd(ctx context.Context, key, task string) (string, error) {
+	id, err := f.memoryBroker.StreamAdd(ctx, key, task)
+	if err != nil {
+		return "", err
+	}
+	return id, f.sync()
+}
+
+func (f *fileBroker) StreamTrim(ctx context.Context, key string) (int64, error) {
+	trimmed, err := f.memoryBroker.StreamTrim(ctx, key)
+	if err != nil || trimmed == 0 {
+		return trimmed, err
+	}
+	return trimmed, f.sync()
+}
+
+func (f *fileBroker) GroupCreate(ctx context.Context, key, group string) error {
+	if err := f.memoryBroker.GroupCreate(ctx, key, group); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) GroupRead(ctx context.Context, group, consumer string, keys []string, count int64, block time.Duration) ([]Entry, error) {
+	entries, err := f.memoryBroker.GroupRead(ctx, group, consumer, keys, count, block)
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+	if err := f.sync(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (f *fileBroker) GroupAck(ctx context.Context, key, group string, ids ...string) error {
+	if err := f.memoryBroker.GroupAck(ctx, key, group, ids...); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) GroupClaim(ctx context.Context, key, group, consumer string, ids ...string) error {
+	if err := f.memoryBroker.GroupClaim(ctx, key, group, consumer, ids...); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+func (f *fileBroker) GroupAutoClaim(ctx context.Context, key, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error) {
+	entries, err := f.memoryBroker.GroupAutoClaim(ctx, key, group, consumer, minIdle, count)
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+	if err := f.sync(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (f *fileBroker) GroupRemoveConsumer(ctx context.Context, key, group, consumer string) error {
+	if err := f.memoryBroker.GroupRemoveConsumer(ctx, key, group, consumer); err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+type snapshot struct {
+	Seq     uint64                        `json:"seq"`
+	Values  map[string]string             `json:"values"`
+	Hashes  map[string]map[string]string  `json:"hashes"`
+	Sets    map[string][]string           `json:"sets"`
+	Sorted  map[string]map[string]float64 `json:"sorted"`
+	Streams map[string]*streamSnapshot    `json:"streams"`
+}
+
+type streamSnapshot struct {
+	LastID  string                    `json:"last_id"`
+	Entries [][2]string               `json:"entries"` // ID and task
+	Groups  map[string]*groupSnapshot `json:"groups"`
+}
+
+type groupSnapshot struct {
+	LastDelivered string            `json:"last_delivered"`
+	Pending       map[string]string `json:"pending"` // entry ID to consumer
+}
+
+// snapshotLocked writes the whole state to the snapshot file and empties
+// the journal. Called with mu held.
+func (f *fileBroker) snapshotLocked() error {
+	if f.seq == f.snapshotSeq {
+		return nil
+	}
+
+	m := f.memoryBroker
+	snap := &snapshot{
+		Seq:     f.seq,
+		Values:  m.values,
+		Hashes:  m.hashes,
+		Sets:    make(map[string][]string, len(m.sets)),
+		Sorted:  make(map[string]map[string]float64, len(m.sorted)),
+		Streams: make(map[string]*streamSnapshot, len(m.streams)),
+	}
+	for key, set := range m.sets {
+		for member := range set {
+			snap.Sets[key] = append(snap.Sets[key], member)
+		}
+	}
+	for key, z := range m.sorted {
+		snap.Sorted[key] = z.scores
+	}
+	for key, s := range m.streams {
+		ss := &streamSnapshot{
+			LastID:  s.lastID.String(),
+			Entries: make([][2]string, len(s.entries)),
+			Groups:  make(map[string]*groupSnapshot, len(s.groups)),
+		}
+		for i, entry := range s.entries {
+			ss.Entries[i] = [2]string{entry.id.String(), entry.task}
+		}
+		for name, g := range s.groups {
+			gs := &groupSnapshot{
+				LastDelivered: g.lastDelivered.String(),
+				Pending:       make(map[string]string, len(g.pending)),
+			}
+			for id, pending := range g.pending {
+				gs.Pending[id.String()] = pending.consumer
+			}
+			ss.Groups[name] = gs
+		}
+		snap.Streams[key] = ss
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := writeFileSync(f.path(snapshotFile), data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	// Once the snapshot is in place the journal is redundant. Should the
+	// truncate not happen, replay skips the records the snapshot covers.
+	f.snapshotSeq = f.seq
+	if err := f.log.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	return nil
+}
+
+// writeFileSync replaces name with data without ever leaving a partial
+// file behind.
+func writeFileSync(name string, data []byte) error {
+	tmp := name + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (f *fileBroker) loadSnapshot() error {
+	data, err := os.ReadFile(f.path(snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	m := f.memoryBroker
+	for key, value := range snap.Values {
+		m.values[key] = value
+	}
+	for key, hash := range snap.Hashes {
+		m.hashes[key] = hash
+	}
+	for key, members := range snap.Sets {
+		set := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			set[member] = struct{}{}
+		}
+		m.sets[key] = set
+	}
+	for key, scores := range snap.Sorted {
+		z := newSortedSet()
+		for member, score := range scores {
+			z.add(member, score)
+		}
+		m.sorted[key] = z
+	}
+
+	// Delivery times are not kept, pending entries count as idle from now
+	now := time.Now()
+	for key, ss := range snap.Streams {
+		s := m.streamLocked(key)
+		s.lastID = parseEntryID(ss.LastID)
+		for _, entry := range ss.Entries {
+			s.entries = append(s.entries, streamEntry{id: parseEntryID(entry[0]), task: entry[1]})
+		}
+		for name, gs := range ss.Groups {
+			g := &consumerGroup{
+				lastDelivered: parseEntryID(gs.LastDelivered),
+				pending:       make(map[entryID]*pendingEntry, len(gs.Pending)),
+			}
+			for id, consumer := range gs.Pending {
+				g.pending[parseEntryID(id)] = &pendingEntry{consumer: consumer, delivered: now}
+			}
+			s.groups[name] = g
+		}
+	}
+
+	f.seq = snap.Seq
+	f.snapshotSeq = snap.Seq
+	return nil
+}
+
+// replay applies the journal records written after the snapshot. A record
+// cut short by a crash is dropped from the end of the journal.
+func (f *fileBroker) replay() error {
+	file, err := os.OpenFile(f.path(journalFile), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read journal: %w", err)
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("corrupt journal record at offset %d: %w", offset, err)
+		}
+		if rec.Seq > f.seq {
+			if err := f.apply(rec); err != nil {
+				return fmt.Errorf("failed to replay journal record %d: %w", rec.Seq, err)
+			}
+			f.seq = rec.Seq
+		}
+		offset += int64(len(line))
+	}
+}
+
+// apply redoes a journaled change. The journal is not attached yet, so
+// nothing is written back.
+func (f *fileBroker) apply(rec record) error {
+	ctx := context.Background()
+	m := f.memoryBroker
+	args := rec.Args
+	if len(args) == 0 {
+		return fmt.Errorf("%s without arguments", rec.Op)
+	}
+
+	switch rec.Op {
+	case opSet:
+		return m.Set(ctx, args[0], args[1])
+	case opDelete:
+		return m.Delete(ctx, args...)
+	case opHashSet:
+		for i := 1; i+1 < len(args); i += 2 {
+			m.HashSet(ctx, args[0], args[i], args[i+1])
+		}
+	case opHashDelete:
+		_, err := m.HashDelete(ctx, args[0], args[1:]...)
+		return err
+	case opSetAdd:
+		return m.SetAdd(ctx, args[0], args[1:]...)
+	case opSetRemove:
+		return m.SetRemove(ctx, args[0], args[1:]...)
+	case opSortedAdd:
+		score, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return err
+		}
+		return m.SortedAdd(ctx, args[0], args[1], score)
+	case opSortedRemove:
+		for _, member := range args[1:] {
+			m.SortedRemove(ctx, args[0], member)
+		}
+	case opStreamAdd:
+		s := m.streamLocked(args[0])
+		id := parseEntryID(args[1])
+		if s.lastID.less(id) {
+			s.lastID = id
+			s.entries = append(s.entries, streamEntry{id: id, task: args[2]})
+		}
+	case opStreamTrim:
+		if s, ok := m.streams[args[0]]; ok {
+			s.trimLocked(parseEntryID(args[1]))
+		}
+	case opGroupCreate:
+		return m.GroupCreate(ctx, args[0], args[1])
+	case opGroupDeliver:
+		g, err := m.groupLocked(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, id := range args[3:] {
+			entry := parseEntryID(id)
+			if g.lastDelivered.less(entry) {
+				g.lastDelivered = entry
+			}
+			g.pending[entry] = &pendingEntry{consumer: args[2], delivered: now}
+		}
+	case opGroupAck:
+		return m.GroupAck(ctx, args[0], args[1], args[2:]...)
+	case opGroupClaim:
+		return m.GroupClaim(ctx, args[0], args[1], args[2], args[3:]...)
+	case opGroupRemoveConsumer:
+		return m.GroupRemoveConsumer(ctx, args[0], args[1], args[2])
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package broker
+
+import "fmt"
+
+// Keys of the shared task and worker state. Queue and stream keys are laid
+// out by the queue package, result stores by the tenant package.
+//
+// Keys that are used together carry the same hash tag, the part in braces,
+// so they land in the same Redis Cluster slot: everything about one worker
+// is tagged with its ID and everything about one task with the task's.
+const (
+	// Worker ID to the Unix time of its last heartbeat
+	WorkersKey = "workers"
+	// Worker ID to its latest metrics snapshot
+	MetricsKey = "worker:metrics"
+)
+
+// WorkerTasksKey holds the tasks assigned to a worker but not accepted yet.
+func WorkerTasksKey(workerID string) string {
+	return fmt.Sprintf("worker:{%s}:tasks", workerID)
+}
+
+// WorkerProcessingKey holds the tasks a worker has accepted.
+func WorkerProcessingKey(workerID string) string {
+	return fmt.Sprintf("worker:{%s}:processing", workerID)
+}
+
+// WorkerResultsKey holds a worker's results until the coordinator
+// collects them.
+func WorkerResultsKey(workerID string) string {
+	return fmt.Sprintf("worker:{%s}:results", workerID)
+}
+
+// WorkerPreemptKey holds the running tasks a worker is asked to give up,
+// by task ID.
+func WorkerPreemptKey(workerID string) string {
+	return fmt.Sprintf("worker:{%s}:preempt", workerID)
+}
+
+// WorkerKeys returns every per-worker task hash.
+func WorkerKeys(workerID string) []string {
+	return []string{WorkerTasksKey(workerID), WorkerProcessingKey(workerID), WorkerResultsKey(workerID), WorkerPreemptKey(workerID)}
+}
+
+func MetricsHistoryKey(workerID string) string {
+	return fmt.Sprintf("worker:{%s}:metrics:history", workerID)
+}
+
+// WaitingKey holds a task waiting for its dependencies.
+func WaitingKey(taskID string) string {
+	return fmt.Sprintf("task:{%s}:waiting", taskID)
+}
+
+// DependentsKey holds the IDs of the tasks waiting for a task.
+func DependentsKey(taskID string) string {
+	return fmt.Sprintf("task:{%s}:dependents", taskID)
+}
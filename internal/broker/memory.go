@@ -0,0 +1,623 @@
+package broker
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryBroker struct {
+	mu      sync.Mutex
+	values  map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]struct{}
+	sorted  map[string]*sortedSet
+	streams map[string]*stream
+	subs    map[*memorySubscription]struct{}
+
+	// Closed and replaced whenever a sorted set or stream gains members,
+	// waking blocked pops and reads
+	changed chan struct{}
+
+	// Receives every change as it is applied, with mu held. Set by the
+	// file broker to persist the state.
+	journal func(op string, args ...string) error
+}
+
+// NewMemory returns a broker that keeps everything in process. State is
+// lost when the process exits.
+func NewMemory() Broker {
+	return &memoryBroker{
+		values:  make(map[string]string),
+		hashes:  make(map[string]map[string]string),
+		sets:    make(map[string]map[string]struct{}),
+		sorted:  make(map[string]*sortedSet),
+		streams: make(map[string]*stream),
+		subs:    make(map[*memorySubscription]struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+func (m *memoryBroker) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryBroker) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subs {
+		sub.closeLocked()
+	}
+	return nil
+}
+
+// logLocked hands a change to the journal, if there is one.
+func (m *memoryBroker) logLocked(op string, args ...string) error {
+	if m.journal == nil {
+		return nil
+	}
+	return m.journal(op, args...)
+}
+
+// notifyLocked wakes everyone waiting for queued work.
+func (m *memoryBroker) notifyLocked() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// await blocks until the queues change, the deadline passes or ctx is
+// done, and reports whether they changed.
+func await(ctx context.Context, changed <-chan struct{}, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (m *memoryBroker) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *memoryBroker) Set(ctx context.Context, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opSet, key, value); err != nil {
+		return err
+	}
+	m.values[key] = value
+	return nil
+}
+
+func (m *memoryBroker) NextSeq(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last, _ := strconv.ParseInt(m.values[key], 10, 64)
+	seq := max(time.Now().UnixMicro(), last+1)
+	if err := m.logLocked(opSet, key, strconv.FormatInt(seq, 10)); err != nil {
+		return 0, err
+	}
+	m.values[key] = strconv.FormatInt(seq, 10)
+	return seq, nil
+}
+
+func (m *memoryBroker) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opDelete, keys...); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(m.values, key)
+		delete(m.hashes, key)
+		delete(m.sets, key)
+		delete(m.sorted, key)
+		delete(m.streams, key)
+	}
+	return nil
+}
+
+func (m *memoryBroker) Keys(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	add := func(key string) {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.values {
+		add(key)
+	}
+	for key := range m.hashes {
+		add(key)
+	}
+	for key := range m.sets {
+		add(key)
+	}
+	for key := range m.sorted {
+		add(key)
+	}
+	for key := range m.streams {
+		add(key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memoryBroker) HashGet(ctx context.Context, key, field string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.hashes[key][field]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *memoryBroker) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash := make(map[string]string, len(m.hashes[key]))
+	for field, value := range m.hashes[key] {
+		hash[field] = value
+	}
+	return hash, nil
+}
+
+func (m *memoryBroker) HashValues(ctx context.Context, keys ...string) ([][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		for _, value := range m.hashes[key] {
+			values[i] = append(values[i], value)
+		}
+	}
+	return values, nil
+}
+
+func (m *memoryBroker) HashSet(ctx context.Context, key, field, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opHashSet, key, field, value); err != nil {
+		return err
+	}
+	m.hashLocked(key)[field] = value
+	return nil
+}
+
+func (m *memoryBroker) HashSetNew(ctx context.Context, key, field, value string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.hashes[key][field]; ok {
+		return false, nil
+	}
+	if err := m.logLocked(opHashSet, key, field, value); err != nil {
+		return false, err
+	}
+	m.hashLocked(key)[field] = value
+	return true, nil
+}
+
+func (m *memoryBroker) HashDelete(ctx context.Context, key string, fields ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hashDeleteLocked(key, fields...)
+}
+
+func (m *memoryBroker) HashLen(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.hashes[key])), nil
+}
+
+func (m *memoryBroker) HashExists(ctx context.Context, key, field string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.hashes[key][field]
+	return ok, nil
+}
+
+func (m *memoryBroker) HashIncr(ctx context.Context, key string, deltas map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash := m.hashes[key]
+	args := []string{key}
+	for field, delta := range deltas {
+		n, _ := strconv.ParseInt(hash[field], 10, 64)
+		args = append(args, field, strconv.FormatInt(n+delta, 10))
+	}
+	if err := m.logLocked(opHashSet, args...); err != nil {
+		return err
+	}
+	m.hashSetLocked(args...)
+	return nil
+}
+
+func (m *memoryBroker) HashMove(ctx context.Context, from, to, field, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opHashSet, to, field, value); err != nil {
+		return err
+	}
+	m.hashLocked(to)[field] = value
+	if from == to {
+		return nil
+	}
+	_, err := m.hashDeleteLocked(from, field)
+	return err
+}
+
+func (m *memoryBroker) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket := m.hashes[key]
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	tokens, err := strconv.ParseFloat(bucket["tokens"], 64)
+	if err != nil {
+		tokens = float64(burst)
+	}
+	updated, err := strconv.ParseFloat(bucket["updated"], 64)
+	if err != nil {
+		updated = now
+	}
+	if now > updated {
+		tokens = math.Min(float64(burst), tokens+(now-updated)*rate)
+		updated = now
+	}
+
+	var wait time.Duration
+	if tokens >= 1 {
+		tokens--
+	} else {
+		wait = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	args := []string{key,
+		"tokens", strconv.FormatFloat(tokens, 'f', -1, 64),
+		"updated", strconv.FormatFloat(updated, 'f', -1, 64),
+	}
+	if err := m.logLocked(opHashSet, args...); err != nil {
+		return 0, err
+	}
+	m.hashSetLocked(args...)
+	return wait, nil
+}
+
+func (m *memoryBroker) hashLocked(key string) map[string]string {
+	hash, ok := m.hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		m.hashes[key] = hash
+	}
+	return hash
+}
+
+// hashSetLocked sets the field value pairs following the key.
+func (m *memoryBroker) hashSetLocked(args ...string) {
+	hash := m.hashLocked(args[0])
+	for i := 1; i+1 < len(args); i += 2 {
+		hash[args[i]] = args[i+1]
+	}
+}
+
+func (m *memoryBroker) hashDeleteLocked(key string, fields ...string) (int64, error) {
+	hash := m.hashes[key]
+	deleted := []string{key}
+	for _, field := range fields {
+		if _, ok := hash[field]; ok {
+			deleted = append(deleted, field)
+		}
+	}
+	if len(deleted) == 1 {
+		return 0, nil
+	}
+	if err := m.logLocked(opHashDelete, deleted...); err != nil {
+		return 0, err
+	}
+
+	for _, field := range deleted[1:] {
+		delete(hash, field)
+	}
+	// Empty hashes disappear, as in Redis
+	if len(hash) == 0 {
+		delete(m.hashes, key)
+	}
+	return int64(len(deleted) - 1), nil
+}
+
+func (m *memoryBroker) SetAdd(ctx context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opSetAdd, append([]string{key}, members...)...); err != nil {
+		return err
+	}
+	set, ok := m.sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		m.sets[key] = set
+	}
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return nil
+}
+
+func (m *memoryBroker) SetRemove(ctx context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opSetRemove, append([]string{key}, members...)...); err != nil {
+		return err
+	}
+	set := m.sets[key]
+	for _, member := range members {
+		delete(set, member)
+	}
+	if set != nil && len(set) == 0 {
+		delete(m.sets, key)
+	}
+	return nil
+}
+
+func (m *memoryBroker) SetMembers(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]string, 0, len(m.sets[key]))
+	for member := range m.sets[key] {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (m *memoryBroker) SetContains(ctx context.Context, key, member string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.sets[key][member]
+	return ok, nil
+}
+
+func (m *memoryBroker) SortedAdd(ctx context.Context, key, member string, score float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.logLocked(opSortedAdd, key, member, strconv.FormatFloat(score, 'g', -1, 64)); err != nil {
+		return err
+	}
+	z, ok := m.sorted[key]
+	if !ok {
+		z = newSortedSet()
+		m.sorted[key] = z
+	}
+	z.add(member, score)
+	m.notifyLocked()
+	return nil
+}
+
+func (m *memoryBroker) SortedRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, ok := m.sorted[key]
+	if !ok {
+		return []string{}, nil
+	}
+
+	n := int64(len(z.members))
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, nil
+	}
+
+	members := make([]string, stop-start+1)
+	copy(members, z.members[start:stop+1])
+	return members, nil
+}
+
+func (m *memoryBroker) SortedRemove(ctx context.Context, key, member string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, ok := m.sorted[key]
+	if !ok {
+		return false, nil
+	}
+	if _, ok := z.scores[member]; !ok {
+		return false, nil
+	}
+	if err := m.logLocked(opSortedRemove, key, member); err != nil {
+		return false, err
+	}
+	m.sortedRemoveLocked(key, member)
+	return true, nil
+}
+
+func (m *memoryBroker) SortedRemoveBelow(ctx context.Context, key string, max float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	z, ok := m.sorted[key]
+	if !ok {
+		return nil
+	}
+	removed := []string{key}
+	for _, member := range z.members {
+		if z.scores[member] > max {
+			break
+		}
+		removed = append(removed, member)
+	}
+	if len(removed) == 1 {
+		return nil
+	}
+	if err := m.logLocked(opSortedRemove, removed...); err != nil {
+		return err
+	}
+	m.sortedRemoveLocked(key, removed[1:]...)
+	return nil
+}
+
+func (m *memoryBroker) SortedLen(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if z, ok := m.sorted[key]; ok {
+		return int64(len(z.members)), nil
+	}
+	return 0, nil
+}
+
+// sortedRemoveLocked removes members, dropping the set once it is empty.
+func (m *memoryBroker) sortedRemoveLocked(key string, members ...string) {
+	z, ok := m.sorted[key]
+	if !ok {
+		return
+	}
+	for _, member := range members {
+		z.remove(member)
+	}
+	if len(z.members) == 0 {
+		delete(m.sorted, key)
+	}
+}
+
+func (m *memoryBroker) SortedPopMin(ctx context.Context, timeout time.Duration, keys ...string) (*Popped, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		m.mu.Lock()
+		for _, key := range keys {
+			z, ok := m.sorted[key]
+			if !ok {
+				continue
+			}
+			member := z.members[0]
+			popped := &Popped{Key: key, Member: member, Score: z.scores[member]}
+			if err := m.logLocked(opSortedRemove, key, member); err != nil {
+				m.mu.Unlock()
+				return nil, err
+			}
+			m.sortedRemoveLocked(key, member)
+			m.mu.Unlock()
+			return popped, nil
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		if timeout <= 0 || !await(ctx, changed, deadline) {
+			return nil, ErrNotFound
+		}
+	}
+}
+
+func (m *memoryBroker) Publish(ctx context.Context, channel, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subs {
+		if !sub.channels[channel] {
+			continue
+		}
+		// Slow subscribers lose messages rather than stall publishers
+		select {
+		case sub.messages <- Message{Channel: channel, Payload: message}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (m *memoryBroker) Subscribe(ctx context.Context, channels ...string) Subscription {
+	sub := &memorySubscription{
+		broker:   m,
+		channels: make(map[string]bool, len(channels)),
+		messages: make(chan Message, 256),
+	}
+	for _, channel := range channels {
+		sub.channels[channel] = true
+	}
+
+	m.mu.Lock()
+	m.subs[sub] = struct{}{}
+	m.mu.Unlock()
+	return sub
+}
+
+type memorySubscription struct {
+	broker   *memoryBroker
+	channels map[string]bool
+	messages chan Message
+}
+
+func (s *memorySubscription) Messages() <-chan Message {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.closeLocked()
+	return nil
+}
+
+func (s *memorySubscription) closeLocked() {
+	if _, ok := s.broker.subs[s]; !ok {
+		return
+	}
+	delete(s.broker.subs, s)
+	close(s.messages)
+}
+
+// sortedSet keeps its members ordered by score, then by member.
+type sortedSet struct {
+	scores  map[string]float64
+	members []string
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64)}
+}
+
+// search returns where member with score is or would be inserted.
+func (z *sortedSet) search(member string, score float64) int {
+	return sort.Search(len(z.members), func(i int) bool {
+		s := z.scores[z.members[i]]
+		return s > score || (s == score && z.members[i] >= member)
+	})
+}
+
+func (z *sortedSet) add(member string, score float64) {
+	z.remove(member)
+	i := z.search(member, score)
+	z.members = append(z.members, "")
+	copy(z.members[i+1:], z.members[i:])
+	z.members[i] = member
+	z.scores[member] = score
+}
+
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	i := z.search(member, score)
+	z.members = append(z.members[:i], z.members[i+1:]...)
+	delete(z.scores, member)
+	return true
+}
@@ -0,0 +1,357 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type stream struct {
+	entries []streamEntry // ordered by ID
+	lastID  entryID
+	groups  map[string]*consumerGroup
+}
+
+type streamEntry struct {
+	id   entryID
+	task string
+}
+
+type consumerGroup struct {
+	lastDelivered entryID
+	pending       map[entryID]*pendingEntry
+}
+
+type pendingEntry struct {
+	consumer  string
+	delivered time.Time
+}
+
+// entryID orders entries like Redis stream IDs: milliseconds, then a
+// sequence number within the millisecond.
+type entryID struct {
+	ms, seq uint64
+}
+
+func (id entryID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id entryID) less(other entryID) bool {
+	if id.ms != other.ms {
+		return id.ms < other.ms
+	}
+	return id.seq < other.seq
+}
+
+func parseEntryID(s string) entryID {
+	ms, seq := splitID(s)
+	return entryID{ms: ms, seq: seq}
+}
+
+func (m *memoryBroker) streamLocked(key string) *stream {
+	s, ok := m.streams[key]
+	if !ok {
+		s = &stream{groups: make(map[string]*consumerGroup)}
+		m.streams[key] = s
+	}
+	return s
+}
+
+func (m *memoryBroker) StreamAdd(ctx context.Context, key, task string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last entryID
+	if s, ok := m.streams[key]; ok {
+		last = s.lastID
+	}
+	id := entryID{ms: uint64(time.Now().UnixMilli())}
+	if !last.less(id) {
+		id = entryID{ms: last.ms, seq: last.seq + 1}
+	}
+	if err := m.logLocked(opStreamAdd, key, id.String(), task); err != nil {
+		return "", err
+	}
+
+	s := m.streamLocked(key)
+	s.lastID = id
+	s.entries = append(s.entries, streamEntry{id: id, task: task})
+	m.notifyLocked()
+	return id.String(), nil
+}
+
+func (m *memoryBroker) StreamLen(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.streams[key]; ok {
+		return int64(len(s.entries)), nil
+	}
+	return 0, nil
+}
+
+func (m *memoryBroker) StreamBacklog(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[key]
+	if !ok {
+		return 0, nil
+	}
+	if len(s.groups) == 0 {
+		return int64(len(s.entries)), nil
+	}
+
+	var lag int64
+	for _, group := range s.groups {
+		lag = max(lag, int64(len(s.entries)-s.after(group.lastDelivered)))
+	}
+	return lag, nil
+}
+
+func (m *memoryBroker) StreamTrim(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.streams[key]
+	if !ok || len(s.groups) == 0 {
+		return 0, nil
+	}
+
+	// Keep everything from the oldest entry any group still needs
+	var minID *entryID
+	for _, group := range s.groups {
+		keep := group.lastDelivered
+		for id := range group.pending {
+			if id.less(keep) {
+				keep = id
+			}
+		}
+		if minID == nil || keep.less(*minID) {
+			minID = &keep
+		}
+	}
+
+	if s.before(*minID) == 0 {
+		return 0, nil
+	}
+	if err := m.logLocked(opStreamTrim, key, minID.String()); err != nil {
+		return 0, err
+	}
+	return int64(s.trimLocked(*minID)), nil
+}
+
+// before returns how many entries come before minID.
+func (s *stream) before(minID entryID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].id.less(minID)
+	})
+}
+
+// trimLocked drops the entries before minID and returns how many it
+// dropped.
+func (s *stream) trimLocked(minID entryID) int {
+	trimmed := s.before(minID)
+	s.entries = append([]streamEntry(nil), s.entries[trimmed:]...)
+	return trimmed
+}
+
+func (m *memoryBroker) GroupCreate(ctx context.Context, key, group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.streams[key]; ok {
+		if _, ok := s.groups[group]; ok {
+			return nil
+		}
+	}
+	if err := m.logLocked(opGroupCreate, key, group); err != nil {
+		return err
+	}
+	m.streamLocked(key).groups[group] = &consumerGroup{
+		pending: make(map[entryID]*pendingEntry),
+	}
+	return nil
+}
+
+func (m *memoryBroker) GroupRead(ctx context.Context, group, consumer string, keys []string, count int64, block time.Duration) ([]Entry, error) {
+	deadline := time.Now().Add(block)
+	for {
+		m.mu.Lock()
+		entries, err := m.deliverLocked(group, consumer, keys, count)
+		changed := m.changed
+		m.mu.Unlock()
+
+		if err != nil || len(entries) > 0 {
+			return entries, err
+		}
+		if block <= 0 || !await(ctx, changed, deadline) {
+			return nil, nil
+		}
+	}
+}
+
+// deliverLocked hands up to count undelivered entries per stream to
+// consumer and records them as pending.
+func (m *memoryBroker) deliverLocked(group, consumer string, keys []string, count int64) ([]Entry, error) {
+	var entries []Entry
+	for _, key := range keys {
+		g, err := m.groupLocked(key, group)
+		if err != nil {
+			return nil, err
+		}
+		s := m.streams[key]
+		start := s.after(g.lastDelivered)
+		end := len(s.entries)
+		if count > 0 {
+			end = min(end, start+int(count))
+		}
+		if start == end {
+			continue
+		}
+
+		delivered := []string{key, group, consumer}
+		for _, entry := range s.entries[start:end] {
+			delivered = append(delivered, entry.id.String())
+		}
+		if err := m.logLocked(opGroupDeliver, delivered...); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		for _, entry := range s.entries[start:end] {
+			g.lastDelivered = entry.id
+			g.pending[entry.id] = &pendingEntry{consumer: consumer, delivered: now}
+			entries = append(entries, Entry{Stream: key, ID: entry.id.String(), Task: entry.task})
+		}
+	}
+	return entries, nil
+}
+
+func (m *memoryBroker) GroupAck(ctx context.Context, key, group string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, err := m.groupLocked(key, group)
+	if err != nil {
+		// Nothing to acknowledge in a group that is gone
+		return nil
+	}
+	if err := m.logLocked(opGroupAck, append([]string{key, group}, ids...)...); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		delete(g.pending, parseEntryID(id))
+	}
+	return nil
+}
+
+func (m *memoryBroker) GroupClaim(ctx context.Context, key, group, consumer string, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, err := m.groupLocked(key, group)
+	if err != nil {
+		return err
+	}
+
+	claimed := []string{key, group, consumer}
+	for _, id := range ids {
+		if _, ok := g.pending[parseEntryID(id)]; ok {
+			claimed = append(claimed, id)
+		}
+	}
+	if len(claimed) == 3 {
+		return nil
+	}
+	if err := m.logLocked(opGroupClaim, claimed...); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range claimed[3:] {
+		pending := g.pending[parseEntryID(id)]
+		pending.consumer = consumer
+		pending.delivered = now
+	}
+	return nil
+}
+
+func (m *memoryBroker) GroupAutoClaim(ctx context.Context, key, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, err := m.groupLocked(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]entryID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+
+	s := m.streams[key]
+	now := time.Now()
+	var entries []Entry
+	claimed := []string{key, group, consumer}
+	for _, id := range ids {
+		if count > 0 && int64(len(entries)) >= count {
+			break
+		}
+		if now.Sub(g.pending[id].delivered) < minIdle {
+			continue
+		}
+
+		entry := Entry{Stream: key, ID: id.String()}
+		if i := s.after(id) - 1; i >= 0 && s.entries[i].id == id {
+			entry.Task = s.entries[i].task
+		}
+		entries = append(entries, entry)
+		claimed = append(claimed, entry.ID)
+	}
+	if len(claimed) == 3 {
+		return entries, nil
+	}
+	if err := m.logLocked(opGroupClaim, claimed...); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		pending := g.pending[parseEntryID(entry.ID)]
+		pending.consumer = consumer
+		pending.delivered = now
+	}
+	return entries, nil
+}
+
+func (m *memoryBroker) GroupRemoveConsumer(ctx context.Context, key, group, consumer string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, err := m.groupLocked(key, group)
+	if err != nil {
+		return err
+	}
+
+	if err := m.logLocked(opGroupRemoveConsumer, key, group, consumer); err != nil {
+		return err
+	}
+	for id, pending := range g.pending {
+		if pending.consumer == consumer {
+			delete(g.pending, id)
+		}
+	}
+	return nil
+}
+
+func (m *memoryBroker) groupLocked(key, group string) (*consumerGroup, error) {
+	if s, ok := m.streams[key]; ok {
+		if g, ok := s.groups[group]; ok {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s on %s", ErrNoGroup, group, key)
+}
+
+// after returns the index of the first entry with an ID greater than id.
+func (s *stream) after(id entryID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return id.less(s.entries[i].id)
+	})
+}
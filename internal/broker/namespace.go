@@ -0,0 +1,261 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateNamespace accepts letters, digits, '_', '.' and '-'. The empty
+// namespace is the default one.
+func ValidateNamespace(ns string) error {
+	if ns != "" && !validNamespace.MatchString(ns) {
+		return fmt.Errorf("invalid namespace %q: use letters, digits, '_', '.' and '-'", ns)
+	}
+	return nil
+}
+
+type namespaced struct {
+	Broker
+	prefix string
+}
+
+// WithNamespace prefixes every key and pub/sub channel with ns, so several
+// clusters can share one Redis without seeing each other's state. Keys and
+// channels handed back are stripped of the prefix again. The default
+// namespace returns b unchanged.
+func WithNamespace(b Broker, ns string) Broker {
+	if ns == "" {
+		return b
+	}
+	return &namespaced{Broker: b, prefix: ns + ":"}
+}
+
+func (n *namespaced) key(key string) string {
+	return n.prefix + key
+}
+
+func (n *namespaced) keys(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = n.prefix + key
+	}
+	return prefixed
+}
+
+func (n *namespaced) strip(key string) string {
+	return strings.TrimPrefix(key, n.prefix)
+}
+
+func (n *namespaced) Get(ctx context.Context, key string) (string, error) {
+	return n.Broker.Get(ctx, n.key(key))
+}
+
+func (n *namespaced) Set(ctx context.Context, key, value string) error {
+	return n.Broker.Set(ctx, n.key(key), value)
+}
+
+func (n *namespaced) NextSeq(ctx context.Context, key string) (int64, error) {
+	return n.Broker.NextSeq(ctx, n.key(key))
+}
+
+func (n *namespaced) Delete(ctx context.Context, keys ...string) error {
+	return n.Broker.Delete(ctx, n.keys(keys)...)
+}
+
+func (n *namespaced) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := n.Broker.Keys(ctx, n.key(prefix))
+	for i, key := range keys {
+		keys[i] = n.strip(key)
+	}
+	return keys, err
+}
+
+func (n *namespaced) HashGet(ctx context.Context, key, field string) (string, error) {
+	return n.Broker.HashGet(ctx, n.key(key), field)
+}
+
+func (n *namespaced) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return n.Broker.HashGetAll(ctx, n.key(key))
+}
+
+func (n *namespaced) HashValues(ctx context.Context, keys ...string) ([][]string, error) {
+	return n.Broker.HashValues(ctx, n.keys(keys)...)
+}
+
+func (n *namespaced) HashSet(ctx context.Context, key, field, value string) error {
+	return n.Broker.HashSet(ctx, n.key(key), field, value)
+}
+
+func (n *namespaced) HashSetNew(ctx context.Context, key, field, value string) (bool, error) {
+	return n.Broker.HashSetNew(ctx, n.key(key), field, value)
+}
+
+func (n *namespaced) HashDelete(ctx context.Context, key string, fields ...string) (int64, error) {
+	return n.Broker.HashDelete(ctx, n.key(key), fields...)
+}
+
+func (n *namespaced) HashLen(ctx context.Context, key string) (int64, error) {
+	return n.Broker.HashLen(ctx, n.key(key))
+}
+
+func (n *namespaced) HashExists(ctx context.Context, key, field string) (bool, error) {
+	return n.Broker.HashExists(ctx, n.key(key), field)
+}
+
+func (n *namespaced) HashIncr(ctx context.Context, key string, deltas map[string]int64) error {
+	return n.Broker.HashIncr(ctx, n.key(key), deltas)
+}
+
+func (n *namespaced) HashMove(ctx context.Context, from, to, field, value string) error {
+	return n.Broker.HashMove(ctx, n.key(from), n.key(to), field, value)
+}
+
+func (n *namespaced) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	return n.Broker.TakeToken(ctx, n.key(key), rate, burst)
+}
+
+func (n *namespaced) SetAdd(ctx context.Context, key string, members ...string) error {
+	return n.Broker.SetAdd(ctx, n.key(key), members...)
+}
+
+func (n *namespaced) SetRemove(ctx context.Context, key string, members ...string) error {
+	return n.Broker.SetRemove(ctx, n.key(key), members...)
+}
+
+func (n *namespaced) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return n.Broker.SetMembers(ctx, n.key(key))
+}
+
+func (n *namespaced) SetContains(ctx context.Context, key, member string) (bool, error) {
+	return n.Broker.SetContains(ctx, n.key(key), member)
+}
+
+func (n *namespaced) SortedAdd(ctx context.Context, key, member string, score float64) error {
+	return n.Broker.SortedAdd(ctx, n.key(key), member, score)
+}
+
+func (n *namespaced) SortedRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return n.Broker.SortedRange(ctx, n.key(key), start, stop)
+}
+
+func (n *namespaced) SortedRemove(ctx context.Context, key, member string) (bool, error) {
+	return n.Broker.SortedRemove(ctx, n.key(key), member)
+}
+
+func (n *namespaced) SortedRemoveBelow(ctx context.Context, key string, max float64) error {
+	return n.Broker.SortedRemoveBelow(ctx, n.key(key), max)
+}
+
+func (n *namespaced) SortedLen(ctx context.Context, key string) (int64, error) {
+	return n.Broker.SortedLen(ctx, n.key(key))
+}
+
+func (n *namespaced) SortedPopMin(ctx context.Context, timeout time.Duration, keys ...string) (*Popped, error) {
+	popped, err := n.Broker.SortedPopMin(ctx, timeout, n.keys(keys)...)
+	if popped != nil {
+		popped.Key = n.strip(popped.Key)
+	}
+	return popped, err
+}
+
+func (n *namespaced) StreamAdd(ctx context.Context, stream, task string) (string, error) {
+	return n.Broker.StreamAdd(ctx, n.key(stream), task)
+}
+
+func (n *namespaced) StreamLen(ctx context.Context, stream string) (int64, error) {
+	return n.Broker.StreamLen(ctx, n.key(stream))
+}
+
+func (n *namespaced) StreamBacklog(ctx context.Context, stream string) (int64, error) {
+	return n.Broker.StreamBacklog(ctx, n.key(stream))
+}
+
+func (n *namespaced) StreamTrim(ctx context.Context, stream string) (int64, error) {
+	return n.Broker.StreamTrim(ctx, n.key(stream))
+}
+
+func (n *namespaced) GroupCreate(ctx context.Context, stream, group string) error {
+	return n.Broker.GroupCreate(ctx, n.key(stream), group)
+}
+
+func (n *namespaced) GroupRead(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error) {
+	entries, err := n.Broker.GroupRead(ctx, group, consumer, n.keys(streams), count, block)
+	return n.stripEntries(entries), err
+}
+
+func (n *namespaced) GroupAck(ctx context.Context, stream, group string, ids ...string) error {
+	return n.Broker.GroupAck(ctx, n.key(stream), group, ids...)
+}
+
+func (n *namespaced) GroupClaim(ctx context.Context, stream, group, consumer string, ids ...string) error {
+	return n.Broker.GroupClaim(ctx, n.key(stream), group, consumer, ids...)
+}
+
+func (n *namespaced) GroupAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error) {
+	entries, err := n.Broker.GroupAutoClaim(ctx, n.key(stream), group, consumer, minIdle, count)
+	return n.stripEntries(entries), err
+}
+
+func (n *namespaced) GroupRemoveConsumer(ctx context.Context, stream, group, consumer string) error {
+	return n.Broker.GroupRemoveConsumer(ctx, n.key(stream), group, consumer)
+}
+
+func (n *namespaced) stripEntries(entries []Entry) []Entry {
+	for i := range entries {
+		entries[i].Stream = n.strip(entries[i].Stream)
+	}
+	return entries
+}
+
+func (n *namespaced) Publish(ctx context.Context, channel, message string) error {
+	return n.Broker.Publish(ctx, n.key(channel), message)
+}
+
+func (n *namespaced) Subscribe(ctx context.Context, channels ...string) Subscription {
+	sub := n.Broker.Subscribe(ctx, n.keys(channels)...)
+	out := make(chan Message, cap(sub.Messages()))
+	go func() {
+		defer close(out)
+		for msg := range sub.Messages() {
+			msg.Channel = n.strip(msg.Channel)
+			select {
+			case out <- msg:
+			default:
+			}
+		}
+	}()
+	return &namespacedSubscription{Subscription: sub, messages: out}
+}
+
+type namespacedSubscription struct {
+	Subscription
+	messages chan Message
+}
+
+func (s *namespacedSubscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Purge deletes every key in the namespace of b and returns how many it
+// deleted. The default namespace cannot be purged, since it would take
+// every other namespace with it.
+func Purge(ctx context.Context, b Broker) (int, error) {
+	if _, ok := b.(*namespaced); !ok {
+		return 0, errors.New("refusing to purge the default namespace")
+	}
+
+	keys, err := b.Keys(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys: %w", err)
+	}
+	if err := b.Delete(ctx, keys...); err != nil {
+		return 0, fmt.Errorf("failed to delete keys: %w", err)
+	}
+	return len(keys), nil
+}
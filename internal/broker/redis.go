@@ -0,0 +1,385 @@
+package broker
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type redisBroker struct {
+	client redis.UniversalClient
+}
+
+// NewRedis returns a broker backed by Redis. Keys that are used together
+// share a hash tag, so it works on a Cluster as well.
+func NewRedis(o *RedisOptions) Broker {
+	return &redisBroker{client: NewRedisClient(o)}
+}
+
+func (r *redisBroker) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *redisBroker) Close() error {
+	return r.client.Close()
+}
+
+func (r *redisBroker) Get(ctx context.Context, key string) (string, error) {
+	return notFound(r.client.Get(ctx, key).Result())
+}
+
+func (r *redisBroker) Set(ctx context.Context, key, value string) error {
+	return r.client.Set(ctx, key, value, 0).Err()
+}
+
+func (r *redisBroker) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// One DEL per key, since the keys may live in different Cluster slots
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// nextSeq keeps the sequence with the server's clock, so every client
+// draws from the same one.
+var nextSeq = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local seq = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local last = tonumber(redis.call('GET', KEYS[1]) or '0')
+if seq <= last then
+	seq = last + 1
+end
+redis.call('SET', KEYS[1], string.format('%.0f', seq))
+return seq
+`)
+
+func (r *redisBroker) NextSeq(ctx context.Context, key string) (int64, error) {
+	return nextSeq.Run(ctx, r.client, []string{key}).Int64()
+}
+
+func (r *redisBroker) Keys(ctx context.Context, prefix string) ([]string, error) {
+	pattern := globEscaper.Replace(prefix) + "*"
+	scan := func(ctx context.Context, client redis.UniversalClient) ([]string, error) {
+		var keys []string
+		iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		return keys, iter.Err()
+	}
+
+	// A Cluster is scanned node by node
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, r.client)
+	}
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		found, err := scan(ctx, node)
+		mu.Lock()
+		keys = append(keys, found...)
+		mu.Unlock()
+		return err
+	})
+	return keys, err
+}
+
+// Escapes the characters SCAN MATCH treats as a pattern
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (r *redisBroker) HashGet(ctx context.Context, key, field string) (string, error) {
+	return notFound(r.client.HGet(ctx, key, field).Result())
+}
+
+func (r *redisBroker) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.client.HGetAll(ctx, key).Result()
+}
+
+func (r *redisBroker) HashValues(ctx context.Context, keys ...string) ([][]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HVals(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	values := make([][]string, len(keys))
+	for i, cmd := range cmds {
+		values[i] = cmd.Val()
+	}
+	return values, nil
+}
+
+func (r *redisBroker) HashSet(ctx context.Context, key, field, value string) error {
+	return r.client.HSet(ctx, key, field, value).Err()
+}
+
+func (r *redisBroker) HashSetNew(ctx context.Context, key, field, value string) (bool, error) {
+	return r.client.HSetNX(ctx, key, field, value).Result()
+}
+
+func (r *redisBroker) HashDelete(ctx context.Context, key string, fields ...string) (int64, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	return r.client.HDel(ctx, key, fields...).Result()
+}
+
+func (r *redisBroker) HashLen(ctx context.Context, key string) (int64, error) {
+	return r.client.HLen(ctx, key).Result()
+}
+
+func (r *redisBroker) HashExists(ctx context.Context, key, field string) (bool, error) {
+	return r.client.HExists(ctx, key, field).Result()
+}
+
+func (r *redisBroker) HashIncr(ctx context.Context, key string, deltas map[string]int64) error {
+	pipe := r.client.Pipeline()
+	for field, delta := range deltas {
+		pipe.HIncrBy(ctx, key, field, delta)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// HashMove is atomic unless the keys live in different Cluster slots. Then
+// the field is only deleted once it is set, so a failure leaves it in both
+// hashes rather than in neither.
+func (r *redisBroker) HashMove(ctx context.Context, from, to, field, value string) error {
+	if r.isCluster() && !sameSlot(from, to) {
+		if err := r.client.HSet(ctx, to, field, value).Err(); err != nil {
+			return err
+		}
+		return r.client.HDel(ctx, from, field).Err()
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, to, field, value)
+	pipe.HDel(ctx, from, field)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// takeToken refills the bucket for the time since its last update, with
+// the server's clock so workers with skewed clocks share it fairly, then
+// takes a token or returns the wait in seconds.
+var takeToken = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = (1 - tokens) / rate
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(updated))
+return tostring(wait)
+`)
+
+func (r *redisBroker) TakeToken(ctx context.Context, key string, rate float64, burst int) (time.Duration, error) {
+	wait, err := takeToken.Run(ctx, r.client, []string{key}, rate, burst).Text()
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(wait, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (r *redisBroker) SetAdd(ctx context.Context, key string, members ...string) error {
+	return r.client.SAdd(ctx, key, toArgs(members)...).Err()
+}
+
+func (r *redisBroker) SetRemove(ctx context.Context, key string, members ...string) error {
+	return r.client.SRem(ctx, key, toArgs(members)...).Err()
+}
+
+func (r *redisBroker) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return r.client.SMembers(ctx, key).Result()
+}
+
+func (r *redisBroker) SetContains(ctx context.Context, key, member string) (bool, error) {
+	return r.client.SIsMember(ctx, key, member).Result()
+}
+
+func (r *redisBroker) SortedAdd(ctx context.Context, key, member string, score float64) error {
+	return r.client.ZAdd(ctx, key, &redis.Z{Score: score, Member: member}).Err()
+}
+
+func (r *redisBroker) SortedRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.client.ZRange(ctx, key, start, stop).Result()
+}
+
+func (r *redisBroker) SortedRemove(ctx context.Context, key, member string) (bool, error) {
+	removed, err := r.client.ZRem(ctx, key, member).Result()
+	return removed > 0, err
+}
+
+func (r *redisBroker) SortedRemoveBelow(ctx context.Context, key string, max float64) error {
+	return r.client.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatFloat(max, 'f', -1, 64)).Err()
+}
+
+func (r *redisBroker) SortedLen(ctx context.Context, key string) (int64, error) {
+	return r.client.ZCard(ctx, key).Result()
+}
+
+func (r *redisBroker) SortedPopMin(ctx context.Context, timeout time.Duration, keys ...string) (*Popped, error) {
+	// A Cluster cannot block on keys in different slots, so it polls them
+	// one at a time instead
+	if timeout > 0 && (len(keys) == 1 || !r.isCluster()) {
+		popped, err := r.client.BZPopMin(ctx, timeout, keys...).Result()
+		if err == redis.Nil {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		member, _ := popped.Member.(string)
+		return &Popped{Key: popped.Key, Member: member, Score: popped.Score}, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, key := range keys {
+			popped, err := r.client.ZPopMin(ctx, key).Result()
+			if err != nil {
+				return nil, err
+			}
+			if len(popped) > 0 {
+				member, _ := popped[0].Member.(string)
+				return &Popped{Key: key, Member: member, Score: popped[0].Score}, nil
+			}
+		}
+		if timeout <= 0 || !pollWait(ctx, deadline) {
+			return nil, ErrNotFound
+		}
+	}
+}
+
+// clusterPoll is how often a Cluster client looks for work across keys it
+// cannot block on together.
+const clusterPoll = 50 * time.Millisecond
+
+func (r *redisBroker) isCluster() bool {
+	_, ok := r.client.(*redis.ClusterClient)
+	return ok
+}
+
+// sameSlot reports whether two keys share a hash tag, and with it a
+// Cluster slot.
+func sameSlot(a, b string) bool {
+	tagA, okA := hashTag(a)
+	tagB, okB := hashTag(b)
+	return okA && okB && tagA == tagB
+}
+
+// hashTag returns the part of key between the first { and the next }, if
+// it is not empty.
+func hashTag(key string) (string, bool) {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return "", false
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return "", false
+	}
+	return key[start+1 : start+1+end], true
+}
+
+// pollWait waits for the next poll and reports whether there is time left
+// for one.
+func pollWait(ctx context.Context, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(min(wait, clusterPoll)):
+		return true
+	}
+}
+
+func (r *redisBroker) Publish(ctx context.Context, channel, message string) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+func (r *redisBroker) Subscribe(ctx context.Context, channels ...string) Subscription {
+	pubsub := r.client.Subscribe(ctx, channels...)
+	out := make(chan Message, 256)
+
+	go func() {
+		defer close(out)
+		for msg := range pubsub.Channel() {
+			// Slow subscribers lose messages rather than stall the
+			// connection
+			select {
+			case out <- Message{Channel: msg.Channel, Payload: msg.Payload}:
+			default:
+			}
+		}
+	}()
+
+	return &redisSubscription{pubsub: pubsub, messages: out}
+}
+
+type redisSubscription struct {
+	pubsub   *redis.PubSub
+	messages chan Message
+}
+
+func (s *redisSubscription) Messages() <-chan Message {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	return s.pubsub.Close()
+}
+
+// notFound maps a missing key to ErrNotFound.
+func notFound(value string, err error) (string, error) {
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisOptions configures the connection to Redis: a single server, the
+// master of a Sentinel group, or a Cluster.
+type RedisOptions struct {
+	// Server, Sentinel or Cluster seed addresses
+	Addrs []string
+	// Name of the Sentinel master; connects through Sentinel when set
+	MasterName string
+	// Connect to a Redis Cluster
+	Cluster  bool
+	Username string
+	Password string
+	// Password of the Sentinel nodes, if they require one
+	SentinelPassword string
+	// Database number, must be 0 on a Cluster
+	DB int
+	// Negotiates TLS when set
+	TLSConfig *tls.Config
+	// Connections per node, 0 uses the client's default
+	PoolSize     int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+func (o *RedisOptions) Validate() error {
+	if len(o.Addrs) == 0 {
+		return errors.New("no Redis address given")
+	}
+	if o.Cluster && o.MasterName != "" {
+		return errors.New("cannot use Sentinel and Cluster together")
+	}
+	if len(o.Addrs) > 1 && !o.Cluster && o.MasterName == "" {
+		return errors.New("several Redis addresses need a Sentinel master or cluster=true")
+	}
+	if o.Cluster && o.DB != 0 {
+		return errors.New("Redis Cluster only has database 0")
+	}
+	return nil
+}
+
+// ParseRedisURL reads the connection settings from either a plain address
+// list or a URL:
+//
+//	host:port[,host:port...]
+//	redis[s]://[[user]:password@]host:port[,host:port...][/db][?options]
+//
+// rediss enables TLS. Options are master (Sentinel master name),
+// sentinel_password, cluster, pool_size, dial_timeout, read_timeout,
+// write_timeout and skip_verify.
+func ParseRedisURL(s string) (*RedisOptions, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		o := &RedisOptions{Addrs: splitAddrs(s)}
+		return o, o.Validate()
+	}
+	if scheme != "redis" && scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported Redis URL scheme %q", scheme)
+	}
+
+	o := &RedisOptions{}
+	if scheme == "rediss" {
+		o.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	rest, rawQuery, _ := strings.Cut(rest, "?")
+	hosts, db, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(hosts, "@"); i >= 0 {
+		user, password, _ := strings.Cut(hosts[:i], ":")
+		var err error
+		if o.Username, err = url.PathUnescape(user); err != nil {
+			return nil, fmt.Errorf("invalid Redis username: %w", err)
+		}
+		if o.Password, err = url.PathUnescape(password); err != nil {
+			return nil, fmt.Errorf("invalid Redis password: %w", err)
+		}
+		hosts = hosts[i+1:]
+	}
+	o.Addrs = splitAddrs(hosts)
+
+	if db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+		o.DB = n
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL options: %w", err)
+	}
+	for name, values := range query {
+		value := values[len(values)-1]
+		switch name {
+		case "master":
+			o.MasterName = value
+		case "sentinel_password":
+			o.SentinelPassword = value
+		case "cluster":
+			o.Cluster, err = strconv.ParseBool(value)
+		case "pool_size":
+			o.PoolSize, err = strconv.Atoi(value)
+		case "dial_timeout":
+			o.DialTimeout, err = time.ParseDuration(value)
+		case "read_timeout":
+			o.ReadTimeout, err = time.ParseDuration(value)
+		case "write_timeout":
+			o.WriteTimeout, err = time.ParseDuration(value)
+		case "skip_verify":
+			var skip bool
+			skip, err = strconv.ParseBool(value)
+			if o.TLSConfig != nil {
+				o.TLSConfig.InsecureSkipVerify = skip
+			}
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis URL option %s: %w", name, err)
+		}
+	}
+
+	return o, o.Validate()
+}
+
+// RedactRedisURL returns s with the passwords replaced by "xxxxx", for
+// logging.
+func RedactRedisURL(s string) string {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return s
+	}
+
+	rest, rawQuery, hasQuery := strings.Cut(rest, "?")
+	hosts, db, hasDB := strings.Cut(rest, "/")
+	if i := strings.LastIndex(hosts, "@"); i >= 0 {
+		if user, _, ok := strings.Cut(hosts[:i], ":"); ok {
+			hosts = user + ":xxxxx" + hosts[i:]
+		}
+	}
+
+	redacted := scheme + "://" + hosts
+	if hasDB {
+		redacted += "/" + db
+	}
+	if hasQuery {
+		if query, err := url.ParseQuery(rawQuery); err != nil {
+			// Cannot tell the password apart, so leave all options out
+			rawQuery = "xxxxx"
+		} else if query.Has("sentinel_password") {
+			query.Set("sentinel_password", "xxxxx")
+			rawQuery = query.Encode()
+		}
+		redacted += "?" + rawQuery
+	}
+	return redacted
+}
+
+func splitAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// NewRedisClient connects every component to Redis the same way. The
+// client follows failovers through Sentinel and routes commands by key
+// slot on a Cluster.
+func NewRedisClient(o *RedisOptions) redis.UniversalClient {
+	universal := &redis.UniversalOptions{
+		Addrs:            o.Addrs,
+		MasterName:       o.MasterName,
+		Username:         o.Username,
+		Password:         o.Password,
+		SentinelPassword: o.SentinelPassword,
+		DB:               o.DB,
+		TLSConfig:        o.TLSConfig,
+		PoolSize:         o.PoolSize,
+		DialTimeout:      o.DialTimeout,
+		ReadTimeout:      o.ReadTimeout,
+		WriteTimeout:     o.WriteTimeout,
+	}
+
+	switch {
+	case o.Cluster:
+		return redis.NewClusterClient(universal.Cluster())
+	case o.MasterName != "":
+		return redis.NewFailoverClient(universal.Failover())
+	default:
+		return redis.NewClient(universal.Simple())
+	}
+}
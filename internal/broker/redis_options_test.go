@@ -0,0 +1,22 @@
+package broker
+
+import "testing"
+
+func TestRedactRedisURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"localhost:6379", "localhost:6379"},
+		{"redis://host:6379/1", "redis://host:6379/1"},
+		{"redis://:secret@host:6379", "redis://:xxxxx@host:6379"},
+		{"rediss://user:p@ss@a:1,b:2/0?cluster=true", "rediss://user:xxxxx@a:1,b:2/0?cluster=true"},
+		{"redis://user@host:6379", "redis://user@host:6379"},
+		{"redis://a:1,b:2?master=m&sentinel_password=secret", "redis://a:1,b:2?master=m&sentinel_password=xxxxx"},
+	}
+	for _, test := range tests {
+		if got := RedactRedisURL(test.url); got != test.want {
+			t.Errorf("RedactRedisURL(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The stream entry field tasks are stored in
+const taskField = "task"
+
+func (r *redisBroker) StreamAdd(ctx context.Context, stream, task string) (string, error) {
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{taskField: task},
+	}).Result()
+}
+
+func (r *redisBroker) StreamLen(ctx context.Context, stream string) (int64, error) {
+	return r.client.XLen(ctx, stream).Result()
+}
+
+func (r *redisBroker) StreamBacklog(ctx context.Context, stream string) (int64, error) {
+	groups, err := r.groupInfo(ctx, stream)
+	if err == nil && len(groups) > 0 {
+		var lag int64
+		known := true
+		for _, group := range groups {
+			if group.lag < 0 {
+				known = false
+				break
+			}
+			lag = max(lag, group.lag)
+		}
+		if known {
+			return lag, nil
+		}
+	}
+
+	return r.client.XLen(ctx, stream).Result()
+}
+
+// StreamTrim keeps entries that are still pending or not yet delivered to
+// some group, so trimming never loses a task.
+func (r *redisBroker) StreamTrim(ctx context.Context, stream string) (int64, error) {
+	groups, err := r.groupInfo(ctx, stream)
+	if err != nil || len(groups) == 0 {
+		return 0, err
+	}
+
+	minID := ""
+	for _, group := range groups {
+		keep := group.lastDelivered
+		pending, err := r.client.XPending(ctx, stream, group.name).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read pending entries of %s: %w", group.name, err)
+		}
+		if pending.Count > 0 && lessID(pending.Lower, keep) {
+			keep = pending.Lower
+		}
+		if minID == "" || lessID(keep, minID) {
+			minID = keep
+		}
+	}
+
+	if minID == "" || minID == "0-0" {
+		return 0, nil
+	}
+	return r.client.XTrimMinID(ctx, stream, minID).Result()
+}
+
+func (r *redisBroker) GroupCreate(ctx context.Context, stream, group string) error {
+	err := r.client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (r *redisBroker) GroupRead(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error) {
+	if len(streams) > 1 && r.isCluster() {
+		return r.groupReadEach(ctx, group, consumer, streams, count, block)
+	}
+	return r.groupRead(ctx, group, consumer, streams, count, block)
+}
+
+// groupReadEach reads the streams one at a time, in order, since a Cluster
+// cannot read streams in different slots in one command. It polls until
+// one has entries or block runs out.
+func (r *redisBroker) groupReadEach(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error) {
+	deadline := time.Now().Add(block)
+	for {
+		var entries []Entry
+		for _, stream := range streams {
+			left := count
+			if count > 0 {
+				if left -= int64(len(entries)); left <= 0 {
+					break
+				}
+			}
+			read, err := r.groupRead(ctx, group, consumer, []string{stream}, left, 0)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, read...)
+		}
+		if len(entries) > 0 || block <= 0 || !pollWait(ctx, deadline) {
+			return entries, nil
+		}
+	}
+}
+
+func (r *redisBroker) groupRead(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]Entry, error) {
+	args := make([]string, 0, 2*len(streams))
+	args = append(args, streams...)
+	for range streams {
+		args = append(args, ">")
+	}
+
+	// A negative block leaves out BLOCK, zero would wait forever
+	if block <= 0 {
+		block = -1
+	}
+	result, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  args,
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	var entries []Entry
+	for _, stream := range result {
+		for _, msg := range stream.Messages {
+			task, _ := msg.Values[taskField].(string)
+			entries = append(entries, Entry{Stream: stream.Stream, ID: msg.ID, Task: task})
+		}
+	}
+	return entries, nil
+}
+
+func (r *redisBroker) GroupAck(ctx context.Context, stream, group string, ids ...string) error {
+	return r.client.XAck(ctx, stream, group, ids...).Err()
+}
+
+func (r *redisBroker) GroupClaim(ctx context.Context, stream, group, consumer string, ids ...string) error {
+	return groupError(r.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		Messages: ids,
+	}).Err())
+}
+
+// GroupAutoClaim issues XAUTOCLAIM directly, since the reply gained a field
+// in Redis 7 that the client does not parse.
+func (r *redisBroker) GroupAutoClaim(ctx context.Context, stream, group, consumer string, minIdle time.Duration, count int64) ([]Entry, error) {
+	reply, err := r.do(ctx, 1, "XAUTOCLAIM", stream, group, consumer,
+		minIdle.Milliseconds(), "0-0", "COUNT", count)
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	parts, _ := reply.([]interface{})
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("unexpected XAUTOCLAIM reply %v", reply)
+	}
+
+	claimed, _ := parts[1].([]interface{})
+	entries := make([]Entry, 0, len(claimed))
+	for _, item := range claimed {
+		pair, _ := item.([]interface{})
+		if len(pair) < 2 {
+			continue
+		}
+		entry := Entry{Stream: stream}
+		entry.ID, _ = pair[0].(string)
+		if fields, ok := pair[1].([]interface{}); ok {
+			for i := 0; i+1 < len(fields); i += 2 {
+				if key, _ := fields[i].(string); key == taskField {
+					entry.Task, _ = fields[i+1].(string)
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	// Redis 7 reports entries deleted from the stream separately
+	if len(parts) > 2 {
+		deleted, _ := parts[2].([]interface{})
+		for _, item := range deleted {
+			if id, ok := item.(string); ok {
+				entries = append(entries, Entry{Stream: stream, ID: id})
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (r *redisBroker) GroupRemoveConsumer(ctx context.Context, stream, group, consumer string) error {
+	return r.client.XGroupDelConsumer(ctx, stream, group, consumer).Err()
+}
+
+type streamGroup struct {
+	name          string
+	lastDelivered string
+	lag           int64 // -1 when Redis does not report it
+}
+
+// groupInfo reads XINFO GROUPS directly, since its reply gained fields in
+// Redis 7 that the client does not parse.
+func (r *redisBroker) groupInfo(ctx context.Context, stream string) ([]streamGroup, error) {
+	reply, err := r.do(ctx, 2, "XINFO", "GROUPS", stream)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	items, _ := reply.([]interface{})
+	groups := make([]streamGroup, 0, len(items))
+	for _, item := range items {
+		fields, _ := item.([]interface{})
+		group := streamGroup{lag: -1}
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].(string)
+			switch key {
+			case "name":
+				group.name, _ = fields[i+1].(string)
+			case "last-delivered-id":
+				group.lastDelivered, _ = fields[i+1].(string)
+			case "lag":
+				if lag, ok := fields[i+1].(int64); ok {
+					group.lag = lag
+				}
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// do runs a raw command, telling a Cluster client where its key is so it
+// reaches the node serving that slot.
+func (r *redisBroker) do(ctx context.Context, keyPos int8, args ...interface{}) (interface{}, error) {
+	cmd := redis.NewCmd(ctx, args...)
+	cmd.SetFirstKeyPos(keyPos)
+	r.client.Process(ctx, cmd)
+	return cmd.Result()
+}
+
+// groupError maps a missing consumer group to ErrNoGroup.
+func groupError(err error) error {
+	if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+		return fmt.Errorf("%w: %v", ErrNoGroup, err)
+	}
+	return err
+}
+
+// lessID compares two stream entry IDs.
+func lessID(a, b string) bool {
+	aMs, aSeq := splitID(a)
+	bMs, bSeq := splitID(b)
+	if aMs != bMs {
+		return aMs < bMs
+	}
+	return aSeq < bSeq
+}
+
+func splitID(id string) (uint64, uint64) {
+	ms, seq, _ := strings.Cut(id, "-")
+	msVal, _ := strconv.ParseUint(ms, 10, 64)
+	seqVal, _ := strconv.ParseUint(seq, 10, 64)
+	return msVal, seqVal
+}
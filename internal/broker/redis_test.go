@@ -0,0 +1,21 @@
+package broker
+
+import "testing"
+
+func TestSameSlot(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"worker:{w1}:tasks", "worker:{w1}:processing", true},
+		{"worker:{w1}:results", "tenant:{default}:results", false},
+		{"dtps:worker:{w1}:tasks", "dtps:worker:{w1}:results", true},
+		{"a:{}:b", "c:{}:d", false},
+		{"a:{x", "b:{x", false},
+	}
+	for _, test := range tests {
+		if got := sameSlot(test.a, test.b); got != test.want {
+			t.Errorf("sameSlot(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
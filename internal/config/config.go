@@ -3,17 +3,18 @@ package monitoring
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"html/template"
 	"net/http"
 	"sync"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
 )
 
 type Dashboard struct {
-	redis     *redis.Client
+	broker    broker.Broker
 	templates *template.Template
 	metrics   sync.Map
 }
@@ -37,10 +38,10 @@ type WorkerStatus struct {
 	Status         string    `json:"status"`
 }
 
-func NewDashboard(redis *redis.Client) *Dashboard {
+func NewDashboard(b broker.Broker) *Dashboard {
 	tmpl := template.Must(template.ParseFiles("templates/dashboard.html"))
 	return &Dashboard{
-		redis:     redis,
+		broker:    b,
 		templates: tmpl,
 	}
 }
@@ -65,18 +66,21 @@ func (d *Dashboard) collectMetrics() {
 			WorkerMetrics: make(map[string]*WorkerStatus),
 		}
 
-		// Collect queue lengths per priority
-		for priority := 1; priority <= 10; priority++ {
-			queueKey := fmt.Sprintf("tasks:priority:%d", priority)
-			length, err := d.redis.ZCard(context.Background(), queueKey).Result()
-			if err == nil {
-				metrics.QueueLengths[priority] = length
-				metrics.TotalTasks += length
+		// Collect queue lengths per priority, across tenants
+		tenants, _ := tenant.List(context.Background(), d.broker)
+		levels := queue.CurrentClasses(context.Background(), d.broker).Levels()
+		for _, name := range tenants {
+			for _, priority := range levels {
+				length, err := d.broker.SortedLen(context.Background(), queue.PriorityKey(name, priority))
+				if err == nil {
+					metrics.QueueLengths[priority] += length
+					metrics.TotalTasks += length
+				}
 			}
 		}
 
 		// Collect worker metrics
-		workers, _ := d.redis.HGetAll(context.Background(), "worker:metrics").Result()
+		workers, _ := d.broker.HashGetAll(context.Background(), broker.MetricsKey)
 		for workerID, data := range workers {
 			var status WorkerStatus
 			if err := json.Unmarshal([]byte(data), &status); err == nil {
@@ -0,0 +1,100 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+const (
+	// How often queued tasks are checked for promotion
+	agingInterval = time.Second
+	// How far into each priority queue aging looks per check
+	agingScan = 100
+)
+
+// ageTasks moves tasks that waited long enough up to the queue of the
+// priority they earned, so low priorities are not starved by a steady
+// stream of higher ones. The original priority stays on the task.
+func (c *Coordinator) ageTasks(ctx context.Context) {
+	ticker := time.NewTicker(agingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tenants, err := tenant.List(ctx, c.broker)
+		if err != nil {
+			continue
+		}
+
+		promoted := 0
+		for _, name := range tenants {
+			for _, priority := range c.classes.Levels() {
+				if priority < c.agingCap {
+					promoted += c.promote(ctx, name, priority)
+				}
+			}
+		}
+		if promoted > 0 {
+			wake(c.wakeDistribute)
+		}
+	}
+}
+
+// promote moves the due tasks at the head of one queue and returns how
+// many it moved.
+func (c *Coordinator) promote(ctx context.Context, name string, priority int) int {
+	key := queue.PriorityKey(name, priority)
+	queued, err := c.broker.SortedRange(ctx, key, 0, agingScan-1)
+	if err != nil {
+		return 0
+	}
+
+	now := time.Now()
+	promoted := 0
+	for _, taskStr := range queued {
+		var t task.Task
+		if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+			continue
+		}
+		// Demoted tasks stay where they are
+		if t.Demoted() {
+			continue
+		}
+		aged := c.classes.Raise(t.Priority, t.AgingSteps(c.agingRate, now), c.agingCap)
+		if aged <= priority {
+			continue
+		}
+
+		// Whoever removes it from the queue owns it
+		removed, err := c.broker.SortedRemove(ctx, key, taskStr)
+		if err != nil || !removed {
+			continue
+		}
+
+		// The task keeps its score, and with it its place by submission
+		// or deadline
+		current := t.EffectivePriority
+		t.EffectivePriority = aged
+		if err := c.scheduler.Requeue(ctx, &t); err != nil {
+			c.logger.Printf("Failed to age task %s: %v", t.ID, err)
+			t.EffectivePriority = current
+			c.scheduler.Requeue(ctx, &t)
+			continue
+		}
+
+		c.logger.Printf("Aged task %s from priority %d to %d after waiting %s",
+			t.ID, priority, aged, now.Sub(t.CreatedAt).Round(time.Second))
+		promoted++
+	}
+	return promoted
+}
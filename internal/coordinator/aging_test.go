@@ -0,0 +1,133 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// TestAging schedules tasks in the listed order, ages the priority 4 queue
+// once with a rate of one minute and checks the order tasks come off the
+// queues in, and the priority each one ended up with.
+func TestAging(t *testing.T) {
+	type submission struct {
+		name     string
+		priority int
+		age      time.Duration // since creation
+		deadline time.Duration // from now, none when zero
+	}
+	tests := []struct {
+		name      string
+		ordering  string
+		limit     int // highest level aging reaches, the highest class when zero
+		tasks     []submission
+		want      []string
+		effective map[string]int
+	}{
+		{
+			name:      "not yet due",
+			ordering:  queue.FIFO,
+			tasks:     []submission{{"a", 5, 0, 0}, {"old", 4, 30 * time.Second, 0}},
+			want:      []string{"a", "old"},
+			effective: map[string]int{"a": 5, "old": 4},
+		},
+		{
+			name:      "fifo keeps its place",
+			ordering:  queue.FIFO,
+			tasks:     []submission{{"a", 5, 0, 0}, {"old", 4, 90 * time.Second, 0}, {"b", 5, 0, 0}, {"c", 4, 0, 0}},
+			want:      []string{"a", "old", "b", "c"},
+			effective: map[string]int{"a": 5, "old": 5, "b": 5, "c": 4},
+		},
+		{
+			name:      "one level per interval",
+			ordering:  queue.FIFO,
+			tasks:     []submission{{"a", 6, 0, 0}, {"b", 5, 0, 0}, {"old", 4, 150 * time.Second, 0}},
+			want:      []string{"a", "old", "b"},
+			effective: map[string]int{"a": 6, "b": 5, "old": 6},
+		},
+		{
+			name:      "stops at the limit",
+			ordering:  queue.FIFO,
+			limit:     5,
+			tasks:     []submission{{"a", 5, 0, 0}, {"old", 4, 10 * time.Minute, 0}},
+			want:      []string{"a", "old"},
+			effective: map[string]int{"a": 5, "old": 5},
+		},
+		{
+			name:     "edf keeps its place",
+			ordering: queue.EDF,
+			tasks: []submission{
+				{"a", 5, 0, time.Hour}, {"b", 5, 0, 3 * time.Hour}, {"old", 4, 90 * time.Second, 2 * time.Hour}, {"c", 4, 0, 30 * time.Minute},
+			},
+			want:      []string{"a", "old", "b", "c"},
+			effective: map[string]int{"a": 5, "b": 5, "old": 5, "c": 4},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := broker.NewMemory()
+			defer b.Close()
+
+			ordering := queue.Ordering{Mode: test.ordering, SlackSeconds: int64(time.Hour.Seconds())}
+			if err := ordering.Store(ctx, b); err != nil {
+				t.Fatalf("storing ordering: %v", err)
+			}
+			c := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(b), WithOrdering(ordering), WithAging(time.Minute, test.limit))
+
+			now := time.Now()
+			// Deadlines come in whole seconds, as from the API
+			base := now.Truncate(time.Second)
+			original := make(map[string]int)
+			for _, s := range test.tasks {
+				original[s.name] = s.priority
+				tk := task.NewTask("test", []byte(s.name))
+				tk.CreatedAt = now.Add(-s.age)
+				opts := &task.ScheduleOptions{Priority: s.priority}
+				if s.deadline != 0 {
+					deadline := base.Add(s.deadline)
+					opts.Deadline = &deadline
+				}
+				if err := c.scheduler.ScheduleTask(ctx, tk, opts); err != nil {
+					t.Fatalf("ScheduleTask %s: %v", s.name, err)
+				}
+			}
+
+			c.promote(ctx, tenant.Default, 4)
+
+			var got []string
+			effective := make(map[string]int)
+			for {
+				tk, err := c.scheduler.GetNextTask(ctx)
+				if errors.Is(err, broker.ErrNotFound) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("GetNextTask: %v", err)
+				}
+				name := string(tk.Payload)
+				got = append(got, name)
+				effective[name] = tk.QueuePriority()
+				if tk.Priority != original[name] {
+					t.Fatalf("%s: original priority changed to %d", name, tk.Priority)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			if !reflect.DeepEqual(effective, test.effective) {
+				t.Fatalf("priorities %v, want %v", effective, test.effective)
+			}
+		})
+	}
+}
@@ -5,21 +5,67 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
 	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
 )
 
 type Coordinator struct {
 	logger   *log.Logger
-	redis    *redis.Client
-	workers  sync.Map
+	broker   broker.Broker
+	strategy Strategy
+	dispatch string
+	backend  string
+	recover  bool
+	ordering queue.Ordering
+	classes  queue.Classes
+	fairness string
+	fair     map[int]*fairState // only touched by distributeWork
+
+	// Priority aging, off while agingRate is 0
+	agingRate time.Duration
+	agingCap  int
+
+	// Tasks at or above this level preempt lower ones, off while 0
+	preemptLevel int
+	// Task IDs involved in recent preemptions, only touched by
+	// distributeWork
+	preempting map[string]time.Time
+
+	mu       sync.RWMutex
+	workers  map[string]*registry.WorkerRecord
+	members  []*registry.WorkerRecord // workers sorted by ID, rebuilt on change
 	shutdown chan struct{}
+
+	// Requeues tasks the way they were submitted
+	scheduler *task.Scheduler
+
+	// Signals from the event listener
+	wakeDistribute chan struct{}
+	wakeMonitor    chan struct{}
+	finished       chan string
 }
 
+const (
+	// How far into each priority queue distributeWork looks per tick
+	scanWindow = 50
+	// How many tasks per queue are assigned per pass
+	assignBatch = 5
+	// How often loops run without being woken by an event
+	pollInterval = 5 * time.Second
+	// How often task streams are trimmed
+	streamTrimInterval = 30 * time.Second
+)
+
 type Option func(*Coordinator)
 
 func WithLogger(logger *log.Logger) Option {
@@ -28,55 +74,207 @@ func WithLogger(logger *log.Logger) Option {
 	}
 }
 
-func WithRedis(url string) Option {
+// WithRedis connects to Redis, see broker.ParseRedisURL for building the
+// options from a URL.
+func WithRedis(opts *broker.RedisOptions) Option {
+	return func(c *Coordinator) {
+		c.broker = broker.NewRedis(opts)
+	}
+}
+
+// WithBroker shares an existing broker, e.g. an in-memory one the API
+// server and workers use as well.
+func WithBroker(b broker.Broker) Option {
+	return func(c *Coordinator) {
+		c.broker = b
+	}
+}
+
+// WithStrategy sets how workers are chosen for tasks. Defaults to
+// round-robin.
+func WithStrategy(strategy Strategy) Option {
+	return func(c *Coordinator) {
+		c.strategy = strategy
+	}
+}
+
+// WithDispatchMode selects whether the coordinator pushes tasks to
+// workers or leaves workers to pull them. Defaults to push.
+func WithDispatchMode(mode string) Option {
+	return func(c *Coordinator) {
+		c.dispatch = mode
+	}
+}
+
+// WithQueueBackend selects the queue layout. With streams, workers read
+// tasks through consumer groups and the coordinator only collects results
+// and trims the streams. Defaults to sorted sets.
+func WithQueueBackend(backend string) Option {
+	return func(c *Coordinator) {
+		c.backend = backend
+	}
+}
+
+// WithOrdering sets how tasks are ordered within each priority queue.
+// Defaults to FIFO.
+func WithOrdering(ordering queue.Ordering) Option {
+	return func(c *Coordinator) {
+		c.ordering = ordering
+	}
+}
+
+// WithPriorityClasses sets the priority classes tasks are queued by.
+// Defaults to queue.DefaultClasses.
+func WithPriorityClasses(classes queue.Classes) Option {
+	return func(c *Coordinator) {
+		c.classes = classes
+	}
+}
+
+// WithFairness sets how tasks of the same priority share the workers in
+// push dispatch. See FairnessModes.
+func WithFairness(mode string) Option {
+	return func(c *Coordinator) {
+		c.fairness = mode
+	}
+}
+
+// WithAging raises a queued task's priority by one class for every rate
+// it waits, up to the level limit, or the highest class if limit is 0.
+// Off by default.
+func WithAging(rate time.Duration, limit int) Option {
 	return func(c *Coordinator) {
-		c.redis = redis.NewClient(&redis.Options{
-			Addr: url,
-		})
+		c.agingRate = rate
+		c.agingCap = limit
+	}
+}
+
+// WithPreemption lets queued tasks at or above level stop running tasks
+// of lower priority when no worker has room for them. Off by default.
+func WithPreemption(level int) Option {
+	return func(c *Coordinator) {
+		c.preemptLevel = level
+	}
+}
+
+// WithRecovery keeps the queues, results and assignments left by a
+// previous run instead of clearing them on start. Tasks held by workers
+// that are gone are requeued once their heartbeat expires.
+func WithRecovery(enabled bool) Option {
+	return func(c *Coordinator) {
+		c.recover = enabled
 	}
 }
 
 func New(opts ...Option) *Coordinator {
 	c := &Coordinator{
+		strategy: &roundRobin{},
+		dispatch: worker.DispatchPush,
+		backend:  queue.SortedSets,
+		ordering: queue.Ordering{Mode: queue.FIFO},
+		classes:  queue.DefaultClasses(),
+		fairness: FairNone,
+		fair:     make(map[int]*fairState),
+		workers:  make(map[string]*registry.WorkerRecord),
 		shutdown: make(chan struct{}),
+
+		wakeDistribute: make(chan struct{}, 1),
+		wakeMonitor:    make(chan struct{}, 1),
+		finished:       make(chan string, 1024),
+
+		preempting: make(map[string]time.Time),
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.agingCap == 0 {
+		c.agingCap = c.classes.Highest()
+	}
+	c.scheduler = task.NewScheduler(c.broker)
 
 	return c
 }
 
+// snapshot returns the known workers in ID order. The slice is shared and
+// must not be modified.
+func (c *Coordinator) snapshot() []*registry.WorkerRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.members
+}
+
+func (c *Coordinator) storeWorker(record *registry.WorkerRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers[record.ID] = record
+	c.rebuildMembers()
+}
+
+func (c *Coordinator) deleteWorker(workerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.workers, workerID)
+	c.rebuildMembers()
+}
+
+// setWorkers replaces the known workers in one go.
+func (c *Coordinator) setWorkers(workers map[string]*registry.WorkerRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers = workers
+	c.rebuildMembers()
+}
+
+func (c *Coordinator) rebuildMembers() {
+	members := make([]*registry.WorkerRecord, 0, len(c.workers))
+	for _, record := range c.workers {
+		members = append(members, record)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	c.members = members
+}
+
 func (c *Coordinator) cleanup(ctx context.Context) error {
-	pipe := c.redis.Pipeline()
+	tenants, err := tenant.List(ctx, c.broker)
+	if err != nil {
+		return err
+	}
 
-	// Clear all priority queues
-	for priority := 1; priority <= 10; priority++ {
-		pipe.Del(ctx, fmt.Sprintf("tasks:priority:%d", priority))
+	// Clear all priority queues and results. Task streams are kept, their
+	// entries are redelivered to the consumer group
+	keys := queue.PriorityKeys(c.classes.Levels(), tenants)
+	for _, name := range tenants {
+		keys = append(keys, tenant.Keys(name)...)
 	}
 
 	// Get all workers to clean their data
-	workers, err := c.redis.HGetAll(ctx, "workers").Result()
+	workers, err := c.broker.HashGetAll(ctx, broker.WorkersKey)
 	if err != nil {
 		return fmt.Errorf("failed to get workers: %w", err)
 	}
 
 	// Clean up worker data
 	for workerID := range workers {
-		pipe.Del(ctx, fmt.Sprintf("worker:%s:tasks", workerID))
-		pipe.Del(ctx, fmt.Sprintf("worker:%s:results", workerID))
-		pipe.Del(ctx, fmt.Sprintf("worker:%s:processing", workerID))
+		keys = append(keys, broker.WorkerKeys(workerID)...)
+	}
+	left, _ := c.broker.SetMembers(ctx, worker.LeftKey)
+	for _, workerID := range left {
+		keys = append(keys, broker.WorkerResultsKey(workerID))
 	}
 
 	// Clean up global keys
-	pipe.Del(ctx, "workers")
-	pipe.Del(ctx, "results")
-	pipe.Del(ctx, "failed_tasks")
-
-	// Execute pipeline
-	_, err = pipe.Exec(ctx)
-	if err != nil {
+	keys = append(keys,
+		broker.WorkersKey,
+		registry.InfoKey,
+		worker.DrainingKey,
+		worker.LeftKey,
+		SharesKey,
+	)
+
+	if err := c.broker.Delete(ctx, keys...); err != nil {
 		return fmt.Errorf("failed to execute cleanup: %w", err)
 	}
 
@@ -85,14 +283,47 @@ func (c *Coordinator) cleanup(ctx context.Context) error {
 }
 
 func (c *Coordinator) Start(ctx context.Context) error {
-	// Clean up any existing state
-	if err := c.cleanup(ctx); err != nil {
+	// Clean up any existing state, unless picking up where the last run
+	// stopped
+	if c.recover {
+		c.logger.Printf("Recovering system state from the previous run")
+	} else if err := c.cleanup(ctx); err != nil {
 		c.logger.Printf("Warning: Failed to cleanup system state: %v", err)
 	}
 
-	go c.distributeWork(ctx)
+	if err := c.publishStrategy(ctx); err != nil {
+		c.logger.Printf("Warning: Failed to publish assignment strategy: %v", err)
+	}
+	c.logger.Printf("Serving priority classes %s", c.classes)
+
+	switch {
+	case c.backend == queue.Streams:
+		c.logger.Printf("Workers consume the task streams")
+		go c.maintainStreams(ctx)
+	case c.dispatch == worker.DispatchPull:
+		c.logger.Printf("Workers pull tasks from the priority queues")
+		go c.reportUnschedulable(ctx)
+	default:
+		c.logger.Printf("Assigning tasks with the %s strategy", c.strategy.Name())
+		if c.fairness != FairNone {
+			c.logger.Printf("Sharing each priority between %s flows by weight", c.fairness)
+		}
+		go c.distributeWork(ctx)
+	}
+	if c.agingRate > 0 {
+		c.logger.Printf("Raising waiting tasks one priority class every %s, up to level %d", c.agingRate, c.agingCap)
+		go c.ageTasks(ctx)
+	}
+	if c.backend != queue.Streams {
+		go c.sweepDeadlines(ctx)
+	}
+	if c.preemptLevel > 0 {
+		c.logger.Printf("Tasks at priority %d and above preempt lower ones when workers are full", c.preemptLevel)
+	}
 	go c.collectResults(ctx)
+	go c.enforceQuotas(ctx)
 	go c.monitorWorkers(ctx)
+	go c.listen(ctx)
 
 	select {
 	case <-ctx.Done():
@@ -103,7 +334,7 @@ func (c *Coordinator) Start(ctx context.Context) error {
 }
 
 func (c *Coordinator) distributeWork(ctx context.Context) {
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -111,87 +342,424 @@ func (c *Coordinator) distributeWork(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// Get active workers
-			var availableWorkers []string
-			c.workers.Range(func(key, value interface{}) bool {
-				workerID := key.(string)
-				availableWorkers = append(availableWorkers, workerID)
-				return true
-			})
-
-			if len(availableWorkers) == 0 {
+		case <-c.wakeDistribute:
+		}
+
+		// Keep going while tasks are being placed, the batch size only
+		// bounds a single pass
+		if c.assignPending(ctx) > 0 {
+			wake(c.wakeDistribute)
+		}
+	}
+}
+
+// assignPending assigns queued tasks to workers and returns how many it
+// assigned.
+func (c *Coordinator) assignPending(ctx context.Context) int {
+	// Draining workers must not receive new work
+	draining, err := c.broker.SetMembers(ctx, worker.DrainingKey)
+	if err != nil {
+		return 0
+	}
+	isDraining := make(map[string]bool, len(draining))
+	for _, workerID := range draining {
+		isDraining[workerID] = true
+	}
+
+	// Get active workers with their current load. Tasks held by draining
+	// workers still count against their tenant's quota.
+	all, err := c.loadCandidates(ctx)
+	if err != nil {
+		c.logger.Printf("Failed to load worker load: %v", err)
+		return 0
+	}
+	candidates := make([]*Candidate, 0, len(all))
+	for _, candidate := range all {
+		if !isDraining[candidate.Record.ID] {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return 0
+	}
+
+	tenants, err := tenant.List(ctx, c.broker)
+	if err != nil {
+		c.logger.Printf("Failed to load tenants: %v", err)
+		return 0
+	}
+	quotas, err := tenant.LoadQuotas(ctx, c.broker)
+	if err != nil {
+		c.logger.Printf("Failed to load tenant quotas: %v", err)
+		return 0
+	}
+	running := runningByTenant(all)
+	admitted := tenant.Admitted(tenants, c.throttle(ctx, quotas, running))
+
+	p := &pass{
+		candidates: candidates,
+		quotas:     quotas,
+		running:    running,
+		notify:     make(map[string]bool),
+	}
+	total := 0
+
+	if c.fairness != FairNone {
+		// Priorities stay strict, flows share each priority by weight
+		weights := c.loadWeights(ctx)
+		for _, priority := range c.classes.Levels() {
+			if flows := c.loadFlows(ctx, priority, admitted, weights); len(flows) > 0 {
+				total += c.assignFair(ctx, p, priority, flows)
+			}
+		}
+	} else {
+		// Try getting tasks from highest to lowest priority, each tenant's
+		// queue in turn
+		for _, queueKey := range queue.PriorityKeys(c.classes.Levels(), admitted) {
+			// Look past the head of the queue so unschedulable tasks
+			// do not block the ones behind them
+			result, err := c.broker.SortedRange(ctx, queueKey, 0, scanWindow-1)
+			if err != nil || len(result) == 0 {
 				continue
 			}
 
-			// Try getting tasks from highest to lowest priority
-			for priority := 10; priority > 0; priority-- {
-				queueKey := fmt.Sprintf("tasks:priority:%d", priority)
+			assigned := 0
+			for _, taskStr := range result {
+				if assigned == assignBatch {
+					break
+				}
+
+				var currentTask task.Task
+				if err := json.Unmarshal([]byte(taskStr), &currentTask); err != nil {
+					c.logger.Printf("Error unmarshaling task: %v", err)
+					continue
+				}
+
+				// Stop at the tenant's running quota, counting what this
+				// pass assigned
+				if p.overQuota(tenant.Name(currentTask.Tenant)) {
+					break
+				}
+
+				if c.place(ctx, p, queueKey, taskStr, &currentTask) {
+					assigned++
+				}
+			}
+			total += assigned
+		}
+	}
+
+	// Wake the workers that received tasks
+	for workerID := range p.notify {
+		events.Publish(ctx, c.broker, events.Event{Type: events.TaskAssigned, WorkerID: workerID})
+	}
+	return total
+}
+
+// pass is what one assignPending pass knows about the workers and tenants,
+// updated as it assigns tasks.
+type pass struct {
+	candidates []*Candidate
+	quotas     map[string]*tenant.Quota
+	running    map[string]int64
+	notify     map[string]bool
+}
+
+// overQuota reports whether the tenant holds as many tasks as its quota
+// allows.
+func (p *pass) overQuota(name string) bool {
+	q := p.quotas[name]
+	return q != nil && q.MaxRunning > 0 && p.running[name] >= q.MaxRunning
+}
+
+// place assigns a queued task to the worker the strategy picks, and
+// reports whether it did.
+func (c *Coordinator) place(ctx context.Context, p *pass, queueKey, taskStr string, currentTask *task.Task) bool {
+	// Let the strategy choose among the eligible workers
+	eligible, reason := eligibleWorkers(p.candidates, currentTask)
+	if len(eligible) == 0 {
+		if reason != "" {
+			c.markUnschedulable(ctx, currentTask, reason)
+		} else if c.preemptLevel > 0 && currentTask.QueuePriority() >= c.preemptLevel {
+			c.preemptFor(ctx, p, currentTask)
+		}
+		return false
+	}
+	chosen := c.strategy.Select(currentTask, eligible)
+	workerID := chosen.Record.ID
+
+	// Claim the task by removing it from the priority queue,
+	// so it is never handed out twice
+	removed, err := c.broker.SortedRemove(ctx, queueKey, taskStr)
+	if err != nil || !removed {
+		return false
+	}
+
+	c.logger.Printf("Assigning task %s to worker %s", currentTask.ID, workerID)
+
+	// Assign task to worker
+	err = c.broker.HashSet(ctx, broker.WorkerTasksKey(workerID), currentTask.ID, taskStr)
+
+	if err != nil {
+		c.logger.Printf("Failed to assign task to worker: %v", err)
+		c.scheduler.Requeue(ctx, currentTask)
+		return false
+	}
+
+	c.broker.HashDelete(ctx, tenant.UnschedulableKey(currentTask.Tenant), currentTask.ID)
+	chosen.Holding.AddTask(currentTask)
+	chosen.Outstanding++
+	p.running[tenant.Name(currentTask.Tenant)]++
+	c.recordAssignment(ctx, workerID)
+	p.notify[workerID] = true
+	return true
+}
+
+// maintainStreams trims entries every consumer group is done with.
+func (c *Coordinator) maintainStreams(ctx context.Context) {
+	ticker := time.NewTicker(streamTrimInterval)
+	defer ticker.Stop()
 
-				// Try to get up to 5 tasks at once
-				result, err := c.redis.ZRange(ctx, queueKey, 0, 4).Result()
-				if err != nil || len(result) == 0 {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tenants, err := tenant.List(ctx, c.broker)
+			if err != nil {
+				continue
+			}
+			for _, key := range queue.StreamKeys(c.classes.Levels(), tenants) {
+				trimmed, err := c.broker.StreamTrim(ctx, key)
+				if err != nil {
+					c.logger.Printf("Failed to trim %s: %v", key, err)
 					continue
 				}
+				if trimmed > 0 {
+					c.logger.Printf("Trimmed %d acknowledged entries from %s", trimmed, key)
+				}
+			}
+		}
+	}
+}
+
+// reportUnschedulable flags queued tasks that no worker can run while
+// workers pull their own work. Workers clear the flag when they claim a
+// task.
+func (c *Coordinator) reportUnschedulable(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			candidates, err := c.loadCandidates(ctx)
+			if err != nil || len(candidates) == 0 {
+				continue
+			}
+			tenants, err := tenant.List(ctx, c.broker)
+			if err != nil {
+				continue
+			}
 
-				c.logger.Printf("Found %d tasks in priority %d queue", len(result), priority)
+			for _, queueKey := range queue.PriorityKeys(c.classes.Levels(), tenants) {
+				result, err := c.broker.SortedRange(ctx, queueKey, 0, scanWindow-1)
+				if err != nil {
+					continue
+				}
 
-				// Process each task
 				for _, taskStr := range result {
 					var currentTask task.Task
 					if err := json.Unmarshal([]byte(taskStr), &currentTask); err != nil {
-						c.logger.Printf("Error unmarshaling task: %v", err)
 						continue
 					}
+					if _, reason := eligibleWorkers(candidates, &currentTask); reason != "" {
+						c.markUnschedulable(ctx, &currentTask, reason)
+					}
+				}
+			}
+		}
+	}
+}
 
-					// Pick a worker (round-robin)
-					workerID := availableWorkers[0]
-					availableWorkers = append(availableWorkers[1:], availableWorkers[0])
-
-					c.logger.Printf("Assigning task %s to worker %s", currentTask.ID, workerID)
+// loadCandidates returns the workers that may receive work, in ID order,
+// with what each currently holds.
+func (c *Coordinator) loadCandidates(ctx context.Context) ([]*Candidate, error) {
+	members := c.snapshot()
+	candidates := make([]*Candidate, 0, len(members))
+	keys := make([]string, 0, 2*len(members))
 
-					// Assign task to worker
-					err = c.redis.HSet(ctx,
-						fmt.Sprintf("worker:%s:tasks", workerID),
-						currentTask.ID,
-						taskStr,
-					).Err()
+	for _, record := range members {
+		candidates = append(candidates, &Candidate{Record: record, Holding: worker.NewHolding()})
+		keys = append(keys, broker.WorkerTasksKey(record.ID), broker.WorkerProcessingKey(record.ID))
+	}
 
-					if err != nil {
-						c.logger.Printf("Failed to assign task to worker: %v", err)
-						continue
-					}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	held, err := c.broker.HashValues(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
 
-					// Remove task from priority queue
-					c.redis.ZRem(ctx, queueKey, taskStr)
-				}
+	for i, candidate := range candidates {
+		for _, tasks := range held[2*i : 2*i+2] {
+			for _, taskStr := range tasks {
+				candidate.Holding.Add(taskStr)
 			}
 		}
+		candidate.Outstanding = int64(candidate.Holding.Tasks)
+	}
+	return candidates, nil
+}
+
+// eligibleWorkers filters the candidates down to those that may run t now.
+// When none qualify, reason explains why the task is unschedulable, or is
+// empty if it only has to wait for capacity or an anti-affinity conflict
+// to clear.
+func eligibleWorkers(candidates []*Candidate, t *task.Task) (eligible []*Candidate, reason string) {
+	supported, matched := false, false
+
+	for _, candidate := range candidates {
+		record := candidate.Record
+		if !record.Supports(t.Type) {
+			continue
+		}
+		supported = true
+
+		if !t.MatchesLabels(record.Labels) {
+			continue
+		}
+		matched = true
+
+		// Never oversubscribe a worker, nor give low priority tasks the
+		// slots it reserves
+		if !candidate.Holding.FitsOn(t, record) {
+			continue
+		}
+		if candidate.Holding.ConflictsWith(t) {
+			continue
+		}
+		eligible = append(eligible, candidate)
+	}
+
+	switch {
+	case len(eligible) > 0:
+		return eligible, ""
+	case !supported:
+		return nil, fmt.Sprintf("no active worker supports task type %q", t.Type)
+	case !matched:
+		return nil, "no active worker matches the task's node selector and affinity rules"
+	}
+	return nil, ""
+}
+
+// markUnschedulable records why a task stays queued. The first sighting is
+// kept so the API can report how long the task has been stuck.
+func (c *Coordinator) markUnschedulable(ctx context.Context, t *task.Task, reason string) {
+	entry, err := json.Marshal(&task.Unschedulable{
+		TaskID:   t.ID,
+		Type:     t.Type,
+		Priority: t.Priority,
+		Reason:   reason,
+		Since:    time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	added, err := c.broker.HashSetNew(ctx, tenant.UnschedulableKey(t.Tenant), t.ID, string(entry))
+	if err == nil && added {
+		c.logger.Printf("Task %s is unschedulable: %s", t.ID, reason)
 	}
 }
 
 func (c *Coordinator) collectResults(ctx context.Context) {
-	ticker := time.NewTicker(100 * time.Millisecond)
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
+	// Workers may have left while no coordinator was running
+	c.collectLeft(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			c.workers.Range(func(key, value interface{}) bool {
-				workerID := key.(string)
-				results, err := c.redis.HGetAll(ctx, fmt.Sprintf("worker:%s:results", workerID)).Result()
-				if err != nil {
-					return true
+			for _, record := range c.snapshot() {
+				c.collectFrom(ctx, record.ID)
+			}
+			c.collectLeft(ctx)
+		case workerID := <-c.finished:
+			// Collect from each worker once, however many completions it
+			// announced in the meantime
+			pending := map[string]bool{workerID: true}
+			for more := true; more; {
+				select {
+				case id := <-c.finished:
+					pending[id] = true
+				default:
+					more = false
 				}
+			}
+			for id := range pending {
+				c.collectFrom(ctx, id)
+			}
+			c.collectLeft(ctx)
+		}
+	}
+}
 
-				for taskID, resultStr := range results {
-					c.redis.HSet(ctx, "results", taskID, resultStr)
-					c.redis.HDel(ctx, fmt.Sprintf("worker:%s:results", workerID), taskID)
-				}
+func (c *Coordinator) collectFrom(ctx context.Context, workerID string) {
+	results, err := c.broker.HashGetAll(ctx, broker.WorkerResultsKey(workerID))
+	if err != nil {
+		return
+	}
+
+	for taskID, resultStr := range results {
+		// Keep failures apart so they show up as failed tasks, in the
+		// stores of the task's tenant
+		var result task.Result
+		parsed := json.Unmarshal([]byte(resultStr), &result) == nil
+		resultsKey := tenant.ResultsKey(result.Tenant)
+		if result.Status == task.StatusFailed || result.Status == task.StatusExpired {
+			resultsKey = tenant.FailedKey(result.Tenant)
+		}
 
-				return true
-			})
+		// Store the result before taking it from the worker, and only
+		// account for it if this pass is the one that took it, since the
+		// monitor may collect the same worker at the same time
+		if err := c.broker.HashSet(ctx, resultsKey, taskID, resultStr); err != nil {
+			c.logger.Printf("Failed to collect result of task %s from worker %s: %v", taskID, workerID, err)
+			continue
+		}
+		claimed, err := c.broker.HashDelete(ctx, broker.WorkerResultsKey(workerID), taskID)
+		if err != nil {
+			c.logger.Printf("Failed to collect result of task %s from worker %s: %v", taskID, workerID, err)
+			continue
+		}
+		if claimed == 1 && parsed {
+			c.recordCompletion(ctx, &result)
+			c.recordUsage(ctx, &result)
+			c.recordDeadline(ctx, &result)
+		}
+	}
+}
+
+// collectLeft collects the results of workers that deregistered before
+// they were all collected, and forgets each worker once it has none left.
+func (c *Coordinator) collectLeft(ctx context.Context) {
+	left, err := c.broker.SetMembers(ctx, worker.LeftKey)
+	if err != nil {
+		return
+	}
+	for _, workerID := range left {
+		c.collectFrom(ctx, workerID)
+		if n, err := c.broker.HashLen(ctx, broker.WorkerResultsKey(workerID)); err == nil && n == 0 {
+			c.broker.SetRemove(ctx, worker.LeftKey, workerID)
 		}
 	}
 }
@@ -205,45 +773,117 @@ func (c *Coordinator) monitorWorkers(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			workers, err := c.redis.HGetAll(ctx, "workers").Result()
-			if err != nil {
-				continue
-			}
+		case <-c.wakeMonitor:
+		}
 
-			now := time.Now().Unix()
-			for workerID, lastSeenStr := range workers {
-				lastSeen, err := strconv.ParseInt(lastSeenStr, 10, 64)
-				if err != nil {
-					continue
-				}
+		if c.refreshWorkers(ctx) {
+			wake(c.wakeDistribute)
+		}
+	}
+}
 
-				if now-lastSeen <= 30 {
-					c.workers.Store(workerID, time.Unix(lastSeen, 0))
-				} else {
-					c.workers.Delete(workerID)
-					c.redis.HDel(ctx, "workers", workerID)
+// refreshWorkers reloads the active workers, forgetting the ones that
+// stopped sending heartbeats. It reports whether it succeeded.
+func (c *Coordinator) refreshWorkers(ctx context.Context) bool {
+	workers, err := c.broker.HashGetAll(ctx, broker.WorkersKey)
+	if err != nil {
+		return false
+	}
 
-					tasks, _ := c.redis.HGetAll(ctx, fmt.Sprintf("worker:%s:tasks", workerID)).Result()
-					for _, taskStr := range tasks {
-						c.redis.RPush(ctx, "tasks", taskStr)
-					}
+	records, err := registry.Load(ctx, c.broker)
+	if err != nil {
+		c.logger.Printf("Failed to load worker records: %v", err)
+	}
 
-					c.redis.Del(ctx, fmt.Sprintf("worker:%s:tasks", workerID))
-					c.redis.Del(ctx, fmt.Sprintf("worker:%s:results", workerID))
-				}
+	// Rebuilt from scratch so workers that deregistered are forgotten
+	active := make(map[string]*registry.WorkerRecord, len(workers))
+	now := time.Now().Unix()
+	for workerID, lastSeenStr := range workers {
+		lastSeen, err := strconv.ParseInt(lastSeenStr, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if now-lastSeen <= 30 {
+			record, ok := records[workerID]
+			if !ok {
+				// Unregistered workers are assumed to run anything
+				record = &registry.WorkerRecord{ID: workerID}
 			}
+			active[workerID] = record
+		} else {
+			c.broker.HashDelete(ctx, broker.WorkersKey, workerID)
+			c.broker.HashDelete(ctx, registry.InfoKey, workerID)
+
+			// Keep the results the dead worker finished
+			c.collectFrom(ctx, workerID)
+
+			// Return everything the dead worker held to its queue. Stream
+			// entries are reclaimed by the rest of the consumer group.
+			requeued := c.requeueHeld(ctx, broker.WorkerTasksKey(workerID)) +
+				c.requeueHeld(ctx, broker.WorkerProcessingKey(workerID))
+			if requeued > 0 {
+				c.logger.Printf("Requeued %d tasks from dead worker %s", requeued, workerID)
+				events.Publish(ctx, c.broker, events.Event{Type: events.TaskSubmitted})
+			}
+
+			c.broker.Delete(ctx, broker.WorkerKeys(workerID)...)
 		}
 	}
+	c.setWorkers(active)
+	return true
+}
+
+// requeueHeld puts the tasks in one of a worker's task hashes back on
+// their priority queues.
+func (c *Coordinator) requeueHeld(ctx context.Context, key string) int {
+	// Tasks delivered from a stream stay pending there until reclaimed
+	if c.backend == queue.Streams {
+		return 0
+	}
+
+	held, err := c.broker.HashValues(ctx, key)
+	if err != nil {
+		return 0
+	}
+	tasks := held[0]
+
+	requeued := 0
+	for _, taskStr := range tasks {
+		var t task.Task
+		if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+			continue
+		}
+		t.Status = task.StatusPending
+		t.WorkerID = ""
+		if err := c.scheduler.Requeue(ctx, &t); err != nil {
+			c.logger.Printf("Failed to requeue task %s: %v", t.ID, err)
+			continue
+		}
+		requeued++
+	}
+	return requeued
 }
 
 func (c *Coordinator) RegisterWorker(id string) {
 	now := time.Now()
-	c.redis.HSet(context.Background(), "workers", id, now.Unix())
-	c.workers.Store(id, now)
+	c.broker.HashSet(context.Background(), broker.WorkersKey, id, strconv.FormatInt(now.Unix(), 10))
+	c.storeWorker(&registry.WorkerRecord{ID: id, StartedAt: now, LastHeartbeat: now})
 }
 
 func (c *Coordinator) UpdateWorkerHeartbeat(id string) {
 	now := time.Now()
-	c.redis.HSet(context.Background(), "workers", id, now.Unix())
-	c.workers.Store(id, now)
+	c.broker.HashSet(context.Background(), broker.WorkersKey, id, strconv.FormatInt(now.Unix(), 10))
+
+	c.mu.RLock()
+	existing, ok := c.workers[id]
+	c.mu.RUnlock()
+
+	record := &registry.WorkerRecord{ID: id, StartedAt: now}
+	if ok {
+		updated := *existing
+		record = &updated
+	}
+	record.LastHeartbeat = now
+	c.storeWorker(record)
 }
@@ -0,0 +1,283 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// TestRoundTrip runs a coordinator and a worker on the in-memory broker
+// and checks that submitted tasks come back as results, in every dispatch
+// mode and queue backend.
+func TestRoundTrip(t *testing.T) {
+	modes := []struct {
+		name     string
+		dispatch string
+		backend  string
+	}{
+		{"push", worker.DispatchPush, queue.SortedSets},
+		{"pull", worker.DispatchPull, queue.SortedSets},
+		{"streams", worker.DispatchPull, queue.Streams},
+	}
+
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			b := broker.NewMemory()
+			defer b.Close()
+			quiet := log.New(io.Discard, "", 0)
+
+			coord := New(
+				WithLogger(quiet),
+				WithBroker(b),
+				WithDispatchMode(mode.dispatch),
+				WithQueueBackend(mode.backend),
+			)
+			go coord.Start(ctx)
+
+			// The coordinator clears the state of earlier runs before it
+			// publishes its configuration, so only join after that
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if published, _ := b.Get(ctx, worker.DispatchKey); published == mode.dispatch {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("coordinator did not publish its configuration")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			w := worker.NewWorker(
+				worker.WithLogger(quiet),
+				worker.WithBroker(b),
+				worker.WithPoolSize(2),
+				worker.WithDispatchMode(mode.dispatch),
+				worker.WithQueueBackend(mode.backend),
+				worker.WithHandler("echo", worker.EchoHandler),
+			)
+			go w.Start(ctx)
+
+			scheduler := task.NewScheduler(b)
+			submitted := make(map[string]string)
+			for _, payload := range []string{"one", "two", "three"} {
+				tk := task.NewTask("echo", []byte(payload))
+				if err := scheduler.ScheduleTask(ctx, tk, &task.ScheduleOptions{Priority: 5}); err != nil {
+					t.Fatalf("ScheduleTask: %v", err)
+				}
+				submitted[tk.ID] = payload
+			}
+
+			for {
+				results, err := b.HashGetAll(ctx, tenant.ResultsKey(tenant.Default))
+				if err != nil {
+					t.Fatalf("loading results: %v", err)
+				}
+				if len(results) == len(submitted) {
+					for id, data := range results {
+						var result task.Result
+						if err := json.Unmarshal([]byte(data), &result); err != nil {
+							t.Fatalf("result of %s: %v", id, err)
+						}
+						if result.Status != task.StatusCompleted || string(result.Output) != submitted[id] {
+							t.Fatalf("result of %s: got status %s, output %q, want %q", id, result.Status, result.Output, submitted[id])
+						}
+					}
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					t.Fatalf("got %d of %d results", len(results), len(submitted))
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+		})
+	}
+}
+
+// TestDrainHandsOverResults checks that results a worker still holds when
+// it deregisters are collected and accounted for like any other, even
+// when no coordinator runs at the time.
+func TestDrainHandsOverResults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b := broker.NewMemory()
+	defer b.Close()
+	quiet := log.New(io.Discard, "", 0)
+
+	w := worker.NewWorker(
+		worker.WithLogger(quiet),
+		worker.WithBroker(b),
+		worker.WithDispatchMode(worker.DispatchPull),
+		worker.WithQueueBackend(queue.SortedSets),
+		worker.WithHandler("echo", worker.EchoHandler),
+	)
+	go w.Start(ctx)
+	for {
+		if ok, _ := b.HashExists(ctx, broker.WorkersKey, w.ID()); ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("worker did not register")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	expired, _ := json.Marshal(task.Result{
+		TaskID:   "expired",
+		Tenant:   tenant.Default,
+		Type:     "echo",
+		Status:   task.StatusExpired,
+		WorkerID: w.ID(),
+		Priority: 5,
+	})
+	if err := b.HashSet(ctx, broker.WorkerResultsKey(w.ID()), "expired", string(expired)); err != nil {
+		t.Fatalf("storing result: %v", err)
+	}
+	if err := w.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	coord := New(
+		WithLogger(quiet),
+		WithBroker(b),
+		WithDispatchMode(worker.DispatchPull),
+		WithRecovery(true),
+	)
+	go coord.Start(ctx)
+
+	for {
+		if ok, _ := b.HashExists(ctx, tenant.FailedKey(tenant.Default), "expired"); ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("result was not collected")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	stats, _ := b.HashGetAll(ctx, StrategyStatsKey(coord.statsName()))
+	if stats["expired"] != "1" {
+		t.Fatalf("expired tasks counted: got %q, want 1", stats["expired"])
+	}
+	misses, err := tenant.LoadDeadlineMisses(ctx, b, tenant.Default)
+	if err != nil || len(misses) != 1 || misses[0].Expired != 1 {
+		t.Fatalf("deadline misses: got %+v, %v", misses, err)
+	}
+	if left, _ := b.SetMembers(ctx, worker.LeftKey); len(left) != 0 {
+		t.Fatalf("left workers: got %v, want none", left)
+	}
+}
+
+// staleResults serves a snapshot of a worker's results, as a collector
+// that read them just before another one took them sees.
+type staleResults struct {
+	broker.Broker
+	key      string
+	snapshot map[string]string
+}
+
+func (s *staleResults) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	if key == s.key {
+		return s.snapshot, nil
+	}
+	return s.Broker.HashGetAll(ctx, key)
+}
+
+// TestCollectCountsOnce collects the same worker twice from the same
+// snapshot of its results, as the result collector and the monitor may,
+// and checks the result is accounted for once.
+func TestCollectCountsOnce(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+
+	expired, _ := json.Marshal(task.Result{
+		TaskID:   "expired",
+		Tenant:   tenant.Default,
+		Type:     "echo",
+		Status:   task.StatusExpired,
+		WorkerID: "w1",
+		Priority: 5,
+	})
+	key := broker.WorkerResultsKey("w1")
+	if err := b.HashSet(ctx, key, "expired", string(expired)); err != nil {
+		t.Fatalf("storing result: %v", err)
+	}
+	stale := &staleResults{Broker: b, key: key, snapshot: map[string]string{"expired": string(expired)}}
+
+	coord := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(stale))
+	coord.collectFrom(ctx, "w1")
+	coord.collectFrom(ctx, "w1")
+
+	if ok, _ := b.HashExists(ctx, tenant.FailedKey(tenant.Default), "expired"); !ok {
+		t.Fatal("result was not collected")
+	}
+	stats, _ := b.HashGetAll(ctx, StrategyStatsKey(coord.statsName()))
+	if stats["expired"] != "1" {
+		t.Fatalf("expired tasks counted: got %q, want 1", stats["expired"])
+	}
+	misses, err := tenant.LoadDeadlineMisses(ctx, b, tenant.Default)
+	if err != nil || len(misses) != 1 || misses[0].Expired != 1 {
+		t.Fatalf("deadline misses: got %+v, %v", misses, err)
+	}
+}
+
+// TestReservedSlots assigns one-slot tasks in order to a worker with 4
+// slots, 2 of them reserved for priority 10, and checks which are placed:
+// lower priorities stop at the unreserved slots, priority 10 may use all.
+func TestReservedSlots(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		want       []int
+	}{
+		{"low stops at unreserved", []int{5, 5, 5, 5}, []int{5, 5}},
+		{"high uses reserved", []int{10, 10, 10, 10, 10}, []int{10, 10, 10, 10}},
+		{"low then high", []int{1, 5, 7, 10, 10, 10}, []int{1, 5, 10, 10}},
+		{"high then low", []int{10, 10, 5, 5, 5}, []int{10, 10, 5, 5}},
+		{"high first keeps reserved", []int{10, 5, 5, 5, 10, 10}, []int{10, 5, 5, 10}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := &registry.WorkerRecord{
+				ID:          "w1",
+				Capacity:    registry.Capacity{Slots: 4},
+				Reservation: &registry.Reservation{Slots: 2, Priority: 10},
+			}
+			candidates := []*Candidate{{Record: record, Holding: worker.NewHolding()}}
+
+			var placed []int
+			for _, priority := range test.priorities {
+				tk := task.NewTask("echo", nil)
+				tk.Priority = priority
+				tk.Cost = registry.DefaultUnitsPerSlot
+				if eligible, _ := eligibleWorkers(candidates, tk); len(eligible) > 0 {
+					eligible[0].Holding.AddTask(tk)
+					placed = append(placed, priority)
+				}
+			}
+			if !reflect.DeepEqual(placed, test.want) {
+				t.Fatalf("placed %v, want %v", placed, test.want)
+			}
+		})
+	}
+}
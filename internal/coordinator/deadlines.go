@@ -0,0 +1,143 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// How often the queues are swept for tasks past their deadline
+const deadlineInterval = 5 * time.Second
+
+// sweepDeadlines handles queued tasks whose deadline passed by their
+// policy: dropped ones leave the queue as expired, demoted ones move to
+// the lowest priority and flagged ones are marked but keep their place.
+// Workers also refuse to start dropped tasks that slipped past a sweep.
+func (c *Coordinator) sweepDeadlines(ctx context.Context) {
+	ticker := time.NewTicker(deadlineInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tenants, err := tenant.List(ctx, c.broker)
+		if err != nil {
+			continue
+		}
+
+		for _, name := range tenants {
+			for _, priority := range c.classes.Levels() {
+				c.sweepQueue(ctx, name, priority)
+			}
+		}
+	}
+}
+
+func (c *Coordinator) sweepQueue(ctx context.Context, name string, priority int) {
+	key := queue.PriorityKey(name, priority)
+	queued, err := c.broker.SortedRange(ctx, key, 0, -1)
+	if err != nil {
+		return
+	}
+
+	for _, taskStr := range queued {
+		// Most tasks have no deadline, skip them without decoding
+		if !strings.Contains(taskStr, `"deadline":`) {
+			continue
+		}
+
+		var t task.Task
+		if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+			continue
+		}
+		if t.DeadlineMissed || !t.IsOverdue() {
+			continue
+		}
+
+		// Whoever removes it from the queue owns it
+		removed, err := c.broker.SortedRemove(ctx, key, taskStr)
+		if err != nil || !removed {
+			continue
+		}
+
+		if t.OnDeadlineMiss() == task.DeadlineDrop {
+			if err := c.expire(ctx, &t); err != nil {
+				c.logger.Printf("Failed to expire task %s: %v", t.ID, err)
+				c.scheduler.Requeue(ctx, &t)
+			}
+			continue
+		}
+
+		t.DeadlineMissed = true
+		if t.Demoted() {
+			t.EffectivePriority = c.classes.Lowest()
+		}
+		if err := c.scheduler.Requeue(ctx, &t); err != nil {
+			c.logger.Printf("Failed to requeue overdue task %s: %v", t.ID, err)
+			continue
+		}
+		if t.Demoted() {
+			c.logger.Printf("Demoted task %s to the lowest priority, its deadline passed at %s",
+				t.ID, t.Deadline.Format(time.RFC3339))
+			c.countMiss(ctx, t.Tenant, t.Type, t.Priority, tenant.MissDemoted)
+		}
+	}
+}
+
+// expire stores a queued task that will not run as expired, among its
+// tenant's failed tasks.
+func (c *Coordinator) expire(ctx context.Context, t *task.Task) error {
+	now := time.Now()
+	result := &task.Result{
+		TaskID:    t.ID,
+		Tenant:    t.Tenant,
+		Type:      t.Type,
+		Status:    task.StatusExpired,
+		Error:     fmt.Sprintf("deadline passed at %s while the task was queued", t.Deadline.Format(time.RFC3339)),
+		StartTime: now,
+		EndTime:   now,
+
+		Priority:          t.Priority,
+		EffectivePriority: t.QueuePriority(),
+		DeadlineMissed:    true,
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+	if err := c.broker.HashSet(ctx, tenant.FailedKey(t.Tenant), t.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to store result: %w", err)
+	}
+	c.broker.HashDelete(ctx, tenant.UnschedulableKey(t.Tenant), t.ID)
+
+	c.logger.Printf("Task %s expired in the queue, its deadline passed at %s",
+		t.ID, t.Deadline.Format(time.RFC3339))
+	c.countMiss(ctx, t.Tenant, t.Type, t.Priority, tenant.MissExpired)
+	return nil
+}
+
+// recordDeadline counts finished tasks that missed their deadline.
+func (c *Coordinator) recordDeadline(ctx context.Context, result *task.Result) {
+	switch {
+	case result.Status == task.StatusExpired:
+		c.countMiss(ctx, result.Tenant, result.Type, result.Priority, tenant.MissExpired)
+	case result.DeadlineMissed:
+		c.countMiss(ctx, result.Tenant, result.Type, result.Priority, tenant.MissLate)
+	}
+}
+
+func (c *Coordinator) countMiss(ctx context.Context, name, taskType string, priority int, outcome string) {
+	if err := tenant.RecordDeadlineMiss(ctx, c.broker, name, taskType, priority, outcome); err != nil {
+		c.logger.Printf("Failed to record deadline miss: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package coordinator
+
+import (
+	"context"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+)
+
+// listen turns cluster events into wake-ups for the coordinator's loops.
+func (c *Coordinator) listen(ctx context.Context) {
+	listener := events.Listen(ctx, c.broker,
+		events.Channel(events.TaskSubmitted, ""),
+		events.Channel(events.TaskCompleted, ""),
+		events.Channel(events.WorkerJoined, ""),
+		events.Channel(events.WorkerLeft, ""),
+	)
+	defer listener.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-listener.C:
+			if !ok {
+				return
+			}
+
+			switch e.Type {
+			case events.TaskSubmitted:
+				wake(c.wakeDistribute)
+			case events.TaskCompleted:
+				// The worker has room again and a result to collect
+				select {
+				case c.finished <- e.WorkerID:
+				default:
+				}
+				wake(c.wakeDistribute)
+			case events.WorkerLeft:
+				// The worker may have left results behind
+				select {
+				case c.finished <- e.WorkerID:
+				default:
+				}
+				wake(c.wakeMonitor)
+			case events.WorkerJoined:
+				wake(c.wakeMonitor)
+			}
+		}
+	}
+}
+
+// wake signals a loop without blocking; pending signals are merged.
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,207 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// Fairness modes decide how tasks of the same priority share the workers.
+const (
+	// Strictly by score, one tenant's queue after the other
+	FairNone = "none"
+	// Weighted fair queuing between tenants
+	FairTenant = "tenant"
+	// Weighted fair queuing between each tenant's task types
+	FairType = "type"
+)
+
+const (
+	// FairnessKey holds the fairness mode the coordinator runs with.
+	FairnessKey = "coordinator:fairness"
+	// WeightsKey holds the weights of flows, by flow name. Flows without
+	// one weigh 1.
+	WeightsKey = "fairness:weights"
+	// SharesKey counts the tasks assigned to each flow, as
+	// <priority>|<flow> fields.
+	SharesKey = "fairness:shares"
+)
+
+func FairnessModes() []string {
+	return []string{FairNone, FairTenant, FairType}
+}
+
+func ValidateFairness(mode string) error {
+	switch mode {
+	case FairNone, FairTenant, FairType:
+		return nil
+	default:
+		return fmt.Errorf("unknown fairness mode %q (available: %s)", mode, strings.Join(FairnessModes(), ", "))
+	}
+}
+
+// FlowName names the flow a task belongs to under a fairness mode: its
+// tenant, or its tenant and task type as <tenant>/<type>.
+func FlowName(mode string, t *task.Task) string {
+	if mode == FairType {
+		return tenant.Name(t.Tenant) + "/" + t.Type
+	}
+	return tenant.Name(t.Tenant)
+}
+
+// SetWeight stores a flow's weight. A weight of 1 is the default and
+// removes it.
+func SetWeight(ctx context.Context, b broker.Broker, flow string, weight float64) error {
+	if weight <= 0 {
+		return fmt.Errorf("weight must be positive")
+	}
+	if weight == 1 {
+		_, err := b.HashDelete(ctx, WeightsKey, flow)
+		return err
+	}
+	return b.HashSet(ctx, WeightsKey, flow, strconv.FormatFloat(weight, 'f', -1, 64))
+}
+
+// flow is the queued tasks of one flow within a priority, in score order.
+type flow struct {
+	name   string
+	tenant string
+	weight float64
+	tasks  []queuedTask
+}
+
+type queuedTask struct {
+	key  string
+	data string
+	task task.Task
+}
+
+// fairState is each flow's virtual time within a priority. A flow is
+// charged 1/weight per assigned task and the flow with the lowest virtual
+// time goes next, so under contention flows are served in proportion to
+// their weights. Flows that fall idle are forgotten and come back at the
+// priority's current virtual time, so idling earns no credit.
+type fairState struct {
+	clock float64
+	flows map[string]float64
+}
+
+func (c *Coordinator) loadWeights(ctx context.Context) map[string]float64 {
+	entries, err := c.broker.HashGetAll(ctx, WeightsKey)
+	if err != nil {
+		return nil
+	}
+
+	weights := make(map[string]float64, len(entries))
+	for name, value := range entries {
+		if weight, err := strconv.ParseFloat(value, 64); err == nil && weight > 0 {
+			weights[name] = weight
+		}
+	}
+	return weights
+}
+
+// loadFlows groups the queued tasks of one priority into flows.
+func (c *Coordinator) loadFlows(ctx context.Context, priority int, tenants []string, weights map[string]float64) []*flow {
+	byName := make(map[string]*flow)
+	var flows []*flow
+
+	for _, name := range tenants {
+		key := queue.PriorityKey(name, priority)
+		result, err := c.broker.SortedRange(ctx, key, 0, scanWindow-1)
+		if err != nil {
+			continue
+		}
+
+		for _, taskStr := range result {
+			var t task.Task
+			if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+				c.logger.Printf("Error unmarshaling task: %v", err)
+				continue
+			}
+
+			flowName := FlowName(c.fairness, &t)
+			f, ok := byName[flowName]
+			if !ok {
+				f = &flow{name: flowName, tenant: tenant.Name(t.Tenant), weight: 1}
+				if weight, ok := weights[flowName]; ok {
+					f.weight = weight
+				}
+				byName[flowName] = f
+				flows = append(flows, f)
+			}
+			f.tasks = append(f.tasks, queuedTask{key: key, data: taskStr, task: t})
+		}
+	}
+	return flows
+}
+
+// assignFair assigns one priority's tasks in weighted fair order and
+// returns how many it assigned.
+func (c *Coordinator) assignFair(ctx context.Context, p *pass, priority int, flows []*flow) int {
+	state, ok := c.fair[priority]
+	if !ok {
+		state = &fairState{flows: make(map[string]float64)}
+		c.fair[priority] = state
+	}
+
+	// Forget idle flows, start new ones at the current virtual time
+	active := make(map[string]bool, len(flows))
+	for _, f := range flows {
+		active[f.name] = true
+		if _, ok := state.flows[f.name]; !ok {
+			state.flows[f.name] = state.clock
+		}
+	}
+	for name := range state.flows {
+		if !active[name] {
+			delete(state.flows, name)
+		}
+	}
+
+	budget := assignBatch * len(flows)
+	assigned := 0
+	for assigned < budget && len(flows) > 0 {
+		// Serve the flow that is furthest behind
+		sort.SliceStable(flows, func(i, j int) bool {
+			vi, vj := state.flows[flows[i].name], state.flows[flows[j].name]
+			if vi != vj {
+				return vi < vj
+			}
+			return flows[i].name < flows[j].name
+		})
+		f := flows[0]
+
+		placed := false
+		for len(f.tasks) > 0 && !p.overQuota(f.tenant) {
+			next := f.tasks[0]
+			f.tasks = f.tasks[1:]
+			if c.place(ctx, p, next.key, next.data, &next.task) {
+				placed = true
+				break
+			}
+		}
+
+		// A flow that cannot place anything has run out of tasks or quota
+		// and sits out the rest of this pass. Its virtual time is left
+		// alone, so it is still first in line on the next pass.
+		if !placed {
+			flows = flows[1:]
+			continue
+		}
+
+		state.clock = state.flows[f.name]
+		state.flows[f.name] += 1 / f.weight
+		c.broker.HashIncr(ctx, SharesKey, map[string]int64{fmt.Sprintf("%d|%s", priority, f.name): 1})
+		assigned++
+	}
+	return assigned
+}
@@ -0,0 +1,192 @@
+package coordinator
+
+import (
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// recorder places every task on the first candidate and records the order
+// it was asked to place them in.
+type recorder struct {
+	placed []string
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Select(t *task.Task, candidates []*Candidate) *Candidate {
+	r.placed = append(r.placed, string(t.Payload))
+	return candidates[0]
+}
+
+type queued struct {
+	tenant   string
+	taskType string
+	name     string
+}
+
+// fairPass queues tasks at one priority and runs one weighted fair
+// assignment pass over them, returning the order tasks were placed in.
+func fairPass(t *testing.T, c *Coordinator, p *pass, tasks []queued) []string {
+	t.Helper()
+	ctx := context.Background()
+
+	var tenants []string
+	seen := make(map[string]bool)
+	for _, q := range tasks {
+		tk := task.NewTask(q.taskType, []byte(q.name))
+		tk.Tenant = q.tenant
+		if err := c.scheduler.ScheduleTask(ctx, tk, &task.ScheduleOptions{Priority: 5}); err != nil {
+			t.Fatalf("ScheduleTask %s: %v", q.name, err)
+		}
+		if !seen[q.tenant] {
+			seen[q.tenant] = true
+			tenants = append(tenants, q.tenant)
+		}
+	}
+
+	rec := c.strategy.(*recorder)
+	rec.placed = nil
+	flows := c.loadFlows(ctx, 5, tenants, c.loadWeights(ctx))
+	c.assignFair(ctx, p, 5, flows)
+	return rec.placed
+}
+
+func newFairPass(record *registry.WorkerRecord, quotas map[string]*tenant.Quota) *pass {
+	return &pass{
+		candidates: []*Candidate{{Record: record, Holding: worker.NewHolding()}},
+		quotas:     quotas,
+		running:    make(map[string]int64),
+		notify:     make(map[string]bool),
+	}
+}
+
+// TestFairOrder checks the order weighted fair queuing places one
+// priority's tasks in across flows.
+func TestFairOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		weights   map[string]float64
+		quotas    map[string]*tenant.Quota
+		taskTypes []string // of the worker, any when empty
+		tasks     []queued
+		want      []string
+	}{
+		{
+			name: "equal weights alternate",
+			mode: FairTenant,
+			tasks: []queued{
+				{"a", "echo", "a1"}, {"a", "echo", "a2"}, {"a", "echo", "a3"},
+				{"b", "echo", "b1"}, {"b", "echo", "b2"}, {"b", "echo", "b3"},
+			},
+			want: []string{"a1", "b1", "a2", "b2", "a3", "b3"},
+		},
+		{
+			name:    "twice the weight twice the share",
+			mode:    FairTenant,
+			weights: map[string]float64{"a": 2},
+			tasks: []queued{
+				{"a", "echo", "a1"}, {"a", "echo", "a2"}, {"a", "echo", "a3"}, {"a", "echo", "a4"},
+				{"b", "echo", "b1"}, {"b", "echo", "b2"}, {"b", "echo", "b3"}, {"b", "echo", "b4"},
+			},
+			want: []string{"a1", "b1", "a2", "a3", "b2", "a4", "b3", "b4"},
+		},
+		{
+			name:    "fractional weight",
+			mode:    FairTenant,
+			weights: map[string]float64{"b": 0.5},
+			tasks: []queued{
+				{"a", "echo", "a1"}, {"a", "echo", "a2"}, {"a", "echo", "a3"},
+				{"b", "echo", "b1"}, {"b", "echo", "b2"},
+			},
+			want: []string{"a1", "b1", "a2", "a3", "b2"},
+		},
+		{
+			name:   "flow over its running quota sits out",
+			mode:   FairTenant,
+			quotas: map[string]*tenant.Quota{"a": {MaxRunning: 1}},
+			tasks: []queued{
+				{"a", "echo", "a1"}, {"a", "echo", "a2"}, {"a", "echo", "a3"},
+				{"b", "echo", "b1"}, {"b", "echo", "b2"}, {"b", "echo", "b3"},
+				{"c", "echo", "c1"}, {"c", "echo", "c2"}, {"c", "echo", "c3"},
+			},
+			want: []string{"a1", "b1", "c1", "b2", "c2", "b3", "c3"},
+		},
+		{
+			name:      "flow with nothing placeable sits out",
+			mode:      FairTenant,
+			taskTypes: []string{"echo"},
+			tasks: []queued{
+				{"a", "other", "a1"}, {"a", "other", "a2"},
+				{"b", "echo", "b1"}, {"b", "echo", "b2"}, {"b", "echo", "b3"},
+			},
+			want: []string{"b1", "b2", "b3"},
+		},
+		{
+			name:    "task types within a tenant",
+			mode:    FairType,
+			weights: map[string]float64{"a/report": 2},
+			tasks: []queued{
+				{"a", "echo", "e1"}, {"a", "echo", "e2"}, {"a", "echo", "e3"},
+				{"a", "report", "r1"}, {"a", "report", "r2"}, {"a", "report", "r3"}, {"a", "report", "r4"},
+			},
+			want: []string{"e1", "r1", "r2", "e2", "r3", "r4", "e3"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := broker.NewMemory()
+			defer b.Close()
+
+			c := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(b), WithStrategy(&recorder{}), WithFairness(test.mode))
+			for flow, weight := range test.weights {
+				if err := SetWeight(ctx, b, flow, weight); err != nil {
+					t.Fatalf("SetWeight: %v", err)
+				}
+			}
+
+			record := &registry.WorkerRecord{ID: "w1", TaskTypes: test.taskTypes, Capacity: registry.Capacity{Units: 100}}
+			got := fairPass(t, c, newFairPass(record, test.quotas), test.tasks)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("placed %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestFairVirtualTime checks that virtual time carries over between passes
+// and that a flow which was idle joins at the current virtual time rather
+// than catching up on the share it did not use.
+func TestFairVirtualTime(t *testing.T) {
+	b := broker.NewMemory()
+	defer b.Close()
+
+	c := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(b), WithStrategy(&recorder{}), WithFairness(FairTenant))
+	record := &registry.WorkerRecord{ID: "w1", Capacity: registry.Capacity{Units: 100}}
+
+	first := fairPass(t, c, newFairPass(record, nil), []queued{
+		{"a", "echo", "a1"}, {"a", "echo", "a2"}, {"a", "echo", "a3"},
+	})
+	if want := []string{"a1", "a2", "a3"}; !reflect.DeepEqual(first, want) {
+		t.Fatalf("first pass placed %v, want %v", first, want)
+	}
+
+	second := fairPass(t, c, newFairPass(record, nil), []queued{
+		{"a", "echo", "a4"}, {"a", "echo", "a5"}, {"a", "echo", "a6"},
+		{"b", "echo", "b1"}, {"b", "echo", "b2"}, {"b", "echo", "b3"},
+	})
+	if want := []string{"b1", "a4", "b2", "a5", "b3", "a6"}; !reflect.DeepEqual(second, want) {
+		t.Fatalf("second pass placed %v, want %v", second, want)
+	}
+}
@@ -0,0 +1,89 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// How long a preemption is given to free its slot before the task it was
+// made for may preempt another
+const preemptGrace = 30 * time.Second
+
+// victim is a running task that could make room for an urgent one.
+type victim struct {
+	workerID string
+	task     task.Task
+}
+
+// preemptFor makes room for an urgent task no worker has room for, by
+// asking a worker to give up a running task of lower priority. The urgent
+// task stays queued and is placed once the slot is free.
+func (c *Coordinator) preemptFor(ctx context.Context, p *pass, t *task.Task) {
+	now := time.Now()
+	for id, since := range c.preempting {
+		if now.Sub(since) > preemptGrace {
+			delete(c.preempting, id)
+		}
+	}
+	if _, ok := c.preempting[t.ID]; ok {
+		return
+	}
+
+	var chosen *victim
+	for _, candidate := range p.candidates {
+		record := candidate.Record
+		if !record.Supports(t.Type) || !t.MatchesLabels(record.Labels) {
+			continue
+		}
+
+		running, err := c.broker.HashGetAll(ctx, broker.WorkerProcessingKey(record.ID))
+		if err != nil {
+			continue
+		}
+		for id, taskStr := range running {
+			var v task.Task
+			if err := json.Unmarshal([]byte(taskStr), &v); err != nil {
+				continue
+			}
+			// Only started tasks of lower priority that allow it
+			if v.Status != task.StatusProcessing || v.NoPreempt || v.QueuePriority() >= t.QueuePriority() {
+				continue
+			}
+			if _, ok := c.preempting[id]; ok {
+				continue
+			}
+
+			// Stopping it must leave room for the urgent task, next to
+			// tasks its anti-affinity rules allow
+			freed := candidate.Holding.Clone()
+			freed.RemoveTask(&v)
+			if !freed.FitsOn(t, record) || freed.ConflictsWith(t) {
+				continue
+			}
+
+			// Prefer the lowest priority, then the task submitted last
+			if chosen == nil || v.QueuePriority() < chosen.task.QueuePriority() ||
+				(v.QueuePriority() == chosen.task.QueuePriority() && v.CreatedAt.After(chosen.task.CreatedAt)) {
+				chosen = &victim{workerID: record.ID, task: v}
+			}
+		}
+	}
+	if chosen == nil {
+		return
+	}
+
+	if err := worker.RequestPreemption(ctx, c.broker, chosen.workerID, chosen.task.ID); err != nil {
+		c.logger.Printf("Failed to preempt task %s: %v", chosen.task.ID, err)
+		return
+	}
+	c.logger.Printf("Preempting task %s (priority %d) on worker %s for task %s (priority %d)",
+		chosen.task.ID, chosen.task.QueuePriority(), chosen.workerID, t.ID, t.QueuePriority())
+	c.preempting[t.ID] = now
+	c.preempting[chosen.task.ID] = now
+	c.broker.HashIncr(ctx, StrategyStatsKey(c.statsName()), map[string]int64{"preempted": 1})
+}
@@ -0,0 +1,76 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// TestPreemptionRespectsAntiAffinity checks that the victim is chosen so
+// the urgent task does not end up next to a task type it must avoid, even
+// when a lower priority task could be stopped instead.
+func TestPreemptionRespectsAntiAffinity(t *testing.T) {
+	running := func(taskType string, priority int) *task.Task {
+		tk := task.NewTask(taskType, nil)
+		tk.Priority = priority
+		tk.Cost = 1
+		tk.Status = task.StatusProcessing
+		return tk
+	}
+
+	tests := []struct {
+		name         string
+		antiAffinity []string
+		want         string // type of the task preempted, none when empty
+	}{
+		{"lowest priority first", nil, "batch"},
+		{"avoids conflicting survivor", []string{"report"}, "report"},
+		{"no victim leaves no conflict", []string{"batch", "report"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := broker.NewMemory()
+			defer b.Close()
+			c := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(b))
+
+			record := &registry.WorkerRecord{ID: "w1", Capacity: registry.Capacity{Units: 2}}
+			holding := worker.NewHolding()
+			byType := make(map[string]string)
+			for _, tk := range []*task.Task{running("batch", 1), running("report", 5)} {
+				data, _ := json.Marshal(tk)
+				if err := b.HashSet(ctx, broker.WorkerProcessingKey(record.ID), tk.ID, string(data)); err != nil {
+					t.Fatalf("storing running task: %v", err)
+				}
+				holding.AddTask(tk)
+				byType[tk.ID] = tk.Type
+			}
+
+			urgent := running("urgent", 10)
+			urgent.Status = task.StatusPending
+			urgent.AntiAffinity = test.antiAffinity
+			p := &pass{candidates: []*Candidate{{Record: record, Holding: holding}}}
+			c.preemptFor(ctx, p, urgent)
+
+			requested, err := b.HashGetAll(ctx, broker.WorkerPreemptKey(record.ID))
+			if err != nil {
+				t.Fatalf("loading preemptions: %v", err)
+			}
+			var got string
+			for id := range requested {
+				got = byType[id]
+			}
+			if len(requested) > 1 || got != test.want {
+				t.Fatalf("preempted %v, want a %q task", requested, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// How often tenants are checked against their running and CPU quotas
+const quotaInterval = time.Second
+
+// enforceQuotas keeps the throttled tenants up to date, for workers that
+// pull their own work and for the API. In push mode assignPending checks
+// the quotas itself on every pass.
+func (c *Coordinator) enforceQuotas(ctx context.Context) {
+	ticker := time.NewTicker(quotaInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		quotas, err := tenant.LoadQuotas(ctx, c.broker)
+		if err != nil {
+			continue
+		}
+		candidates, err := c.loadCandidates(ctx)
+		if err != nil {
+			continue
+		}
+		if c.publishThrottled(ctx, c.throttle(ctx, quotas, runningByTenant(candidates))) {
+			wake(c.wakeDistribute)
+		}
+	}
+}
+
+// runningByTenant counts the tasks the candidates hold for each tenant.
+func runningByTenant(candidates []*Candidate) map[string]int64 {
+	running := make(map[string]int64)
+	for _, candidate := range candidates {
+		for name, held := range candidate.Holding.Tenants {
+			running[name] += int64(held)
+		}
+	}
+	return running
+}
+
+// throttle returns why each tenant over its running or CPU quota is held
+// back.
+func (c *Coordinator) throttle(ctx context.Context, quotas map[string]*tenant.Quota, running map[string]int64) map[string]string {
+	throttled := make(map[string]string)
+	for name, q := range quotas {
+		if q.MaxRunning > 0 && running[name] >= q.MaxRunning {
+			throttled[name] = fmt.Sprintf("running task quota of %d reached", q.MaxRunning)
+			continue
+		}
+		if q.CPUSeconds > 0 {
+			used, ends, err := tenant.CPUUsed(ctx, c.broker, name, q.Window())
+			if err == nil && used.Seconds() >= q.CPUSeconds {
+				throttled[name] = fmt.Sprintf("CPU budget of %gs per %s used up until %s",
+					q.CPUSeconds, q.Window(), ends.Format(time.RFC3339))
+			}
+		}
+	}
+	return throttled
+}
+
+// publishThrottled replaces the throttled tenants and reports whether any
+// tenant was released.
+func (c *Coordinator) publishThrottled(ctx context.Context, throttled map[string]string) bool {
+	current, err := tenant.Throttled(ctx, c.broker)
+	if err != nil {
+		return false
+	}
+
+	for name, reason := range throttled {
+		if current[name] == reason {
+			continue
+		}
+		c.logger.Printf("Throttling tenant %s: %s", name, reason)
+		c.broker.HashSet(ctx, tenant.ThrottledKey, name, reason)
+	}
+
+	var released []string
+	for name := range current {
+		if _, ok := throttled[name]; !ok {
+			c.logger.Printf("Tenant %s is no longer throttled", name)
+			released = append(released, name)
+		}
+	}
+	c.broker.HashDelete(ctx, tenant.ThrottledKey, released...)
+	return len(released) > 0
+}
+
+// recordUsage charges a finished task to its tenant.
+func (c *Coordinator) recordUsage(ctx context.Context, result *task.Result) {
+	// Expired tasks never ran
+	if result.Status == task.StatusExpired {
+		return
+	}
+
+	usage := tenant.Usage{Tasks: 1}
+	if result.Status == task.StatusFailed {
+		usage.Failed = 1
+	}
+	if result.Metrics != nil {
+		usage.ProcessingSeconds = result.Metrics.ProcessingTime.Seconds()
+		usage.CPUSeconds = result.Metrics.CPUSeconds()
+		usage.QueueWaitSeconds = result.Metrics.QueueWaitTime.Seconds()
+	}
+
+	finished := result.EndTime
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	if err := tenant.RecordUsage(ctx, c.broker, result.Tenant, result.Type, finished, usage); err != nil {
+		c.logger.Printf("Failed to record usage of task %s: %v", result.TaskID, err)
+	}
+
+	// Only tenants with a CPU budget keep a window
+	q, err := tenant.GetQuota(ctx, c.broker, result.Tenant)
+	if err != nil || q == nil || q.CPUSeconds == 0 {
+		return
+	}
+	used := time.Duration(usage.CPUSeconds * float64(time.Second))
+	if err := tenant.AddCPU(ctx, c.broker, result.Tenant, q.Window(), used); err != nil {
+		c.logger.Printf("Failed to charge CPU time of task %s: %v", result.TaskID, err)
+	}
+}
@@ -0,0 +1,127 @@
+package coordinator
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// assignedByTenant counts the tasks assigned to a worker by tenant.
+func assignedByTenant(t *testing.T, b broker.Broker, workerID string) map[string]int {
+	t.Helper()
+	assigned, err := b.HashGetAll(context.Background(), broker.WorkerTasksKey(workerID))
+	if err != nil {
+		t.Fatalf("loading assigned tasks: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, data := range assigned {
+		var tk task.Task
+		if err := json.Unmarshal([]byte(data), &tk); err != nil {
+			t.Fatalf("decoding assigned task: %v", err)
+		}
+		counts[tenant.Name(tk.Tenant)]++
+	}
+	return counts
+}
+
+// TestRunningQuota checks that assignment stops at a tenant's running
+// quota, counting what the tenant already holds, while other tenants'
+// tasks keep being assigned.
+func TestRunningQuota(t *testing.T) {
+	for _, mode := range FairnessModes() {
+		t.Run(mode, func(t *testing.T) {
+			ctx := context.Background()
+			b := broker.NewMemory()
+			defer b.Close()
+
+			c := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(b), WithFairness(mode))
+			record := &registry.WorkerRecord{ID: "w1", Capacity: registry.Capacity{Units: 100}}
+			c.storeWorker(record)
+
+			if err := tenant.SetQuota(ctx, b, "a", &tenant.Quota{MaxRunning: 3}); err != nil {
+				t.Fatalf("SetQuota: %v", err)
+			}
+			// One task of a's is already running
+			running, _ := json.Marshal(&task.Task{ID: "running", Type: "echo", Tenant: "a", Cost: 1})
+			if err := b.HashSet(ctx, broker.WorkerProcessingKey(record.ID), "running", string(running)); err != nil {
+				t.Fatalf("storing running task: %v", err)
+			}
+
+			for _, name := range []string{"a", "a", "a", "a", "b", "b", "b"} {
+				tk := task.NewTask("echo", nil)
+				tk.Tenant = name
+				tk.Cost = 1
+				if err := c.scheduler.ScheduleTask(ctx, tk, &task.ScheduleOptions{Priority: 5}); err != nil {
+					t.Fatalf("ScheduleTask: %v", err)
+				}
+			}
+
+			c.assignPending(ctx)
+			c.assignPending(ctx)
+			if got := assignedByTenant(t, b, record.ID); got["a"] != 2 || got["b"] != 3 {
+				t.Fatalf("assigned %v, want 2 of a's and 3 of b's", got)
+			}
+
+			quotas, _ := tenant.LoadQuotas(ctx, b)
+			candidates, _ := c.loadCandidates(ctx)
+			throttled := c.throttle(ctx, quotas, runningByTenant(candidates))
+			if len(throttled) != 1 || !strings.Contains(throttled["a"], "running task quota of 3 reached") {
+				t.Fatalf("throttled %v, want a at its running quota", throttled)
+			}
+		})
+	}
+}
+
+// TestRecordUsage checks what a finished task is charged: processing,
+// CPU and queue wait time to its tenant's usage, failures counted, expired
+// tasks not at all, and CPU time to the budget window of tenants with one.
+func TestRecordUsage(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+
+	c := New(WithLogger(log.New(io.Discard, "", 0)), WithBroker(b))
+	q := &tenant.Quota{CPUSeconds: 60}
+	if err := tenant.SetQuota(ctx, b, "a", q); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+
+	now := time.Now()
+	for _, result := range []*task.Result{
+		{TaskID: "1", Tenant: "a", Type: "echo", Status: task.StatusCompleted, EndTime: now,
+			Metrics: &task.TaskMetrics{ProcessingTime: 2 * time.Second, QueueWaitTime: time.Second}},
+		{TaskID: "2", Tenant: "a", Type: "echo", Status: task.StatusFailed, EndTime: now,
+			Metrics: &task.TaskMetrics{ProcessingTime: time.Second, CPUTime: 0.5}},
+		{TaskID: "3", Tenant: "a", Type: "echo", Status: task.StatusExpired, EndTime: now},
+		{TaskID: "4", Tenant: "b", Type: "echo", Status: task.StatusCompleted, EndTime: now,
+			Metrics: &task.TaskMetrics{ProcessingTime: time.Second}},
+	} {
+		c.recordUsage(ctx, result)
+	}
+
+	usage, err := tenant.LoadUsage(ctx, b, "a", "", "")
+	if err != nil {
+		t.Fatalf("LoadUsage: %v", err)
+	}
+	want := tenant.Usage{Tasks: 2, Failed: 1, ProcessingSeconds: 3, CPUSeconds: 2.5, QueueWaitSeconds: 1}
+	if got := usage["echo"]; got == nil || *got != want {
+		t.Fatalf("usage of a: got %+v, want %+v", got, want)
+	}
+
+	used, _, err := tenant.CPUUsed(ctx, b, "a", q.Window())
+	if err != nil || used != 2500*time.Millisecond {
+		t.Fatalf("CPU charged to a: got %s, %v, want 2.5s", used, err)
+	}
+	if used, _, _ := tenant.CPUUsed(ctx, b, "b", tenant.DefaultWindow); used != 0 {
+		t.Fatalf("CPU charged to b without a budget: got %s, want none", used)
+	}
+}
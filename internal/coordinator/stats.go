@@ -0,0 +1,86 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// Assignment statistics are kept per strategy and survive restarts, so
+// strategies can be compared on the same workload across runs.
+const (
+	StrategyKey   = "coordinator:strategy"
+	StrategiesKey = "coordinator:strategies"
+)
+
+func StrategyStatsKey(strategy string) string {
+	return fmt.Sprintf("coordinator:stats:%s", strategy)
+}
+
+func StrategyAssignmentsKey(strategy string) string {
+	return fmt.Sprintf("coordinator:assignments:%s", strategy)
+}
+
+// statsName is what assignments are recorded under. Pull dispatch and
+// streams have no strategy and are reported under their own names.
+func (c *Coordinator) statsName() string {
+	if c.backend == queue.Streams {
+		return queue.Streams
+	}
+	if c.dispatch == worker.DispatchPull {
+		return worker.DispatchPull
+	}
+	return c.strategy.Name()
+}
+
+func (c *Coordinator) publishStrategy(ctx context.Context) error {
+	settings := map[string]string{
+		StrategyKey:        c.statsName(),
+		FairnessKey:        c.fairness,
+		worker.DispatchKey: c.dispatch,
+		queue.BackendKey:   c.backend,
+	}
+	for key, value := range settings {
+		if err := c.broker.Set(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	if err := c.ordering.Store(ctx, c.broker); err != nil {
+		return err
+	}
+	if err := c.classes.Store(ctx, c.broker); err != nil {
+		return err
+	}
+	return c.broker.SetAdd(ctx, StrategiesKey, c.statsName())
+}
+
+func (c *Coordinator) recordAssignment(ctx context.Context, workerID string) {
+	c.broker.HashIncr(ctx, StrategyStatsKey(c.statsName()), map[string]int64{"assigned": 1})
+	c.broker.HashIncr(ctx, StrategyAssignmentsKey(c.statsName()), map[string]int64{workerID: 1})
+}
+
+func (c *Coordinator) recordCompletion(ctx context.Context, result *task.Result) {
+	// Pulled tasks are only seen once they finish
+	if c.dispatch == worker.DispatchPull || c.backend == queue.Streams {
+		c.recordAssignment(ctx, result.WorkerID)
+	}
+
+	deltas := make(map[string]int64)
+	switch result.Status {
+	case task.StatusFailed:
+		deltas["failed"] = 1
+	case task.StatusExpired:
+		deltas["expired"] = 1
+	default:
+		deltas["completed"] = 1
+	}
+	if result.Metrics != nil {
+		deltas["queue_wait_ms"] = result.Metrics.QueueWaitTime.Milliseconds()
+		deltas["processing_ms"] = result.Metrics.ProcessingTime.Milliseconds()
+	}
+
+	c.broker.HashIncr(ctx, StrategyStatsKey(c.statsName()), deltas)
+}
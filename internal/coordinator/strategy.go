@@ -0,0 +1,175 @@
+package coordinator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
+)
+
+// Candidate is a worker that is allowed to run a task, with the number of
+// tasks it currently holds (assigned plus accepted) and their demand.
+type Candidate struct {
+	Record      *registry.WorkerRecord
+	Outstanding int64
+	Holding     *worker.Holding
+}
+
+// Utilization is the share of the worker's capacity that is committed.
+func (c *Candidate) Utilization() float64 {
+	return float64(c.Holding.Load) / float64(c.Record.CapacityUnits())
+}
+
+// Strategy chooses which of the eligible workers gets a task. Candidates
+// are always passed in worker ID order and are never empty.
+type Strategy interface {
+	Name() string
+	Select(t *task.Task, candidates []*Candidate) *Candidate
+}
+
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastOutstanding = "least-outstanding"
+	StrategyWeighted         = "weighted"
+	StrategyPowerOfTwo       = "power-of-two"
+	StrategyLeastLoaded      = "least-loaded"
+)
+
+func Strategies() []string {
+	return []string{StrategyRoundRobin, StrategyLeastOutstanding, StrategyWeighted, StrategyPowerOfTwo, StrategyLeastLoaded}
+}
+
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case StrategyRoundRobin:
+		return &roundRobin{}, nil
+	case StrategyLeastOutstanding:
+		return leastOutstanding{}, nil
+	case StrategyWeighted:
+		return &weighted{current: make(map[string]int)}, nil
+	case StrategyPowerOfTwo:
+		return powerOfTwo{}, nil
+	case StrategyLeastLoaded:
+		return leastLoaded{}, nil
+	default:
+		return nil, fmt.Errorf("unknown assignment strategy %q (available: %s)", name, strings.Join(Strategies(), ", "))
+	}
+}
+
+// roundRobin hands tasks to workers in ID order, continuing after the
+// worker that was picked last.
+type roundRobin struct {
+	last string
+}
+
+func (s *roundRobin) Name() string { return StrategyRoundRobin }
+
+func (s *roundRobin) Select(t *task.Task, candidates []*Candidate) *Candidate {
+	i := sort.Search(len(candidates), func(i int) bool {
+		return candidates[i].Record.ID > s.last
+	})
+	if i == len(candidates) {
+		i = 0
+	}
+	s.last = candidates[i].Record.ID
+	return candidates[i]
+}
+
+// leastOutstanding picks the worker holding the fewest tasks.
+type leastOutstanding struct{}
+
+func (leastOutstanding) Name() string { return StrategyLeastOutstanding }
+
+func (leastOutstanding) Select(t *task.Task, candidates []*Candidate) *Candidate {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Outstanding < best.Outstanding {
+			best = c
+		}
+	}
+	return best
+}
+
+// weighted spreads tasks in proportion to pool size using smooth weighted
+// round-robin, so a worker with 8 slots gets 4 times the tasks of one
+// with 2 without receiving them in bursts.
+type weighted struct {
+	current map[string]int
+}
+
+func (s *weighted) Name() string { return StrategyWeighted }
+
+func (s *weighted) Select(t *task.Task, candidates []*Candidate) *Candidate {
+	var best *Candidate
+	total := 0
+	for _, c := range candidates {
+		weight := max(c.Record.PoolSize, 1)
+		total += weight
+		s.current[c.Record.ID] += weight
+		if best == nil || s.current[c.Record.ID] > s.current[best.Record.ID] {
+			best = c
+		}
+	}
+	s.current[best.Record.ID] -= total
+
+	// Forget workers that are gone
+	if len(s.current) > 2*len(candidates) {
+		present := make(map[string]bool, len(candidates))
+		for _, c := range candidates {
+			present[c.Record.ID] = true
+		}
+		for id := range s.current {
+			if !present[id] {
+				delete(s.current, id)
+			}
+		}
+	}
+
+	return best
+}
+
+// powerOfTwo samples two workers at random and keeps the less loaded one,
+// which gets close to least-outstanding without herding on a single
+// worker between load refreshes.
+type powerOfTwo struct{}
+
+func (powerOfTwo) Name() string { return StrategyPowerOfTwo }
+
+func (powerOfTwo) Select(t *task.Task, candidates []*Candidate) *Candidate {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := candidates[i], candidates[j]
+	if b.Outstanding < a.Outstanding {
+		return b
+	}
+	return a
+}
+
+// leastLoaded picks the worker with the lowest share of its capacity
+// committed, which spreads heavy tasks across workers instead of stacking
+// them on whichever worker holds the fewest tasks.
+type leastLoaded struct{}
+
+func (leastLoaded) Name() string { return StrategyLeastLoaded }
+
+func (leastLoaded) Select(t *task.Task, candidates []*Candidate) *Candidate {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Utilization() < best.Utilization() {
+			best = c
+		}
+	}
+	return best
+}
@@ -0,0 +1,79 @@
+// Package events lets components announce changes over the broker's pub/sub so
+// others can react right away instead of polling. Delivery is best effort:
+// every listener keeps a slow poll as a safety net for missed events.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+type Type string
+
+const (
+	TaskSubmitted Type = "task.submitted"
+	TaskAssigned  Type = "task.assigned"
+	TaskCompleted Type = "task.completed"
+	TaskPreempted Type = "task.preempted"
+	WorkerJoined  Type = "worker.joined"
+	WorkerLeft    Type = "worker.left"
+)
+
+type Event struct {
+	Type     Type   `json:"type"`
+	WorkerID string `json:"worker_id,omitempty"`
+	TaskID   string `json:"task_id,omitempty"`
+}
+
+// Channel returns where events of type t are published. Assignments and
+// preemptions go to a channel per worker so only that worker wakes up.
+func Channel(t Type, workerID string) string {
+	if t == TaskAssigned || t == TaskPreempted {
+		return fmt.Sprintf("events:%s:%s", t, workerID)
+	}
+	return fmt.Sprintf("events:%s", t)
+}
+
+func Publish(ctx context.Context, b broker.Broker, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return b.Publish(ctx, Channel(e.Type, e.WorkerID), string(data))
+}
+
+// Listener delivers the events published on the channels it subscribed to.
+type Listener struct {
+	sub broker.Subscription
+	C   <-chan Event
+}
+
+// Listen subscribes to the given channels. Events that arrive while C is
+// full are dropped; the listener's safety poll picks up what they announced.
+func Listen(ctx context.Context, b broker.Broker, channels ...string) *Listener {
+	sub := b.Subscribe(ctx, channels...)
+	out := make(chan Event, 256)
+
+	go func() {
+		defer close(out)
+		for msg := range sub.Messages() {
+			var e Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				continue
+			}
+			select {
+			case out <- e:
+			default:
+			}
+		}
+	}()
+
+	return &Listener{sub: sub, C: out}
+}
+
+func (l *Listener) Close() error {
+	return l.sub.Close()
+}
@@ -0,0 +1,117 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+// Orderings decide which task of a priority queue goes first.
+const (
+	// By submission time
+	FIFO = "fifo"
+	// By deadline, earliest first
+	EDF = "edf"
+)
+
+// OrderingKey holds the ordering the coordinator runs with, which every
+// component scores tasks by.
+const OrderingKey = "queue:ordering"
+
+// SeqKey numbers the submissions of the whole cluster.
+const SeqKey = "queue:seq"
+
+// DefaultSlack is how long after submission tasks without a deadline are
+// due under EDF, unless configured otherwise.
+const DefaultSlack = time.Hour
+
+func Orderings() []string {
+	return []string{FIFO, EDF}
+}
+
+func ValidateOrdering(name string) error {
+	switch name {
+	case FIFO, EDF:
+		return nil
+	default:
+		return fmt.Errorf("unknown ordering %q (available: %s)", name, strings.Join(Orderings(), ", "))
+	}
+}
+
+// Ordering is how tasks are ordered within each priority queue of the
+// sorted set layout. Streams are always ordered by arrival.
+type Ordering struct {
+	Mode string `json:"mode"`
+	// Under EDF, tasks without a deadline are due this long after they
+	// were submitted
+	SlackSeconds int64 `json:"slackSeconds,omitempty"`
+}
+
+func (o Ordering) Slack() time.Duration {
+	return time.Duration(o.SlackSeconds) * time.Second
+}
+
+// Due returns when a task is due under the ordering: its submission time
+// under FIFO, or its deadline under EDF.
+func (o Ordering) Due(created time.Time, deadline *time.Time) time.Time {
+	if o.Mode != EDF {
+		return created
+	}
+	if deadline != nil {
+		return *deadline
+	}
+	return created.Add(o.Slack())
+}
+
+// Score orders a task in its priority queue, given its sequence number
+// from NextSeq. Under FIFO the score is the sequence number itself, so
+// tasks never tie and go in submission order across the cluster. Under
+// EDF it is the time the task is due, in microseconds; tasks due at the
+// same time sort by their encoding, which starts with the sequence
+// number. Tasks without a sequence number count from their creation.
+func (o Ordering) Score(seq int64, created time.Time, deadline *time.Time) float64 {
+	if seq == 0 {
+		seq = created.UnixMicro()
+	}
+	if o.Mode == EDF && deadline != nil {
+		return float64(deadline.UnixMicro())
+	}
+	if o.Mode == EDF {
+		return float64(seq + o.Slack().Microseconds())
+	}
+	return float64(seq)
+}
+
+// NextSeq numbers a submission. The numbers grow across the cluster and
+// follow the broker's clock in microseconds, so scores of both orderings
+// share a unit.
+func NextSeq(ctx context.Context, b broker.Broker) (int64, error) {
+	return b.NextSeq(ctx, SeqKey)
+}
+
+func (o Ordering) Store(ctx context.Context, b broker.Broker) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ordering: %w", err)
+	}
+	return b.Set(ctx, OrderingKey, string(data))
+}
+
+// CurrentOrdering returns the ordering the cluster runs with, FIFO unless
+// the coordinator published another.
+func CurrentOrdering(ctx context.Context, b broker.Broker) Ordering {
+	data, err := b.Get(ctx, OrderingKey)
+	if err != nil {
+		return Ordering{Mode: FIFO}
+	}
+
+	var o Ordering
+	if err := json.Unmarshal([]byte(data), &o); err != nil || ValidateOrdering(o.Mode) != nil {
+		return Ordering{Mode: FIFO}
+	}
+	return o
+}
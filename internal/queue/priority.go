@@ -0,0 +1,185 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+// ClassesKey holds the priority classes the coordinator runs with, which
+// every component queues and serves tasks by.
+const ClassesKey = "queue:priorities"
+
+var className = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// PriorityClass names a priority level. Each level has its own queues,
+// and higher levels are served first.
+type PriorityClass struct {
+	Name  string `json:"name"`
+	Level int    `json:"level"`
+}
+
+// Classes is a set of priority classes, from highest to lowest level.
+type Classes []PriorityClass
+
+// DefaultClasses are the classes used unless configured otherwise. They
+// name every level from 1 to 10, the priorities accepted before there
+// were classes, so existing clients keep working.
+func DefaultClasses() Classes {
+	return Classes{
+		{Name: "critical", Level: 10},
+		{Name: "urgent", Level: 9},
+		{Name: "high", Level: 8},
+		{Name: "interactive", Level: 7},
+		{Name: "elevated", Level: 6},
+		{Name: "batch", Level: 5},
+		{Name: "normal", Level: 4},
+		{Name: "low", Level: 3},
+		{Name: "bulk", Level: 2},
+		{Name: "background", Level: 1},
+	}
+}
+
+// ParseClasses reads classes written as comma separated name=level pairs,
+// e.g. "critical=10,batch=5".
+func ParseClasses(s string) (Classes, error) {
+	var classes Classes
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid priority class %q, want name=level", pair)
+		}
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level of priority class %q: %w", name, err)
+		}
+		classes = append(classes, PriorityClass{Name: name, Level: level})
+	}
+	if err := classes.Validate(); err != nil {
+		return nil, err
+	}
+	classes.sort()
+	return classes, nil
+}
+
+// Validate checks that there is at least one class and that names and
+// levels are unique, levels being positive.
+func (c Classes) Validate() error {
+	if len(c) == 0 {
+		return fmt.Errorf("at least one priority class is required")
+	}
+	names := make(map[string]bool, len(c))
+	levels := make(map[int]bool, len(c))
+	for _, class := range c {
+		if !className.MatchString(class.Name) {
+			return fmt.Errorf("invalid priority class name %q: use up to 32 lowercase letters, digits, '-' or '_'", class.Name)
+		}
+		if class.Level < 1 {
+			return fmt.Errorf("level of priority class %q must be positive", class.Name)
+		}
+		if names[class.Name] {
+			return fmt.Errorf("duplicate priority class %q", class.Name)
+		}
+		if levels[class.Level] {
+			return fmt.Errorf("duplicate priority level %d", class.Level)
+		}
+		names[class.Name] = true
+		levels[class.Level] = true
+	}
+	return nil
+}
+
+func (c Classes) sort() {
+	sort.Slice(c, func(i, j int) bool { return c[i].Level > c[j].Level })
+}
+
+// Levels returns the levels from highest to lowest.
+func (c Classes) Levels() []int {
+	levels := make([]int, len(c))
+	for i, class := range c {
+		levels[i] = class.Level
+	}
+	return levels
+}
+
+// Lookup returns the class of a name.
+func (c Classes) Lookup(name string) (PriorityClass, bool) {
+	for _, class := range c {
+		if class.Name == name {
+			return class, true
+		}
+	}
+	return PriorityClass{}, false
+}
+
+// Class returns the class of a level.
+func (c Classes) Class(level int) (PriorityClass, bool) {
+	for _, class := range c {
+		if class.Level == level {
+			return class, true
+		}
+	}
+	return PriorityClass{}, false
+}
+
+func (c Classes) Highest() int {
+	return c[0].Level
+}
+
+func (c Classes) Lowest() int {
+	return c[len(c)-1].Level
+}
+
+// Raise returns the level steps classes above level, but not above limit.
+// Levels that are not a class are left alone.
+func (c Classes) Raise(level, steps, limit int) int {
+	for i := len(c) - 1; i >= 0; i-- {
+		if c[i].Level != level {
+			continue
+		}
+		for ; steps > 0 && i > 0 && c[i-1].Level <= limit; steps-- {
+			i--
+		}
+		return c[i].Level
+	}
+	return level
+}
+
+// String writes the classes the way ParseClasses reads them.
+func (c Classes) String() string {
+	pairs := make([]string, len(c))
+	for i, class := range c {
+		pairs[i] = fmt.Sprintf("%s=%d", class.Name, class.Level)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (c Classes) Store(ctx context.Context, b broker.Broker) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal priority classes: %w", err)
+	}
+	return b.Set(ctx, ClassesKey, string(data))
+}
+
+// CurrentClasses returns the priority classes the cluster runs with, the
+// default ones unless the coordinator published others.
+func CurrentClasses(ctx context.Context, b broker.Broker) Classes {
+	data, err := b.Get(ctx, ClassesKey)
+	if err != nil {
+		return DefaultClasses()
+	}
+
+	var c Classes
+	if err := json.Unmarshal([]byte(data), &c); err != nil || c.Validate() != nil {
+		return DefaultClasses()
+	}
+	c.sort()
+	return c
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+func TestParseClasses(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Classes
+		err   string
+	}{
+		{
+			name:  "sorted by level",
+			input: "batch=5, critical=10,background=1",
+			want:  Classes{{"critical", 10}, {"batch", 5}, {"background", 1}},
+		},
+		{name: "single", input: "only=3", want: Classes{{"only", 3}}},
+		{name: "empty", input: "", err: "want name=level"},
+		{name: "missing level", input: "critical=10,batch", err: "want name=level"},
+		{name: "trailing comma", input: "critical=10,", err: "want name=level"},
+		{name: "level not a number", input: "critical=high", err: "invalid level"},
+		{name: "zero level", input: "critical=10,idle=0", err: "must be positive"},
+		{name: "negative level", input: "critical=-1", err: "must be positive"},
+		{name: "duplicate name", input: "batch=5,batch=4", err: "duplicate priority class"},
+		{name: "duplicate level", input: "batch=5,bulk=5", err: "duplicate priority level"},
+		{name: "uppercase name", input: "Batch=5", err: "invalid priority class name"},
+		{name: "empty name", input: "=5", err: "invalid priority class name"},
+		{name: "long name", input: strings.Repeat("a", 33) + "=5", err: "invalid priority class name"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseClasses(test.input)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, %v, want error containing %q", got, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseClasses: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			if back, err := ParseClasses(got.String()); err != nil || !reflect.DeepEqual(back, got) {
+				t.Fatalf("String does not round trip: got %v, %v", back, err)
+			}
+		})
+	}
+}
+
+func TestDefaultClasses(t *testing.T) {
+	classes := DefaultClasses()
+	if err := classes.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	for level := 1; level <= 10; level++ {
+		if _, ok := classes.Class(level); !ok {
+			t.Errorf("level %d has no class", level)
+		}
+	}
+}
+
+func TestCurrentClasses(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+
+	if got := CurrentClasses(ctx, b); !reflect.DeepEqual(got, DefaultClasses()) {
+		t.Fatalf("without stored classes: got %v, want the defaults", got)
+	}
+
+	stored := Classes{{"low", 1}, {"high", 20}}
+	if err := stored.Store(ctx, b); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if got, want := CurrentClasses(ctx, b), (Classes{{"high", 20}, {"low", 1}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("stored classes: got %v, want %v", got, want)
+	}
+
+	for _, data := range []string{"not json", "[]", `[{"name":"a","level":1},{"name":"a","level":2}]`} {
+		if err := b.Set(ctx, ClassesKey, data); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if got := CurrentClasses(ctx, b); !reflect.DeepEqual(got, DefaultClasses()) {
+			t.Errorf("stored %q: got %v, want the defaults", data, got)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package queue holds the layouts tasks can be queued in. The sorted set
+// layout keeps one sorted set per tenant and priority; the streams layout
+// keeps one stream per tenant and priority, consumed by worker pools
+// through consumer groups.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+const (
+	SortedSets = "zset"
+	Streams    = "streams"
+)
+
+// BackendKey holds the backend the coordinator runs with. Components
+// without an explicit backend follow it.
+const BackendKey = "queue:backend"
+
+// DefaultGroup is the consumer group workers join unless configured
+// otherwise.
+const DefaultGroup = "workers"
+
+func Backends() []string {
+	return []string{SortedSets, Streams}
+}
+
+func ValidateBackend(name string) error {
+	switch name {
+	case SortedSets, Streams:
+		return nil
+	default:
+		return fmt.Errorf("unknown queue backend %q (available: %s)", name, strings.Join(Backends(), ", "))
+	}
+}
+
+// Current returns the backend the cluster runs with.
+func Current(ctx context.Context, b broker.Broker) string {
+	name, err := b.Get(ctx, BackendKey)
+	if err != nil || ValidateBackend(name) != nil {
+		return SortedSets
+	}
+	return name
+}
+
+// PriorityKey names one of a tenant's priority queues. Queues and streams
+// carry their tenant's hash tag, so on a Cluster the tenants spread over
+// the shards; the brokers pop them one key at a time there.
+func PriorityKey(name string, priority int) string {
+	return fmt.Sprintf("tasks:{%s}:priority:%d", tenant.Name(name), priority)
+}
+
+func StreamKey(name string, priority int) string {
+	return fmt.Sprintf("tasks:{%s}:stream:%d", tenant.Name(name), priority)
+}
+
+// PriorityKeys returns the sorted set queues of the given tenants at the
+// given levels, highest first, tenants in the given order within a
+// priority.
+func PriorityKeys(levels []int, tenants []string) []string {
+	keys := make([]string, 0, len(levels)*len(tenants))
+	for _, priority := range levels {
+		for _, name := range tenants {
+			keys = append(keys, PriorityKey(name, priority))
+		}
+	}
+	return keys
+}
+
+// StreamKeys returns the streams of the given tenants at the given
+// levels, highest first.
+func StreamKeys(levels []int, tenants []string) []string {
+	keys := make([]string, 0, len(levels)*len(tenants))
+	for _, priority := range levels {
+		for _, name := range tenants {
+			keys = append(keys, StreamKey(name, priority))
+		}
+	}
+	return keys
+}
+
+// Enqueue adds an encoded task to its tenant's queue for its priority,
+// registering the tenant if it is new. score orders tasks in the sorted
+// set layout; streams are ordered by arrival.
+func Enqueue(ctx context.Context, b broker.Broker, backend, name string, priority int, taskBytes []byte, score float64) error {
+	if err := tenant.Register(ctx, b, name); err != nil {
+		return fmt.Errorf("failed to register tenant: %w", err)
+	}
+	if backend == Streams {
+		_, err := b.StreamAdd(ctx, StreamKey(name, priority), string(taskBytes))
+		return err
+	}
+	return b.SortedAdd(ctx, PriorityKey(name, priority), string(taskBytes), score)
+}
+
+// Length returns how many of a tenant's tasks of a priority wait to be
+// picked up. For streams that is the consumer group lag.
+func Length(ctx context.Context, b broker.Broker, backend, name string, priority int) (int64, error) {
+	if backend == Streams {
+		return b.StreamBacklog(ctx, StreamKey(name, priority))
+	}
+	return b.SortedLen(ctx, PriorityKey(name, priority))
+}
+
+// EnsureGroups creates the consumer group on every priority stream of the
+// given tenants, creating the streams as needed. Existing groups are left
+// alone.
+func EnsureGroups(ctx context.Context, b broker.Broker, group string, tenants []string) error {
+	for _, key := range StreamKeys(CurrentClasses(ctx, b).Levels(), tenants) {
+		if err := b.GroupCreate(ctx, key, group); err != nil {
+			return fmt.Errorf("failed to create consumer group %s on %s: %w", group, key, err)
+		}
+	}
+	return nil
+}
+
+// Clear removes every task queued for the given tenants in both layouts,
+// at the current priority levels.
+func Clear(ctx context.Context, b broker.Broker, tenants []string) error {
+	levels := CurrentClasses(ctx, b).Levels()
+	return b.Delete(ctx, append(PriorityKeys(levels, tenants), StreamKeys(levels, tenants)...)...)
+}
@@ -0,0 +1,184 @@
+// Package ratelimit enforces cluster-wide rate limits per task type. Each
+// limit is a token bucket kept in the broker, so every worker draws from
+// the same tokens.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+const (
+	// LimitsKey holds the rate limit of each task type that has one.
+	LimitsKey = "ratelimits"
+	// StatsKey counts the throttled tasks of each task type and the time
+	// they waited, as <task type>|throttled and <task type>|waitMs fields.
+	StatsKey = "ratelimits:stats"
+)
+
+// Limit caps how many tasks of a type start per second across the
+// cluster.
+type Limit struct {
+	// Tasks started per second
+	Rate float64 `json:"rate"`
+	// Tasks that may start at once after a quiet period, the rate rounded
+	// up by default
+	Burst int `json:"burst,omitempty"`
+	// Worker label each value of which gets a limit of its own, e.g.
+	// "region" to limit every region separately
+	Label string `json:"label,omitempty"`
+}
+
+func (l *Limit) Validate() error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return errors.New("rate and burst cannot be negative")
+	}
+	if math.IsInf(l.Rate, 0) || math.IsNaN(l.Rate) {
+		return errors.New("rate must be a number")
+	}
+	return nil
+}
+
+// BurstSize returns the bucket size.
+func (l *Limit) BurstSize() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return max(1, int(math.Ceil(l.Rate)))
+}
+
+// BucketKey holds the tokens of a task type's limit, on a worker with the
+// given labels.
+func BucketKey(taskType string, l *Limit, labels map[string]string) string {
+	if l.Label == "" {
+		return fmt.Sprintf("ratelimit:{%s}:bucket", taskType)
+	}
+	return fmt.Sprintf("ratelimit:{%s}:bucket:%s=%s", taskType, l.Label, labels[l.Label])
+}
+
+// Set stores a task type's limit. A zero rate removes it.
+func Set(ctx context.Context, b broker.Broker, taskType string, l *Limit) error {
+	if l == nil || l.Rate == 0 {
+		_, err := b.HashDelete(ctx, LimitsKey, taskType)
+		return err
+	}
+
+	data, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rate limit: %w", err)
+	}
+	return b.HashSet(ctx, LimitsKey, taskType, string(data))
+}
+
+// Get returns a task type's limit, or nil if it has none.
+func Get(ctx context.Context, b broker.Broker, taskType string) (*Limit, error) {
+	data, err := b.HashGet(ctx, LimitsKey, taskType)
+	if err == broker.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var l Limit
+	if err := json.Unmarshal([]byte(data), &l); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rate limit: %w", err)
+	}
+	return &l, nil
+}
+
+// Load returns the limits of the task types that have one.
+func Load(ctx context.Context, b broker.Broker) (map[string]*Limit, error) {
+	entries, err := b.HashGetAll(ctx, LimitsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate limits: %w", err)
+	}
+
+	limits := make(map[string]*Limit, len(entries))
+	for taskType, data := range entries {
+		var l Limit
+		if err := json.Unmarshal([]byte(data), &l); err != nil {
+			continue
+		}
+		limits[taskType] = &l
+	}
+	return limits, nil
+}
+
+// Take takes a token for a task of the type, returning how long to wait
+// before trying again if there is none.
+func Take(ctx context.Context, b broker.Broker, taskType string, l *Limit, labels map[string]string) (time.Duration, error) {
+	return b.TakeToken(ctx, BucketKey(taskType, l, labels), l.Rate, l.BurstSize())
+}
+
+// RecordThrottled counts a task that had to wait for a token.
+func RecordThrottled(ctx context.Context, b broker.Broker, taskType string, waited time.Duration) error {
+	return b.HashIncr(ctx, StatsKey, map[string]int64{
+		taskType + "|throttled": 1,
+		taskType + "|waitMs":    waited.Milliseconds(),
+	})
+}
+
+// Stats summarises the throttling of one task type.
+type Stats struct {
+	Type      string `json:"type"`
+	Limit     *Limit `json:"limit,omitempty"`
+	Throttled int64  `json:"throttled"`
+	WaitMs    int64  `json:"waitMs"`
+}
+
+// LoadStats returns the throttling of every task type that has a limit or
+// was throttled, by task type.
+func LoadStats(ctx context.Context, b broker.Broker) ([]Stats, error) {
+	limits, err := Load(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := b.HashGetAll(ctx, StatsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rate limit stats: %w", err)
+	}
+
+	byType := make(map[string]*Stats)
+	get := func(taskType string) *Stats {
+		s, ok := byType[taskType]
+		if !ok {
+			s = &Stats{Type: taskType}
+			byType[taskType] = s
+		}
+		return s
+	}
+	for taskType, l := range limits {
+		get(taskType).Limit = l
+	}
+	for field, value := range counters {
+		// The task type may contain the separator, the counter cannot
+		i := strings.LastIndex(field, "|")
+		if i < 0 {
+			continue
+		}
+		n, _ := strconv.ParseInt(value, 10, 64)
+		switch field[i+1:] {
+		case "throttled":
+			get(field[:i]).Throttled = n
+		case "waitMs":
+			get(field[:i]).WaitMs = n
+		}
+	}
+
+	stats := make([]Stats, 0, len(byType))
+	for _, s := range byType {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Type < stats[j].Type })
+	return stats, nil
+}
@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+)
+
+// InfoKey maps worker IDs to their registration records.
+const InfoKey = "workers:info"
+
+// AnyTaskType is advertised by workers that accept every task type.
+const AnyTaskType = "*"
+
+// WorkerRecord describes a worker as it registered itself. Heartbeats
+// refresh it, so the dynamic fields are at most one heartbeat old.
+type WorkerRecord struct {
+	ID            string            `json:"id"`
+	Hostname      string            `json:"hostname"`
+	PID           int               `json:"pid"`
+	Version       string            `json:"version"`
+	PoolSize      int               `json:"poolSize"`
+	TaskTypes     []string          `json:"taskTypes"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Capacity      Capacity          `json:"capacity"`
+	Draining      bool              `json:"draining"`
+	StartedAt     time.Time         `json:"startedAt"`
+	LastHeartbeat time.Time         `json:"lastHeartbeat"`
+
+	Reservation *Reservation `json:"reservation,omitempty"`
+}
+
+// DefaultUnitsPerSlot sizes a worker's capacity when it declares none.
+const DefaultUnitsPerSlot = 10
+
+// Capacity is what a worker can take on at once. Units are measured in the
+// same terms as task.Task.Demand.
+type Capacity struct {
+	Slots int `json:"slots"`
+	CPUs  int `json:"cpus"`
+	Units int `json:"units"`
+}
+
+// Reservation keeps part of a worker's slots for tasks at or above a
+// priority level, so urgent work always finds headroom.
+type Reservation struct {
+	Slots    int `json:"slots"`
+	Priority int `json:"priority"`
+}
+
+func Publish(ctx context.Context, b broker.Broker, record *WorkerRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal worker record: %w", err)
+	}
+
+	if err := b.HashSet(ctx, InfoKey, record.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to publish worker record: %w", err)
+	}
+	return nil
+}
+
+func Get(ctx context.Context, b broker.Broker, workerID string) (*WorkerRecord, error) {
+	data, err := b.HashGet(ctx, InfoKey, workerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var record WorkerRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal worker record: %w", err)
+	}
+	return &record, nil
+}
+
+func Load(ctx context.Context, b broker.Broker) (map[string]*WorkerRecord, error) {
+	entries, err := b.HashGetAll(ctx, InfoKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load worker records: %w", err)
+	}
+
+	records := make(map[string]*WorkerRecord, len(entries))
+	for workerID, data := range entries {
+		var record WorkerRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			continue
+		}
+		records[workerID] = &record
+	}
+	return records, nil
+}
+
+// Supports reports whether the worker advertises a handler for taskType.
+func (r *WorkerRecord) Supports(taskType string) bool {
+	if len(r.TaskTypes) == 0 {
+		return true
+	}
+	for _, t := range r.TaskTypes {
+		if t == AnyTaskType || t == taskType {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRun reports whether the worker's task types and labels allow it to run
+// t. Anti-affinity depends on what the worker currently holds and is
+// checked separately.
+func (r *WorkerRecord) CanRun(t *task.Task) bool {
+	return r.Supports(t.Type) && t.MatchesLabels(r.Labels)
+}
+
+// CapacityUnits returns the task demand the worker can hold at once.
+func (r *WorkerRecord) CapacityUnits() int {
+	if r.Capacity.Units > 0 {
+		return r.Capacity.Units
+	}
+	return max(r.Capacity.Slots, r.PoolSize, 1) * DefaultUnitsPerSlot
+}
+
+// ReservedUnits returns the capacity units only tasks at or above the
+// reservation's priority may use.
+func (r *WorkerRecord) ReservedUnits() int {
+	if r.Reservation == nil || r.Reservation.Slots <= 0 {
+		return 0
+	}
+	units := r.CapacityUnits()
+	perSlot := units / max(r.Capacity.Slots, r.PoolSize, 1)
+	return min(r.Reservation.Slots*perSlot, units)
+}
@@ -0,0 +1,96 @@
+package task
+
+import "fmt"
+
+type LabelOperator string
+
+const (
+	LabelIn           LabelOperator = "In"
+	LabelNotIn        LabelOperator = "NotIn"
+	LabelExists       LabelOperator = "Exists"
+	LabelDoesNotExist LabelOperator = "DoesNotExist"
+)
+
+// LabelRequirement is an affinity rule evaluated against worker labels.
+type LabelRequirement struct {
+	Key      string        `json:"key"`
+	Operator LabelOperator `json:"operator"`
+	Values   []string      `json:"values,omitempty"`
+}
+
+func (r LabelRequirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+
+	switch r.Operator {
+	case LabelIn:
+		return ok && contains(r.Values, value)
+	case LabelNotIn:
+		return !ok || !contains(r.Values, value)
+	case LabelExists:
+		return ok
+	case LabelDoesNotExist:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func (r LabelRequirement) Validate() error {
+	if r.Key == "" {
+		return fmt.Errorf("affinity rule without key")
+	}
+
+	switch r.Operator {
+	case LabelIn, LabelNotIn:
+		if len(r.Values) == 0 {
+			return fmt.Errorf("affinity rule %s %s needs values", r.Key, r.Operator)
+		}
+	case LabelExists, LabelDoesNotExist:
+		if len(r.Values) > 0 {
+			return fmt.Errorf("affinity rule %s %s takes no values", r.Key, r.Operator)
+		}
+	default:
+		return fmt.Errorf("unknown affinity operator %q", r.Operator)
+	}
+	return nil
+}
+
+// MatchesLabels reports whether a worker with the given labels satisfies
+// the task's node selector and affinity rules.
+func (t *Task) MatchesLabels(labels map[string]string) bool {
+	for key, value := range t.NodeSelector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	for _, rule := range t.Affinity {
+		if !rule.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConflictsWith reports whether the task's anti-affinity rules forbid it
+// from sharing a worker with a task of the given type.
+func (t *Task) ConflictsWith(taskType string) bool {
+	return contains(t.AntiAffinity, taskType)
+}
+
+func (t *Task) ValidatePlacement() error {
+	for _, rule := range t.Affinity {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
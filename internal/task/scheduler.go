@@ -3,42 +3,61 @@ package task
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
 )
 
 type Scheduler struct {
-	redis *redis.Client
+	broker broker.Broker
 }
 
 type ScheduleOptions struct {
-	Priority     int        `json:"priority"`     // 1-10, higher is more important
+	Priority     int        `json:"priority"`     // Level of a priority class, higher is more important
 	Deadline     *time.Time `json:"deadline"`     // Optional deadline
 	MaxRetries   int        `json:"max_retries"`  // Maximum retry attempts
-	Dependencies []string   `json:"dependencies"` // Task IDs that must complete first
+	Dependencies []string   `json:"dependencies"` // IDs of the tenant's tasks that must complete first
 }
 
-func NewScheduler(redis *redis.Client) *Scheduler {
+func NewScheduler(b broker.Broker) *Scheduler {
 	return &Scheduler{
-		redis: redis,
+		broker: b,
 	}
 }
 
+// ErrInvalidPriority is returned for priorities that are not the level of
+// a configured priority class.
+var ErrInvalidPriority = errors.New("priority is not the level of a priority class")
+
+// ScheduleTask queues a new task, or parks it until its dependencies
+// complete. Every submission goes through here, so all tasks are ordered
+// the same way.
 func (s *Scheduler) ScheduleTask(ctx context.Context, task *Task, opts *ScheduleOptions) error {
-	// Set task metadata
+	if _, ok := queue.CurrentClasses(ctx, s.broker).Class(opts.Priority); !ok {
+		return ErrInvalidPriority
+	}
+
+	// Set task metadata. The task starts over at its own priority.
 	task.Priority = opts.Priority
+	task.EffectivePriority = 0
 	if opts.Deadline != nil {
 		task.Deadline = opts.Deadline
 	}
 	task.MaxRetries = opts.MaxRetries
 	task.Dependencies = opts.Dependencies
+	if err := s.number(ctx, task); err != nil {
+		return err
+	}
 
 	// Check if all dependencies are complete
 	if len(task.Dependencies) > 0 {
 		for _, depID := range task.Dependencies {
-			exists, err := s.redis.HExists(ctx, "results", depID).Result()
+			exists, err := s.broker.HashExists(ctx, tenant.ResultsKey(task.Tenant), depID)
 			if err != nil {
 				return fmt.Errorf("failed to check dependency %s: %w", depID, err)
 			}
@@ -49,33 +68,44 @@ func (s *Scheduler) ScheduleTask(ctx context.Context, task *Task, opts *Schedule
 		}
 	}
 
-	// Encode task
+	if err := s.Requeue(ctx, task); err != nil {
+		return err
+	}
+
+	events.Publish(ctx, s.broker, events.Event{Type: events.TaskSubmitted, TaskID: task.ID})
+	return nil
+}
+
+// Requeue puts a task on the queue of the priority it waits at, scored by
+// the cluster's ordering. Tasks keep their score when they are requeued,
+// so they do not lose their place.
+func (s *Scheduler) Requeue(ctx context.Context, task *Task) error {
+	if err := s.number(ctx, task); err != nil {
+		return err
+	}
 	taskBytes, err := json.Marshal(task)
 	if err != nil {
 		return fmt.Errorf("failed to marshal task: %w", err)
 	}
 
-	// Add to appropriate priority queue
-	score := float64(time.Now().Unix())
-	if task.Deadline != nil {
-		// Adjust score based on deadline
-		remaining := time.Until(*task.Deadline)
-		if remaining < 0 {
-			score -= 1000000 // Overdue tasks get highest priority
-		} else {
-			score -= float64(remaining.Seconds())
-		}
-	}
-
-	queueKey := fmt.Sprintf("tasks:priority:%d", task.Priority)
-	err = s.redis.ZAdd(ctx, queueKey, &redis.Z{
-		Score:  score,
-		Member: taskBytes,
-	}).Err()
+	score := queue.CurrentOrdering(ctx, s.broker).Score(task.SeqNumber(), task.CreatedAt, task.Deadline)
+	err = queue.Enqueue(ctx, s.broker, queue.Current(ctx, s.broker), task.Tenant, task.QueuePriority(), taskBytes, score)
 	if err != nil {
 		return fmt.Errorf("failed to queue task: %w", err)
 	}
+	return nil
+}
 
+// number gives a task its sequence number, unless it has one.
+func (s *Scheduler) number(ctx context.Context, task *Task) error {
+	if task.Seq != "" {
+		return nil
+	}
+	seq, err := queue.NextSeq(ctx, s.broker)
+	if err != nil {
+		return fmt.Errorf("failed to number task: %w", err)
+	}
+	task.Seq = fmt.Sprintf("%020d", seq)
 	return nil
 }
 
@@ -86,11 +116,7 @@ func (s *Scheduler) scheduleDependentTask(ctx context.Context, task *Task) error
 	}
 
 	// Store task in waiting list
-	err = s.redis.HSet(ctx,
-		fmt.Sprintf("tasks:waiting:%s", task.ID),
-		"task",
-		taskBytes,
-	).Err()
+	err = s.broker.HashSet(ctx, broker.WaitingKey(task.ID), "task", string(taskBytes))
 
 	if err != nil {
 		return fmt.Errorf("failed to schedule dependent task: %w", err)
@@ -98,10 +124,7 @@ func (s *Scheduler) scheduleDependentTask(ctx context.Context, task *Task) error
 
 	// Add task ID to dependency tracking
 	for _, depID := range task.Dependencies {
-		err = s.redis.SAdd(ctx,
-			fmt.Sprintf("tasks:dependencies:%s", depID),
-			task.ID,
-		).Err()
+		err = s.broker.SetAdd(ctx, broker.DependentsKey(depID), task.ID)
 		if err != nil {
 			return fmt.Errorf("failed to track dependency: %w", err)
 		}
@@ -110,44 +133,40 @@ func (s *Scheduler) scheduleDependentTask(ctx context.Context, task *Task) error
 	return nil
 }
 
+// GetNextTask returns broker.ErrNotFound when every queue is empty.
 func (s *Scheduler) GetNextTask(ctx context.Context) (*Task, error) {
-	// Try to get tasks from highest to lowest priority
-	for priority := 10; priority > 0; priority-- {
-		queueKey := fmt.Sprintf("tasks:priority:%d", priority)
-
-		// Get oldest task in this priority queue
-		result, err := s.redis.ZPopMin(ctx, queueKey).Result()
-		if err != nil && err != redis.Nil {
-			return nil, err
-		}
+	tenants, err := tenant.List(ctx, s.broker)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(result) > 0 {
-			var task Task
-			if err := json.Unmarshal([]byte(result[0].Member.(string)), &task); err != nil {
-				return nil, err
-			}
-			return &task, nil
-		}
+	// Take the oldest task of the highest priority queue that has one
+	levels := queue.CurrentClasses(ctx, s.broker).Levels()
+	popped, err := s.broker.SortedPopMin(ctx, 0, queue.PriorityKeys(levels, tenants)...)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, redis.Nil
+	var task Task
+	if err := json.Unmarshal([]byte(popped.Member), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
 }
 
 func (s *Scheduler) OnTaskComplete(ctx context.Context, taskID string) error {
 	// Get dependent tasks
-	dependentIDs, err := s.redis.SMembers(ctx,
-		fmt.Sprintf("tasks:dependencies:%s", taskID),
-	).Result()
+	dependentIDs, err := s.broker.SetMembers(ctx, broker.DependentsKey(taskID))
 	if err != nil {
 		return fmt.Errorf("failed to get dependent tasks: %w", err)
 	}
 
 	// Check each dependent task
 	for _, depTaskID := range dependentIDs {
-		taskKey := fmt.Sprintf("tasks:waiting:%s", depTaskID)
+		taskKey := broker.WaitingKey(depTaskID)
 
 		// Get task data
-		taskBytes, err := s.redis.HGet(ctx, taskKey, "task").Result()
+		taskBytes, err := s.broker.HashGet(ctx, taskKey, "task")
 		if err != nil {
 			continue
 		}
@@ -160,7 +179,7 @@ func (s *Scheduler) OnTaskComplete(ctx context.Context, taskID string) error {
 		// Check if all dependencies are now complete
 		allComplete := true
 		for _, depID := range task.Dependencies {
-			exists, err := s.redis.HExists(ctx, "results", depID).Result()
+			exists, err := s.broker.HashExists(ctx, tenant.ResultsKey(task.Tenant), depID)
 			if err != nil || !exists {
 				allComplete = false
 				break
@@ -169,7 +188,7 @@ func (s *Scheduler) OnTaskComplete(ctx context.Context, taskID string) error {
 
 		if allComplete {
 			// Remove from waiting list
-			s.redis.Del(ctx, taskKey)
+			s.broker.Delete(ctx, taskKey)
 
 			// Schedule task
 			opts := &ScheduleOptions{
@@ -182,7 +201,7 @@ func (s *Scheduler) OnTaskComplete(ctx context.Context, taskID string) error {
 	}
 
 	// Cleanup dependency tracking
-	s.redis.Del(ctx, fmt.Sprintf("tasks:dependencies:%s", taskID))
+	s.broker.Delete(ctx, broker.DependentsKey(taskID))
 	return nil
 }
 
@@ -0,0 +1,154 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+)
+
+// TestOrdering schedules tasks in the listed order and checks the order
+// they come off the queues in. All tasks are created at the same instant,
+// so only their priority, deadline and sequence number tell them apart.
+func TestOrdering(t *testing.T) {
+	type submission struct {
+		name     string
+		priority int
+		deadline time.Duration // from now, none when zero
+	}
+	tests := []struct {
+		name     string
+		ordering string
+		tasks    []submission
+		want     []string
+	}{
+		{
+			name:     "fifo equal priority",
+			ordering: queue.FIFO,
+			tasks:    []submission{{"a", 5, 0}, {"b", 5, 0}, {"c", 5, 0}, {"d", 5, 0}},
+			want:     []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "fifo ignores deadlines",
+			ordering: queue.FIFO,
+			tasks:    []submission{{"a", 5, 3 * time.Hour}, {"b", 5, time.Hour}},
+			want:     []string{"a", "b"},
+		},
+		{
+			name:     "fifo cross priority",
+			ordering: queue.FIFO,
+			tasks:    []submission{{"a", 1, 0}, {"b", 10, 0}, {"c", 5, 0}, {"d", 10, 0}, {"e", 1, 0}},
+			want:     []string{"b", "d", "c", "a", "e"},
+		},
+		{
+			name:     "edf earliest deadline first",
+			ordering: queue.EDF,
+			tasks:    []submission{{"a", 5, 3 * time.Hour}, {"b", 5, time.Hour}, {"c", 5, 2 * time.Hour}},
+			want:     []string{"b", "c", "a"},
+		},
+		{
+			name:     "edf ties",
+			ordering: queue.EDF,
+			tasks:    []submission{{"a", 5, 2 * time.Hour}, {"b", 5, 2 * time.Hour}, {"c", 5, time.Hour}, {"d", 5, 2 * time.Hour}},
+			want:     []string{"c", "a", "b", "d"},
+		},
+		{
+			name:     "edf ties without deadline",
+			ordering: queue.EDF,
+			tasks:    []submission{{"a", 5, 0}, {"b", 5, 0}, {"c", 5, 30 * time.Minute}},
+			want:     []string{"c", "a", "b"},
+		},
+		{
+			name:     "edf cross priority",
+			ordering: queue.EDF,
+			tasks:    []submission{{"a", 1, time.Hour}, {"b", 10, 3 * time.Hour}, {"c", 10, 2 * time.Hour}},
+			want:     []string{"c", "b", "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			b := broker.NewMemory()
+			defer b.Close()
+
+			ordering := queue.Ordering{Mode: test.ordering, SlackSeconds: int64(time.Hour.Seconds())}
+			if err := ordering.Store(ctx, b); err != nil {
+				t.Fatalf("storing ordering: %v", err)
+			}
+
+			scheduler := NewScheduler(b)
+			created := time.Now()
+			// Deadlines come in whole seconds, as from the API
+			base := created.Truncate(time.Second)
+			for _, s := range test.tasks {
+				tk := NewTask("test", []byte(s.name))
+				tk.CreatedAt = created
+				opts := &ScheduleOptions{Priority: s.priority}
+				if s.deadline != 0 {
+					deadline := base.Add(s.deadline)
+					opts.Deadline = &deadline
+				}
+				if err := scheduler.ScheduleTask(ctx, tk, opts); err != nil {
+					t.Fatalf("ScheduleTask %s: %v", s.name, err)
+				}
+			}
+
+			var got []string
+			for {
+				tk, err := scheduler.GetNextTask(ctx)
+				if errors.Is(err, broker.ErrNotFound) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("GetNextTask: %v", err)
+				}
+				got = append(got, string(tk.Payload))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestRequeueKeepsPlace checks that a requeued task goes back ahead of the
+// tasks submitted after it.
+func TestRequeueKeepsPlace(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	defer b.Close()
+	scheduler := NewScheduler(b)
+
+	for _, name := range []string{"a", "b"} {
+		if err := scheduler.ScheduleTask(ctx, NewTask("test", []byte(name)), &ScheduleOptions{Priority: 5}); err != nil {
+			t.Fatalf("ScheduleTask %s: %v", name, err)
+		}
+	}
+	first, err := scheduler.GetNextTask(ctx)
+	if err != nil {
+		t.Fatalf("GetNextTask: %v", err)
+	}
+	if err := scheduler.ScheduleTask(ctx, NewTask("test", []byte("c")), &ScheduleOptions{Priority: 5}); err != nil {
+		t.Fatalf("ScheduleTask c: %v", err)
+	}
+	if err := scheduler.Requeue(ctx, first); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+
+	var got []string
+	for {
+		tk, err := scheduler.GetNextTask(ctx)
+		if err != nil {
+			break
+		}
+		got = append(got, string(tk.Payload))
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
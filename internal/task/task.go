@@ -1,6 +1,9 @@
 package task
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,27 +18,64 @@ const (
 	StatusCompleted  Status = "completed"
 	StatusFailed     Status = "failed"
 	StatusRetrying   Status = "retrying"
+	// Dropped because its deadline passed before it started
+	StatusExpired Status = "expired"
+)
+
+// What happens to a task that misses its deadline
+const (
+	// Drop it if it has not started, with the expired status
+	DeadlineDrop = "drop"
+	// Run it anyway and flag it as late (default)
+	DeadlineFlag = "flag"
+	// Run it at the lowest priority, flagged as late
+	DeadlineDemote = "demote"
 )
 
 type Task struct {
+	// Sequence number from queue.NextSeq, padded so numbers sort as text.
+	// It comes first in the encoded task, so queued tasks with the same
+	// score sort in submission order.
+	Seq string `json:"seq,omitempty"`
+
 	ID              string     `json:"id"`
+	Tenant          string     `json:"tenant,omitempty"`
 	Type            string     `json:"type"`
 	Payload         []byte     `json:"payload"`
 	Status          Status     `json:"status"`
 	Priority        int        `json:"priority"`
 	ComplexityScore int        `json:"complexity_score"`
+	Cost            int        `json:"cost,omitempty"`
 	Dependencies    []string   `json:"dependencies,omitempty"`
 	RetryCount      int        `json:"retry_count"`
 	MaxRetries      int        `json:"max_retries"`
 	Deadline        *time.Time `json:"deadline,omitempty"`
+	DeadlinePolicy  string     `json:"deadline_policy,omitempty"`
 	NextRetryAt     time.Time  `json:"next_retry_at,omitempty"`
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
 	WorkerID        string     `json:"worker_id,omitempty"`
+
+	// Priority aging raised the task to, 0 while it was not raised
+	EffectivePriority int `json:"effective_priority,omitempty"`
+	// Set once the task was found in its queue past its deadline
+	DeadlineMissed bool `json:"deadline_missed,omitempty"`
+
+	// Opts the task out of being preempted by more urgent work
+	NoPreempt bool `json:"no_preempt,omitempty"`
+	// How often the task was stopped to make room and requeued
+	Preemptions int `json:"preemptions,omitempty"`
+
+	// Placement constraints
+	NodeSelector map[string]string  `json:"node_selector,omitempty"`
+	Affinity     []LabelRequirement `json:"affinity,omitempty"`
+	AntiAffinity []string           `json:"anti_affinity,omitempty"`
 }
 
 type Result struct {
 	TaskID     string       `json:"task_id"`
+	Tenant     string       `json:"tenant,omitempty"`
+	Type       string       `json:"type,omitempty"`
 	Status     Status       `json:"status"`
 	Output     []byte       `json:"output,omitempty"`
 	Error      string       `json:"error,omitempty"`
@@ -44,6 +84,14 @@ type Result struct {
 	RetryCount int          `json:"retry_count"`
 	WorkerID   string       `json:"worker_id"`
 	Metrics    *TaskMetrics `json:"metrics,omitempty"`
+
+	// The priority the task was submitted with and the one it ran at
+	Priority          int `json:"priority,omitempty"`
+	EffectivePriority int `json:"effective_priority,omitempty"`
+	// Finished, or was dropped, after its deadline
+	DeadlineMissed bool `json:"deadline_missed,omitempty"`
+	// How often the task was preempted before this run
+	Preemptions int `json:"preemptions,omitempty"`
 }
 
 type TaskMetrics struct {
@@ -51,6 +99,18 @@ type TaskMetrics struct {
 	QueueWaitTime  time.Duration `json:"queue_wait_time"`
 	MemoryUsage    uint64        `json:"memory_usage"`
 	CPUTime        float64       `json:"cpu_time"`
+
+	// Time spent waiting for the task type's rate limit
+	ThrottleTime time.Duration `json:"throttle_time,omitempty"`
+}
+
+// CPUSeconds is what a task is charged for: its CPU time when the handler
+// measured it, otherwise its processing time.
+func (m *TaskMetrics) CPUSeconds() float64 {
+	if m.CPUTime > 0 {
+		return m.CPUTime
+	}
+	return m.ProcessingTime.Seconds()
 }
 
 func NewTask(taskType string, payload []byte) *Task {
@@ -67,6 +127,19 @@ func NewTask(taskType string, payload []byte) *Task {
 	}
 }
 
+// SeqNumber returns the task's sequence number, 0 if it has none.
+func (t *Task) SeqNumber() int64 {
+	seq, _ := strconv.ParseInt(t.Seq, 10, 64)
+	return seq
+}
+
+// WithTenant submits the task on behalf of a tenant instead of the
+// default one.
+func (t *Task) WithTenant(name string) *Task {
+	t.Tenant = name
+	return t
+}
+
 func (t *Task) WithPriority(priority int) *Task {
 	t.Priority = priority
 	return t
@@ -77,16 +150,57 @@ func (t *Task) WithDeadline(deadline time.Time) *Task {
 	return t
 }
 
+// WithDeadlinePolicy sets what happens if the task misses its deadline,
+// see DeadlinePolicies.
+func (t *Task) WithDeadlinePolicy(policy string) *Task {
+	t.DeadlinePolicy = policy
+	return t
+}
+
 func (t *Task) WithDependencies(deps ...string) *Task {
 	t.Dependencies = deps
 	return t
 }
 
+func (t *Task) WithNodeSelector(selector map[string]string) *Task {
+	t.NodeSelector = selector
+	return t
+}
+
+func (t *Task) WithAffinity(rules ...LabelRequirement) *Task {
+	t.Affinity = rules
+	return t
+}
+
+// WithAntiAffinity keeps the task off workers that hold a task of any of
+// the given types.
+func (t *Task) WithAntiAffinity(taskTypes ...string) *Task {
+	t.AntiAffinity = taskTypes
+	return t
+}
+
 func (t *Task) WithMaxRetries(maxRetries int) *Task {
 	t.MaxRetries = maxRetries
 	return t
 }
 
+// WithCost declares the resource demand of the task, overriding the one
+// derived from its complexity score.
+func (t *Task) WithCost(cost int) *Task {
+	t.Cost = cost
+	return t
+}
+
+// Demand is the capacity the task takes up on a worker while it is
+// assigned or running: its declared cost, or else its complexity score,
+// and never less than one unit.
+func (t *Task) Demand() int {
+	if t.Cost > 0 {
+		return t.Cost
+	}
+	return max(t.ComplexityScore, 1)
+}
+
 func (t *Task) IsOverdue() bool {
 	if t.Deadline == nil {
 		return false
@@ -104,3 +218,63 @@ func (t *Task) ShouldProcess() bool {
 	}
 	return time.Now().After(t.NextRetryAt)
 }
+
+// QueuePriority is the priority of the queue the task waits in: the one
+// it was demoted to for missing its deadline, the one aging raised it to,
+// or else its own.
+func (t *Task) QueuePriority() int {
+	if t.Demoted() && t.EffectivePriority > 0 {
+		return t.EffectivePriority
+	}
+	if t.EffectivePriority > t.Priority {
+		return t.EffectivePriority
+	}
+	return t.Priority
+}
+
+// AgingSteps is how many priority classes the task has earned by waiting
+// since it was submitted, one per rate.
+func (t *Task) AgingSteps(rate time.Duration, now time.Time) int {
+	if rate <= 0 {
+		return 0
+	}
+	return int(now.Sub(t.CreatedAt) / rate)
+}
+
+func DeadlinePolicies() []string {
+	return []string{DeadlineDrop, DeadlineFlag, DeadlineDemote}
+}
+
+// ValidateDeadlinePolicy accepts the policies and an empty one, which
+// stands for the default.
+func ValidateDeadlinePolicy(policy string) error {
+	switch policy {
+	case "", DeadlineDrop, DeadlineFlag, DeadlineDemote:
+		return nil
+	default:
+		return fmt.Errorf("unknown deadline policy %q (available: %s)", policy, strings.Join(DeadlinePolicies(), ", "))
+	}
+}
+
+// OnDeadlineMiss returns the task's deadline policy, flag by default.
+func (t *Task) OnDeadlineMiss() string {
+	if t.DeadlinePolicy == "" {
+		return DeadlineFlag
+	}
+	return t.DeadlinePolicy
+}
+
+// Demoted reports whether the task was moved to the lowest priority class
+// for missing its deadline.
+func (t *Task) Demoted() bool {
+	return t.DeadlineMissed && t.OnDeadlineMiss() == DeadlineDemote
+}
+
+// Unschedulable records why a queued task cannot currently be assigned.
+type Unschedulable struct {
+	TaskID   string    `json:"task_id"`
+	Type     string    `json:"type"`
+	Priority int       `json:"priority"`
+	Reason   string    `json:"reason"`
+	Since    time.Time `json:"since"`
+}
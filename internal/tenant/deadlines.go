@@ -0,0 +1,102 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+// What became of tasks that missed their deadline
+const (
+	// Dropped before they started
+	MissExpired = "expired"
+	// Moved to the lowest priority
+	MissDemoted = "demoted"
+	// Finished after their deadline
+	MissLate = "late"
+)
+
+// DeadlineMisses counts the deadline misses of one task type and
+// priority.
+type DeadlineMisses struct {
+	Type     string `json:"type"`
+	Priority int    `json:"priority"`
+	Expired  int64  `json:"expired"`
+	Demoted  int64  `json:"demoted"`
+	Late     int64  `json:"late"`
+}
+
+// DeadlinesKey holds a tenant's deadline miss counters, as
+// <task type>|<priority>|<outcome> fields.
+func DeadlinesKey(name string) string {
+	return fmt.Sprintf("tenant:{%s}:deadlines", Name(name))
+}
+
+// RecordDeadlineMiss counts a deadline miss of a task of the tenant.
+func RecordDeadlineMiss(ctx context.Context, b broker.Broker, name, taskType string, priority int, outcome string) error {
+	field := fmt.Sprintf("%s|%d|%s", taskType, priority, outcome)
+	return b.HashIncr(ctx, DeadlinesKey(name), map[string]int64{field: 1})
+}
+
+// LoadDeadlineMisses returns the tenant's deadline misses by task type and
+// priority.
+func LoadDeadlineMisses(ctx context.Context, b broker.Broker, name string) ([]DeadlineMisses, error) {
+	counters, err := b.HashGetAll(ctx, DeadlinesKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deadline misses: %w", err)
+	}
+
+	byKey := make(map[string]*DeadlineMisses)
+	for field, value := range counters {
+		// The task type may contain the separator, the rest cannot
+		parts := strings.Split(field, "|")
+		if len(parts) < 3 {
+			continue
+		}
+		n := len(parts)
+		priority, err := strconv.Atoi(parts[n-2])
+		if err != nil {
+			continue
+		}
+		taskType := strings.Join(parts[:n-2], "|")
+
+		key := fmt.Sprintf("%s|%d", taskType, priority)
+		m, ok := byKey[key]
+		if !ok {
+			m = &DeadlineMisses{Type: taskType, Priority: priority}
+			byKey[key] = m
+		}
+
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch parts[n-1] {
+		case MissExpired:
+			m.Expired += count
+		case MissDemoted:
+			m.Demoted += count
+		case MissLate:
+			m.Late += count
+		}
+	}
+
+	misses := make([]DeadlineMisses, 0, len(byKey))
+	for _, m := range byKey {
+		misses = append(misses, *m)
+	}
+	SortDeadlineMisses(misses)
+	return misses, nil
+}
+
+// SortDeadlineMisses orders misses by task type, then highest priority
+// first.
+func SortDeadlineMisses(misses []DeadlineMisses) {
+	sort.Slice(misses, func(i, j int) bool {
+		if misses[i].Type != misses[j].Type {
+			return misses[i].Type < misses[j].Type
+		}
+		return misses[i].Priority > misses[j].Priority
+	})
+}
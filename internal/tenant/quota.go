@@ -0,0 +1,171 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+const (
+	// QuotasKey holds each tenant's quota, by tenant name.
+	QuotasKey = "tenants:quotas"
+	// ThrottledKey holds why the coordinator holds back tenants that are
+	// over their running or CPU quota, by tenant name. Workers that pull
+	// their own work skip these tenants' queues.
+	ThrottledKey = "tenants:throttled"
+)
+
+// DefaultWindow is the CPU budget window of quotas that do not set one.
+const DefaultWindow = time.Hour
+
+// Quota limits what a tenant may put on the cluster. Zero means
+// unlimited.
+type Quota struct {
+	// Tasks waiting in the tenant's queues
+	MaxQueued int64 `json:"maxQueued,omitempty"`
+	// Tasks assigned to or accepted by workers at once
+	MaxRunning int64 `json:"maxRunning,omitempty"`
+	// Processing time per window, in seconds
+	CPUSeconds    float64 `json:"cpuSeconds,omitempty"`
+	WindowSeconds int64   `json:"windowSeconds,omitempty"`
+}
+
+func (q *Quota) Validate() error {
+	if q.MaxQueued < 0 || q.MaxRunning < 0 || q.CPUSeconds < 0 || q.WindowSeconds < 0 {
+		return errors.New("quota limits cannot be negative")
+	}
+	return nil
+}
+
+// Unlimited reports whether the quota sets no limit at all.
+func (q *Quota) Unlimited() bool {
+	return q.MaxQueued == 0 && q.MaxRunning == 0 && q.CPUSeconds == 0
+}
+
+// Window returns the period the CPU budget applies to.
+func (q *Quota) Window() time.Duration {
+	if q.WindowSeconds > 0 {
+		return time.Duration(q.WindowSeconds) * time.Second
+	}
+	return DefaultWindow
+}
+
+// SetQuota stores a tenant's quota. An unlimited quota removes it.
+func SetQuota(ctx context.Context, b broker.Broker, name string, q *Quota) error {
+	if q == nil || q.Unlimited() {
+		_, err := b.HashDelete(ctx, QuotasKey, Name(name))
+		return err
+	}
+
+	data, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quota: %w", err)
+	}
+	return b.HashSet(ctx, QuotasKey, Name(name), string(data))
+}
+
+// LoadQuotas returns the quotas of the tenants that have one.
+func LoadQuotas(ctx context.Context, b broker.Broker) (map[string]*Quota, error) {
+	entries, err := b.HashGetAll(ctx, QuotasKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quotas: %w", err)
+	}
+
+	quotas := make(map[string]*Quota, len(entries))
+	for name, data := range entries {
+		var q Quota
+		if err := json.Unmarshal([]byte(data), &q); err != nil {
+			continue
+		}
+		quotas[name] = &q
+	}
+	return quotas, nil
+}
+
+// GetQuota returns a tenant's quota, or nil if it has none.
+func GetQuota(ctx context.Context, b broker.Broker, name string) (*Quota, error) {
+	data, err := b.HashGet(ctx, QuotasKey, Name(name))
+	if err == broker.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quota: %w", err)
+	}
+
+	var q Quota
+	if err := json.Unmarshal([]byte(data), &q); err != nil {
+		return nil, fmt.Errorf("failed to decode quota: %w", err)
+	}
+	return &q, nil
+}
+
+// CPUKey holds the processing time a tenant used in its current budget
+// window, in milliseconds, by window start.
+func CPUKey(name string) string {
+	return fmt.Sprintf("tenant:{%s}:cpu", Name(name))
+}
+
+func windowField(window time.Duration, at time.Time) string {
+	return strconv.FormatInt(at.Truncate(window).Unix(), 10)
+}
+
+// AddCPU charges processing time to the tenant's current window and
+// forgets earlier windows.
+func AddCPU(ctx context.Context, b broker.Broker, name string, window time.Duration, used time.Duration) error {
+	key := CPUKey(name)
+	current := windowField(window, time.Now())
+	if err := b.HashIncr(ctx, key, map[string]int64{current: used.Milliseconds()}); err != nil {
+		return err
+	}
+
+	windows, err := b.HashGetAll(ctx, key)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for field := range windows {
+		if field != current {
+			stale = append(stale, field)
+		}
+	}
+	_, err = b.HashDelete(ctx, key, stale...)
+	return err
+}
+
+// CPUUsed returns the processing time the tenant used in its current
+// window, and when that window ends.
+func CPUUsed(ctx context.Context, b broker.Broker, name string, window time.Duration) (time.Duration, time.Time, error) {
+	now := time.Now()
+	ends := now.Truncate(window).Add(window)
+
+	ms, err := b.HashGet(ctx, CPUKey(name), windowField(window, now))
+	if err == broker.ErrNotFound {
+		return 0, ends, nil
+	}
+	if err != nil {
+		return 0, ends, err
+	}
+	n, _ := strconv.ParseInt(ms, 10, 64)
+	return time.Duration(n) * time.Millisecond, ends, nil
+}
+
+// Throttled returns the tenants the coordinator holds back, with why.
+func Throttled(ctx context.Context, b broker.Broker) (map[string]string, error) {
+	return b.HashGetAll(ctx, ThrottledKey)
+}
+
+// Admitted returns the tenants that are not throttled, in order.
+func Admitted(tenants []string, throttled map[string]string) []string {
+	admitted := make([]string, 0, len(tenants))
+	for _, name := range tenants {
+		if _, ok := throttled[name]; !ok {
+			admitted = append(admitted, name)
+		}
+	}
+	return admitted
+}
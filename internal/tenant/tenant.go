@@ -0,0 +1,84 @@
+// Package tenant separates the teams sharing a cluster. Every tenant has
+// its own priority queues and result stores; workers are shared and take
+// tasks from all tenants.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+// Default is the tenant of tasks submitted without one.
+const Default = "default"
+
+// Key holds the names of the tenants that have submitted tasks.
+const Key = "tenants"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Validate accepts up to 64 letters, digits, '_', '.' and '-'.
+func Validate(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid tenant %q: use up to 64 letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+// Name returns the tenant a task belongs to, mapping the empty name of
+// tasks submitted before tenants existed to Default.
+func Name(name string) string {
+	if name == "" {
+		return Default
+	}
+	return name
+}
+
+// Register records that a tenant exists, so workers and the coordinator
+// look at its queues.
+func Register(ctx context.Context, b broker.Broker, name string) error {
+	return b.SetAdd(ctx, Key, Name(name))
+}
+
+// List returns the known tenants in name order, always including Default.
+func List(ctx context.Context, b broker.Broker) ([]string, error) {
+	names, err := b.SetMembers(ctx, Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	found := false
+	for _, name := range names {
+		found = found || name == Default
+	}
+	if !found {
+		names = append(names, Default)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ResultsKey holds a tenant's completed tasks, by task ID. Everything
+// about one tenant shares its hash tag.
+func ResultsKey(name string) string {
+	return fmt.Sprintf("tenant:{%s}:results", Name(name))
+}
+
+// FailedKey holds a tenant's failed tasks, by task ID.
+func FailedKey(name string) string {
+	return fmt.Sprintf("tenant:{%s}:failed", Name(name))
+}
+
+// UnschedulableKey holds why a tenant's queued tasks cannot be placed on
+// any worker, by task ID.
+func UnschedulableKey(name string) string {
+	return fmt.Sprintf("tenant:{%s}:unschedulable", Name(name))
+}
+
+// Keys returns a tenant's result stores.
+func Keys(name string) []string {
+	return []string{ResultsKey(name), FailedKey(name), UnschedulableKey(name), DeadlinesKey(name)}
+}
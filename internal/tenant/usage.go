@@ -0,0 +1,104 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+)
+
+// DayFormat is how usage days are written, in UTC.
+const DayFormat = "2006-01-02"
+
+// Usage is what a tenant consumed running tasks, for chargeback.
+type Usage struct {
+	Tasks             int64   `json:"tasks"`
+	Failed            int64   `json:"failed"`
+	ProcessingSeconds float64 `json:"processingSeconds"`
+	CPUSeconds        float64 `json:"cpuSeconds"`
+	QueueWaitSeconds  float64 `json:"queueWaitSeconds"`
+}
+
+func (u *Usage) Add(other Usage) {
+	u.Tasks += other.Tasks
+	u.Failed += other.Failed
+	u.ProcessingSeconds += other.ProcessingSeconds
+	u.CPUSeconds += other.CPUSeconds
+	u.QueueWaitSeconds += other.QueueWaitSeconds
+}
+
+// UsageKey holds a tenant's usage counters, as <day>|<task type>|<counter>
+// fields. Times are counted in milliseconds.
+func UsageKey(name string) string {
+	return fmt.Sprintf("tenant:{%s}:usage", Name(name))
+}
+
+// UsageKeys returns a tenant's accounting keys, which resets of the
+// tenant's tasks leave alone.
+func UsageKeys(name string) []string {
+	return []string{UsageKey(name), CPUKey(name)}
+}
+
+// RecordUsage adds u to the tenant's usage of a task type on the day of
+// at.
+func RecordUsage(ctx context.Context, b broker.Broker, name, taskType string, at time.Time, u Usage) error {
+	prefix := at.UTC().Format(DayFormat) + "|" + taskType + "|"
+	return b.HashIncr(ctx, UsageKey(name), map[string]int64{
+		prefix + "tasks":         u.Tasks,
+		prefix + "failed":        u.Failed,
+		prefix + "processing_ms": seconds(u.ProcessingSeconds).Milliseconds(),
+		prefix + "cpu_ms":        seconds(u.CPUSeconds).Milliseconds(),
+		prefix + "queue_wait_ms": seconds(u.QueueWaitSeconds).Milliseconds(),
+	})
+}
+
+// LoadUsage returns the tenant's usage by task type over the days from
+// and to, both included. Empty bounds are open.
+func LoadUsage(ctx context.Context, b broker.Broker, name, from, to string) (map[string]*Usage, error) {
+	counters, err := b.HashGetAll(ctx, UsageKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	byType := make(map[string]*Usage)
+	for field, value := range counters {
+		// The task type may contain the separator, the day and counter
+		// cannot
+		day, rest, ok := strings.Cut(field, "|")
+		i := strings.LastIndex(rest, "|")
+		if !ok || i < 0 {
+			continue
+		}
+		if (from != "" && day < from) || (to != "" && day > to) {
+			continue
+		}
+		taskType, counter := rest[:i], rest[i+1:]
+
+		n, _ := strconv.ParseInt(value, 10, 64)
+		u, ok := byType[taskType]
+		if !ok {
+			u = &Usage{}
+			byType[taskType] = u
+		}
+		switch counter {
+		case "tasks":
+			u.Tasks += n
+		case "failed":
+			u.Failed += n
+		case "processing_ms":
+			u.ProcessingSeconds += float64(n) / 1000
+		case "cpu_ms":
+			u.CPUSeconds += float64(n) / 1000
+		case "queue_wait_ms":
+			u.QueueWaitSeconds += float64(n) / 1000
+		}
+	}
+	return byType, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
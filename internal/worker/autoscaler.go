@@ -27,10 +27,10 @@ func NewAutoScaler(minWorkers, maxWorkers int32, metrics *WorkerMetrics) *AutoSc
 }
 
 func (as *AutoScaler) Start(ctx context.Context, worker *Worker) {
-	// Initialize with minimum workers
-	for i := int32(0); i < as.minWorkers; i++ {
+	// Account for the processors the worker already started
+	active := minInt32(atomic.LoadInt32(&as.metrics.ActiveWorkers), as.maxWorkers)
+	for i := int32(0); i < active; i++ {
 		as.workerPool <- struct{}{}
-		atomic.AddInt32(&as.metrics.ActiveWorkers, 1)
 	}
 
 	// Start auto-scaling monitor
@@ -70,8 +70,7 @@ func (as *AutoScaler) adjust(worker *Worker) {
 		for i := int32(0); i < toAdd; i++ {
 			select {
 			case as.workerPool <- struct{}{}:
-				atomic.AddInt32(&as.metrics.ActiveWorkers, 1)
-				go worker.processTask(context.Background())
+				worker.spawnProcessor()
 			default:
 				return
 			}
@@ -84,7 +83,10 @@ func (as *AutoScaler) adjust(worker *Worker) {
 	if idleWorkers > as.minWorkers/2 && activeWorkers > as.minWorkers {
 		select {
 		case <-as.workerPool:
-			atomic.AddInt32(&as.metrics.ActiveWorkers, -1)
+			if !worker.retireProcessor(time.Second) {
+				as.workerPool <- struct{}{}
+				return
+			}
 			as.lastScaling = time.Now()
 		default:
 		}
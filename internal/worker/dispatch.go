@@ -0,0 +1,253 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// Dispatch modes. In push mode the coordinator assigns tasks to
+// worker:<id>:tasks; in pull mode workers claim them straight from the
+// priority queues and the coordinator only collects results.
+const (
+	DispatchPush = "push"
+	DispatchPull = "pull"
+)
+
+// DispatchKey holds the dispatch mode the coordinator runs with. Workers
+// without an explicit mode follow it.
+const DispatchKey = "coordinator:dispatch"
+
+const (
+	// How often push mode checks for assignments without being woken
+	pollInterval = 5 * time.Second
+	// How long a blocking pop waits before checking for shutdown
+	pullTimeout = time.Second
+	// How long to back off when the queue heads are tasks this worker
+	// cannot run
+	pullBackoff = 200 * time.Millisecond
+	// How far into each queue to look for a task this worker can run
+	pullScanWindow = 50
+	// How often the fetch loops look for new tenants
+	tenantRefresh = time.Second
+)
+
+func DispatchModes() []string {
+	return []string{DispatchPush, DispatchPull}
+}
+
+func ValidateDispatchMode(mode string) error {
+	switch mode {
+	case DispatchPush, DispatchPull:
+		return nil
+	default:
+		return fmt.Errorf("unknown dispatch mode %q (available: %s)", mode, strings.Join(DispatchModes(), ", "))
+	}
+}
+
+// resolveDispatchMode returns the configured mode, or the cluster's when
+// none was set.
+func (w *Worker) resolveDispatchMode(ctx context.Context) string {
+	if w.dispatch != "" {
+		return w.dispatch
+	}
+
+	mode, err := w.broker.Get(ctx, DispatchKey)
+	if err != nil || ValidateDispatchMode(mode) != nil {
+		return DispatchPush
+	}
+	return mode
+}
+
+// pullWork claims tasks from the priority queues whenever a processor is
+// free, blocking on the queues instead of polling them.
+func (w *Worker) pullWork(ctx context.Context) {
+	defer close(w.fetchDone)
+
+	var keys []string
+	var lastSample, lastRefresh time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.shutdown:
+			return
+		default:
+		}
+		if w.isDraining() {
+			return
+		}
+		if time.Since(lastRefresh) > tenantRefresh {
+			levels := queue.CurrentClasses(ctx, w.broker).Levels()
+			keys = queue.PriorityKeys(levels, w.admittedTenants(ctx))
+			lastRefresh = time.Now()
+		}
+		if len(keys) == 0 {
+			w.pause(tenantRefresh)
+			continue
+		}
+
+		// Only claim what can start right away, so tasks stay in the
+		// shared queues for other workers
+		if int(atomic.LoadInt32(&w.metrics.IdleWorkers)) <= len(w.tasks) {
+			if time.Since(lastSample) > time.Second {
+				w.sampleBacklog(ctx)
+				lastSample = time.Now()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.shutdown:
+				return
+			case <-w.slotFreed:
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		popped, err := w.broker.SortedPopMin(ctx, pullTimeout, keys...)
+		if err == broker.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			w.logger.Printf("Failed to pull tasks: %v", err)
+			w.pause(time.Second)
+			continue
+		}
+
+		taskStr := popped.Member
+		var t task.Task
+		if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+			w.logger.Printf("Failed to unmarshal task from %s: %v", popped.Key, err)
+			continue
+		}
+
+		if w.canAccept(ctx, &t) {
+			w.acceptTask(ctx, &t, taskStr)
+			continue
+		}
+
+		// Put it back where it was and look past it for work we can run
+		err = w.broker.SortedAdd(ctx, popped.Key, taskStr, popped.Score)
+		if err != nil {
+			w.logger.Printf("Failed to return task %s: %v", t.ID, err)
+		}
+		if !w.claimEligible(ctx, keys) {
+			w.pause(pullBackoff)
+		}
+	}
+}
+
+// claimEligible claims the first queued task this worker can run, in
+// priority order. It reports whether one was claimed.
+func (w *Worker) claimEligible(ctx context.Context, keys []string) bool {
+	holding, err := LoadHolding(ctx, w.broker, w.id)
+	if err != nil {
+		return false
+	}
+
+	for _, key := range keys {
+		queued, err := w.broker.SortedRange(ctx, key, 0, pullScanWindow-1)
+		if err != nil {
+			continue
+		}
+
+		for _, taskStr := range queued {
+			var t task.Task
+			if err := json.Unmarshal([]byte(taskStr), &t); err != nil || !w.accepts(holding, &t) {
+				continue
+			}
+
+			// Whoever removes it from the queue owns it
+			removed, err := w.broker.SortedRemove(ctx, key, taskStr)
+			if err != nil || !removed {
+				continue
+			}
+			w.acceptTask(ctx, &t, taskStr)
+			return true
+		}
+	}
+	return false
+}
+
+// acceptTask hands a claimed task to the processors and records it as held.
+func (w *Worker) acceptTask(ctx context.Context, t *task.Task, taskStr string) {
+	// Recorded before a processor can pick the task up, so the
+	// processor's removal of the entry always comes last
+	if err := w.broker.HashSet(ctx, broker.WorkerProcessingKey(w.id), t.ID, taskStr); err != nil {
+		w.logger.Printf("Failed to record task %s as processing: %v", t.ID, err)
+	}
+	w.broker.HashDelete(ctx, tenant.UnschedulableKey(t.Tenant), t.ID)
+
+	select {
+	case w.tasks <- t:
+	case <-w.shutdown:
+		if err := w.returnTask(ctx, t); err != nil {
+			w.logger.Printf("Failed to requeue task %s: %v", t.ID, err)
+		}
+	}
+}
+
+// sampleBacklog reports the shared queue length, across tenants, to the
+// autoscaler.
+func (w *Worker) sampleBacklog(ctx context.Context) {
+	var backlog int64
+	levels := queue.CurrentClasses(ctx, w.broker).Levels()
+	for _, name := range w.loadTenants(ctx) {
+		for _, priority := range levels {
+			length, err := queue.Length(ctx, w.broker, w.backend, name, priority)
+			if err != nil {
+				return
+			}
+			backlog += length
+		}
+	}
+	atomic.StoreInt64(&w.metrics.QueueLength, backlog)
+}
+
+// loadTenants returns the tenants whose queues the worker takes tasks
+// from, falling back to the default tenant when they cannot be listed.
+func (w *Worker) loadTenants(ctx context.Context) []string {
+	tenants, err := tenant.List(ctx, w.broker)
+	if err != nil {
+		w.logger.Printf("Failed to list tenants: %v", err)
+		return []string{tenant.Default}
+	}
+	return tenants
+}
+
+// admittedTenants leaves out of loadTenants the tenants the coordinator
+// throttles for being over their quota.
+func (w *Worker) admittedTenants(ctx context.Context) []string {
+	tenants := w.loadTenants(ctx)
+	throttled, err := tenant.Throttled(ctx, w.broker)
+	if err != nil {
+		return tenants
+	}
+	return tenant.Admitted(tenants, throttled)
+}
+
+// pause waits for d unless the worker shuts down first.
+func (w *Worker) pause(d time.Duration) {
+	select {
+	case <-w.shutdown:
+	case <-time.After(d):
+	}
+}
+
+// notifySlotFreed wakes the pull loop when a processor becomes idle.
+func (w *Worker) notifySlotFreed() {
+	select {
+	case w.slotFreed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,230 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// DrainingKey holds the IDs of workers that are draining or have been asked
+// to drain. The coordinator stops assigning work to its members.
+const DrainingKey = "workers:draining"
+
+// LeftKey holds the IDs of workers that deregistered before the
+// coordinator collected all their results.
+const LeftKey = "workers:left"
+
+// RequestDrain asks the worker with the given ID to drain, wherever it runs.
+func RequestDrain(ctx context.Context, b broker.Broker, workerID string) error {
+	return b.SetAdd(ctx, DrainingKey, workerID)
+}
+
+func (w *Worker) watchDrainRequests(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.shutdown:
+			return
+		case <-ticker.C:
+			requested, err := w.broker.SetContains(ctx, DrainingKey, w.id)
+			if err != nil || !requested {
+				continue
+			}
+
+			w.drainOnce.Do(func() {
+				close(w.drainReq)
+			})
+			return
+		}
+	}
+}
+
+func (w *Worker) drain(ctx context.Context) {
+	atomic.StoreInt32(&w.draining, 1)
+
+	// Make sure the coordinator stops assigning to us
+	if err := w.broker.SetAdd(ctx, DrainingKey, w.id); err != nil {
+		w.logger.Printf("Failed to mark worker as draining: %v", err)
+	}
+	if err := w.publishRecord(ctx); err != nil {
+		w.logger.Printf("Failed to refresh registration: %v", err)
+	}
+
+	close(w.shutdown)
+
+	// Wait for the fetch loop so nothing is accepted behind our back
+	<-w.fetchDone
+
+	// Hand back everything that has not been started yet
+	requeued := w.requeueBuffered(ctx) + w.requeueAssigned(ctx)
+	if requeued > 0 {
+		w.logger.Printf("Returned %d unstarted tasks to their queues", requeued)
+	}
+
+	// Wait for in-flight tasks
+	finished := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(w.drainTimeout):
+		w.logger.Printf("Drain timeout of %s exceeded, requeueing in-flight tasks", w.drainTimeout)
+		// Stop the handlers first so no task runs here and elsewhere at
+		// once, and let those already finishing settle their task
+		for _, done := range w.abandonRunning() {
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
+		}
+		w.requeueProcessing(ctx)
+	}
+
+	if err := w.deregister(ctx); err != nil {
+		w.logger.Printf("Failed to deregister worker: %v", err)
+		return
+	}
+
+	w.logger.Printf("Worker drained")
+}
+
+func (w *Worker) requeueTask(ctx context.Context, t *task.Task) error {
+	t.Status = task.StatusPending
+	t.WorkerID = ""
+	t.UpdatedAt = time.Now()
+
+	// The task keeps its score so it does not lose its place
+	if err := w.scheduler.Requeue(ctx, t); err != nil {
+		return err
+	}
+
+	// The new entry replaces the one we were delivered
+	if w.backend == queue.Streams {
+		w.ackTask(ctx, t.ID)
+	}
+
+	events.Publish(ctx, w.broker, events.Event{Type: events.TaskSubmitted, TaskID: t.ID})
+	return nil
+}
+
+// returnTask requeues a task this worker accepted but never started.
+func (w *Worker) returnTask(ctx context.Context, t *task.Task) error {
+	if err := w.requeueTask(ctx, t); err != nil {
+		return err
+	}
+	_, err := w.broker.HashDelete(ctx, broker.WorkerProcessingKey(w.id), t.ID)
+	return err
+}
+
+// handedBack reports whether a running task's processing entry is gone,
+// e.g. because the coordinator requeued the task while this worker seemed
+// dead.
+func (w *Worker) handedBack(ctx context.Context, taskID string) bool {
+	held, err := w.broker.HashExists(ctx, broker.WorkerProcessingKey(w.id), taskID)
+	return err == nil && !held
+}
+
+func (w *Worker) requeueBuffered(ctx context.Context) int {
+	requeued := 0
+	for {
+		select {
+		case t := <-w.tasks:
+			if t == nil {
+				continue
+			}
+			if err := w.returnTask(ctx, t); err != nil {
+				w.logger.Printf("Failed to requeue task %s: %v", t.ID, err)
+				continue
+			}
+			requeued++
+		default:
+			return requeued
+		}
+	}
+}
+
+func (w *Worker) requeueAssigned(ctx context.Context) int {
+	return w.requeueHash(ctx, broker.WorkerTasksKey(w.id))
+}
+
+func (w *Worker) requeueProcessing(ctx context.Context) int {
+	return w.requeueHash(ctx, broker.WorkerProcessingKey(w.id))
+}
+
+func (w *Worker) requeueHash(ctx context.Context, key string) int {
+	tasks, err := w.broker.HashGetAll(ctx, key)
+	if err != nil {
+		w.logger.Printf("Failed to fetch tasks from %s: %v", key, err)
+		return 0
+	}
+
+	requeued := 0
+	for taskID, taskStr := range tasks {
+		var t task.Task
+		if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+			w.logger.Printf("Failed to unmarshal task %s: %v", taskID, err)
+			w.broker.HashSet(ctx, tenant.FailedKey(tenant.Default), taskID, taskStr)
+			w.broker.HashDelete(ctx, key, taskID)
+			continue
+		}
+
+		if err := w.requeueTask(ctx, &t); err != nil {
+			w.logger.Printf("Failed to requeue task %s: %v", taskID, err)
+			continue
+		}
+
+		w.broker.HashDelete(ctx, key, taskID)
+		requeued++
+	}
+
+	return requeued
+}
+
+func (w *Worker) deregister(ctx context.Context) error {
+	// Pick up anything assigned while the drain was starting
+	w.requeueAssigned(ctx)
+
+	// Results the coordinator has not collected yet stay behind, and it
+	// collects them once we are gone like those of any other worker
+	pending, err := w.broker.HashLen(ctx, broker.WorkerResultsKey(w.id))
+	if err != nil {
+		return fmt.Errorf("failed to count results: %w", err)
+	}
+	if pending > 0 {
+		if err := w.broker.SetAdd(ctx, LeftKey, w.id); err != nil {
+			return fmt.Errorf("failed to hand over results: %w", err)
+		}
+	}
+
+	// Results are safe, so the worker can disappear
+	err = w.broker.Delete(ctx, broker.WorkerTasksKey(w.id), broker.WorkerProcessingKey(w.id), broker.WorkerPreemptKey(w.id))
+	if err != nil {
+		return fmt.Errorf("failed to remove worker state: %w", err)
+	}
+	w.broker.HashDelete(ctx, broker.WorkersKey, w.id)
+	w.broker.HashDelete(ctx, registry.InfoKey, w.id)
+	w.broker.SetRemove(ctx, DrainingKey, w.id)
+
+	if w.backend == queue.Streams {
+		w.leaveGroup(ctx)
+	}
+
+	events.Publish(ctx, w.broker, events.Event{Type: events.WorkerLeft, WorkerID: w.id})
+	return nil
+}
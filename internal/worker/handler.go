@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+)
+
+// Handler executes a task and returns its output.
+type Handler func(ctx context.Context, t *task.Task) ([]byte, error)
+
+// SimulateHandler sleeps for the task's complexity score in seconds.
+func SimulateHandler(ctx context.Context, t *task.Task) ([]byte, error) {
+	select {
+	case <-time.After(time.Duration(t.ComplexityScore) * time.Second):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// EchoHandler returns the task payload unchanged.
+func EchoHandler(ctx context.Context, t *task.Task) ([]byte, error) {
+	return t.Payload, nil
+}
+
+// BuiltinHandlers returns the handlers that can be selected by name.
+func BuiltinHandlers() map[string]Handler {
+	return map[string]Handler{
+		"simulate": SimulateHandler,
+		"echo":     EchoHandler,
+	}
+}
+
+// ParseHandlers resolves a handler set such as "test=simulate,echo" into
+// handlers keyed by task type. An entry without "=" uses the handler name
+// as the task type.
+func ParseHandlers(specs []string) (map[string]Handler, error) {
+	builtin := BuiltinHandlers()
+	handlers := make(map[string]Handler)
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		taskType, name := spec, spec
+		if i := strings.Index(spec, "="); i >= 0 {
+			taskType, name = strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		}
+
+		handler, ok := builtin[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown handler %q (available: %s)", name, strings.Join(handlerNames(builtin), ", "))
+		}
+		handlers[taskType] = handler
+	}
+
+	return handlers, nil
+}
+
+func handlerNames(handlers map[string]Handler) []string {
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handlerFor returns the handler for a task type. A worker without any
+// registered handlers simulates every task type.
+func (w *Worker) handlerFor(taskType string) (Handler, bool) {
+	if len(w.handlers) == 0 {
+		return SimulateHandler, true
+	}
+	handler, ok := w.handlers[taskType]
+	return handler, ok
+}
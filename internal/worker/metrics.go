@@ -3,16 +3,15 @@ package worker
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"sync/atomic"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
 )
 
 type MetricsCollector struct {
 	workerID string
-	redis    *redis.Client
+	broker   broker.Broker
 	metrics  *WorkerMetrics
 }
 
@@ -26,10 +25,10 @@ type MetricsSnapshot struct {
 	MemoryUsage    uint64    `json:"memory_usage"`
 }
 
-func NewMetricsCollector(workerID string, redis *redis.Client, metrics *WorkerMetrics) *MetricsCollector {
+func NewMetricsCollector(workerID string, b broker.Broker, metrics *WorkerMetrics) *MetricsCollector {
 	return &MetricsCollector{
 		workerID: workerID,
-		redis:    redis,
+		broker:   b,
 		metrics:  metrics,
 	}
 }
@@ -72,20 +71,14 @@ func (mc *MetricsCollector) publishMetrics(ctx context.Context, snapshot *Metric
 	}
 
 	// Publish current metrics
-	mc.redis.HSet(ctx, "worker:metrics", mc.workerID, data)
+	mc.broker.HashSet(ctx, broker.MetricsKey, mc.workerID, string(data))
 
 	// Store historical metrics (last 24 hours)
-	key := fmt.Sprintf("worker:%s:metrics:history", mc.workerID)
-	mc.redis.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(snapshot.Timestamp.Unix()),
-		Member: data,
-	})
+	key := broker.MetricsHistoryKey(mc.workerID)
+	mc.broker.SortedAdd(ctx, key, string(data), float64(snapshot.Timestamp.Unix()))
 
 	// Cleanup old metrics
-	mc.redis.ZRemRangeByScore(ctx, key,
-		"0",
-		fmt.Sprintf("%d", time.Now().Add(-24*time.Hour).Unix()),
-	)
+	mc.broker.SortedRemoveBelow(ctx, key, float64(time.Now().Add(-24*time.Hour).Unix()))
 }
 
 func (mc *MetricsCollector) collectCPUUsage() float64 {
@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
+)
+
+// Holding summarises the tasks assigned to or accepted by a worker.
+type Holding struct {
+	Tasks   int
+	Load    int
+	Types   map[string]int
+	Tenants map[string]int
+
+	// Load by queue priority, for the reserved capacity checks
+	Priorities map[int]int
+}
+
+func NewHolding() *Holding {
+	return &Holding{
+		Types:      make(map[string]int),
+		Tenants:    make(map[string]int),
+		Priorities: make(map[int]int),
+	}
+}
+
+// Add accounts for one task as stored in a worker's task hashes.
+func (h *Holding) Add(taskStr string) {
+	var t task.Task
+	if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+		return
+	}
+	h.AddTask(&t)
+}
+
+func (h *Holding) AddTask(t *task.Task) {
+	h.Tasks++
+	h.Load += t.Demand()
+	h.Types[t.Type]++
+	h.Tenants[tenant.Name(t.Tenant)]++
+	h.Priorities[t.QueuePriority()] += t.Demand()
+}
+
+// RemoveTask undoes AddTask.
+func (h *Holding) RemoveTask(t *task.Task) {
+	h.Tasks--
+	h.Load -= t.Demand()
+	h.Types[t.Type]--
+	if h.Types[t.Type] <= 0 {
+		delete(h.Types, t.Type)
+	}
+	h.Tenants[tenant.Name(t.Tenant)]--
+	h.Priorities[t.QueuePriority()] -= t.Demand()
+}
+
+func (h *Holding) Clone() *Holding {
+	clone := NewHolding()
+	clone.Tasks = h.Tasks
+	clone.Load = h.Load
+	for k, v := range h.Types {
+		clone.Types[k] = v
+	}
+	for k, v := range h.Tenants {
+		clone.Tenants[k] = v
+	}
+	for k, v := range h.Priorities {
+		clone.Priorities[k] = v
+	}
+	return clone
+}
+
+// LoadBelow returns the load of the tasks queued below level.
+func (h *Holding) LoadBelow(level int) int {
+	load := 0
+	for priority, demand := range h.Priorities {
+		if priority < level {
+			load += demand
+		}
+	}
+	return load
+}
+
+// ConflictsWith reports whether t's anti-affinity rules forbid it from
+// joining the held tasks.
+func (h *Holding) ConflictsWith(t *task.Task) bool {
+	for taskType := range h.Types {
+		if t.ConflictsWith(taskType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fits reports whether t fits in the remaining capacity. A task larger
+// than the whole capacity still fits on an empty worker so it can run at
+// all.
+func (h *Holding) Fits(t *task.Task, capacity int) bool {
+	return h.Load == 0 || h.Load+t.Demand() <= capacity
+}
+
+// Reserved reports whether t would have to use capacity the worker keeps
+// for higher priorities. Like Fits, a task larger than the unreserved
+// capacity may still take it all so it can run at all.
+func (h *Holding) Reserved(t *task.Task, record *registry.WorkerRecord) bool {
+	reserved := record.ReservedUnits()
+	if reserved == 0 || t.QueuePriority() >= record.Reservation.Priority {
+		return false
+	}
+	unreserved := record.CapacityUnits() - reserved
+	if unreserved <= 0 {
+		return true
+	}
+	low := h.LoadBelow(record.Reservation.Priority)
+	return low > 0 && low+t.Demand() > unreserved
+}
+
+// FitsOn reports whether t fits on the worker, both in its remaining
+// capacity and outside of its reservation if t's priority is too low.
+func (h *Holding) FitsOn(t *task.Task, record *registry.WorkerRecord) bool {
+	return h.Fits(t, record.CapacityUnits()) && !h.Reserved(t, record)
+}
+
+// ReservedInUse returns how much of the worker's reserved capacity the
+// held tasks take up: whatever the load exceeds the unreserved capacity
+// by.
+func (h *Holding) ReservedInUse(record *registry.WorkerRecord) int {
+	reserved := record.ReservedUnits()
+	if reserved == 0 {
+		return 0
+	}
+	return min(reserved, max(0, h.Load-(record.CapacityUnits()-reserved)))
+}
+
+// LoadHolding reads what a worker currently holds.
+func LoadHolding(ctx context.Context, b broker.Broker, workerID string) (*Holding, error) {
+	held, err := b.HashValues(ctx, broker.WorkerTasksKey(workerID), broker.WorkerProcessingKey(workerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tasks of worker %s: %w", workerID, err)
+	}
+
+	holding := NewHolding()
+	for _, tasks := range held {
+		for _, taskStr := range tasks {
+			holding.Add(taskStr)
+		}
+	}
+	return holding, nil
+}
+
+// canAccept reports whether this worker may take t on, given its handlers,
+// labels, free capacity and the anti-affinity rules against what it
+// already holds.
+func (w *Worker) canAccept(ctx context.Context, t *task.Task) bool {
+	holding, err := LoadHolding(ctx, w.broker, w.id)
+	if err != nil {
+		return false
+	}
+	return w.accepts(holding, t)
+}
+
+// accepts is canAccept against an already loaded holding.
+func (w *Worker) accepts(holding *Holding, t *task.Task) bool {
+	w.recordMu.Lock()
+	defer w.recordMu.Unlock()
+
+	return w.record.CanRun(t) && holding.FitsOn(t, w.record) && !holding.ConflictsWith(t) && !w.lowSlotsTaken(t)
+}
+
+// reserves reports whether t has to wait for capacity or slots this worker
+// keeps for higher priorities.
+func (w *Worker) reserves(holding *Holding, t *task.Task) bool {
+	w.recordMu.Lock()
+	defer w.recordMu.Unlock()
+	return holding.Reserved(t, w.record) || w.lowSlotsTaken(t)
+}
+
+func (w *Worker) belowReservation(t *task.Task) bool {
+	r := w.reservation
+	return r != nil && r.Slots > 0 && t.QueuePriority() < r.Priority
+}
+
+// lowSlots returns how many processors may run tasks below the reserved
+// priority at once: the current pool less the reserved slots.
+func (w *Worker) lowSlots() int32 {
+	return atomic.LoadInt32(&w.metrics.ActiveWorkers) - int32(w.reservation.Slots)
+}
+
+// lowSlotsTaken reports whether t is below the reserved priority while
+// every unreserved slot already runs such a task.
+func (w *Worker) lowSlotsTaken(t *task.Task) bool {
+	return w.belowReservation(t) && atomic.LoadInt32(&w.lowRunning) >= w.lowSlots()
+}
+
+// startLow takes an unreserved slot for a task below the reserved
+// priority, reporting whether one was free.
+func (w *Worker) startLow() bool {
+	for {
+		running := atomic.LoadInt32(&w.lowRunning)
+		if running >= w.lowSlots() {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&w.lowRunning, running, running+1) {
+			return true
+		}
+	}
+}
+
+// processingHolding reads the tasks this worker has accepted, leaving out
+// the ones only assigned to it.
+func (w *Worker) processingHolding(ctx context.Context) (*Holding, error) {
+	tasks, err := w.broker.HashGetAll(ctx, broker.WorkerProcessingKey(w.id))
+	if err != nil {
+		return nil, err
+	}
+
+	holding := NewHolding()
+	for _, taskStr := range tasks {
+		holding.Add(taskStr)
+	}
+	return holding, nil
+}
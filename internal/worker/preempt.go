@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+)
+
+// RequestPreemption asks a worker to stop one of its running tasks and
+// hand it back to its queue, wherever the worker runs.
+func RequestPreemption(ctx context.Context, b broker.Broker, workerID, taskID string) error {
+	if err := b.HashSet(ctx, broker.WorkerPreemptKey(workerID), taskID, time.Now().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to request preemption: %w", err)
+	}
+	return events.Publish(ctx, b, events.Event{Type: events.TaskPreempted, WorkerID: workerID, TaskID: taskID})
+}
+
+// runningTask is a task a processor is running, which can be cancelled to
+// preempt it or to abandon it to a drain.
+type runningTask struct {
+	cancel    context.CancelFunc
+	preempted bool
+	abandoned bool
+
+	// Set once the handler returned, closed once the task is finished with
+	finishing bool
+	done      chan struct{}
+}
+
+// startRunning returns the context to run a task in, cancelled if the task
+// is preempted or abandoned.
+func (w *Worker) startRunning(ctx context.Context, taskID string) context.Context {
+	taskCtx, cancel := context.WithCancel(ctx)
+	w.runningMu.Lock()
+	w.running[taskID] = &runningTask{cancel: cancel, done: make(chan struct{})}
+	w.runningMu.Unlock()
+	return taskCtx
+}
+
+// stopRunning marks a task whose handler returned as finishing and reports
+// whether it was preempted or abandoned. An abandoned task is no longer
+// this processor's to finish.
+func (w *Worker) stopRunning(taskID string) (preempted, abandoned bool) {
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+	r, ok := w.running[taskID]
+	if !ok {
+		return false, false
+	}
+	r.cancel()
+	if !r.abandoned {
+		r.finishing = true
+	}
+	return r.preempted, r.abandoned
+}
+
+// forgetRunning drops a task once its processor is done with it.
+func (w *Worker) forgetRunning(taskID string) {
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+	if r, ok := w.running[taskID]; ok {
+		close(r.done)
+		delete(w.running, taskID)
+	}
+}
+
+// abandonRunning cancels every task whose handler is still running, so a
+// drain can hand them back without them running twice, and returns what
+// to wait on for the tasks already finishing.
+func (w *Worker) abandonRunning() []chan struct{} {
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+
+	var finishing []chan struct{}
+	for _, r := range w.running {
+		if r.finishing {
+			finishing = append(finishing, r.done)
+			continue
+		}
+		r.abandoned = true
+		r.cancel()
+	}
+	return finishing
+}
+
+// preempt cancels a running task and reports whether it was running.
+func (w *Worker) preempt(taskID string) bool {
+	w.runningMu.Lock()
+	defer w.runningMu.Unlock()
+	r, ok := w.running[taskID]
+	if !ok {
+		return false
+	}
+	if !r.preempted {
+		w.logger.Printf("Preempting task %s", taskID)
+		r.preempted = true
+		r.cancel()
+	}
+	return true
+}
+
+// watchPreemptions cancels the running tasks the coordinator asks for.
+func (w *Worker) watchPreemptions(ctx context.Context) {
+	// Woken by the coordinator, polling only as a safety net
+	listener := events.Listen(ctx, w.broker, events.Channel(events.TaskPreempted, w.id))
+	defer listener.Close()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.shutdown:
+			return
+		case <-listener.C:
+		case <-ticker.C:
+		}
+
+		requests, err := w.broker.HashGetAll(ctx, broker.WorkerPreemptKey(w.id))
+		if err != nil {
+			continue
+		}
+		for taskID := range requests {
+			// Tasks that finished in the meantime need no preempting
+			if !w.preempt(taskID) {
+				w.broker.HashDelete(ctx, broker.WorkerPreemptKey(w.id), taskID)
+			}
+		}
+	}
+}
+
+// requeuePreempted hands a preempted task back to its queue. Preemption
+// is not the task's fault, so it does not count as a failed attempt.
+func (w *Worker) requeuePreempted(ctx context.Context, t *task.Task) {
+	t.Preemptions++
+	if err := w.returnTask(ctx, t); err != nil {
+		w.logger.Printf("Failed to requeue preempted task %s: %v", t.ID, err)
+	} else {
+		w.logger.Printf("Task %s preempted and returned to its queue", t.ID)
+	}
+	w.broker.HashDelete(ctx, broker.WorkerPreemptKey(w.id), t.ID)
+}
@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/ratelimit"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+)
+
+// throttle waits until the rate limit of t's type, if it has one, lets it
+// start, and returns how long it waited. The task keeps its slot while it
+// waits. When the broker fails the task is let through rather than held.
+func (w *Worker) throttle(ctx context.Context, t *task.Task) (time.Duration, error) {
+	limit, err := ratelimit.Get(ctx, w.broker, t.Type)
+	if err != nil {
+		w.logger.Printf("Failed to load rate limit of task type %s: %v", t.Type, err)
+		return 0, nil
+	}
+	if limit == nil {
+		return 0, nil
+	}
+
+	start := time.Now()
+	throttled := false
+	for {
+		wait, err := ratelimit.Take(ctx, w.broker, t.Type, limit, w.labels)
+		if err != nil {
+			w.logger.Printf("Failed to take rate limit token for task %s: %v", t.ID, err)
+			break
+		}
+		if wait == 0 {
+			break
+		}
+		if !throttled {
+			w.logger.Printf("Task %s throttled by the rate limit of task type %s", t.ID, t.Type)
+			throttled = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Since(start), ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	if !throttled {
+		return 0, nil
+	}
+
+	waited := time.Since(start)
+	if err := ratelimit.RecordThrottled(ctx, w.broker, t.Type, waited); err != nil {
+		w.logger.Printf("Failed to record throttled task %s: %v", t.ID, err)
+	}
+	return waited, nil
+}
@@ -2,24 +2,32 @@ package worker
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"sort"
 	"sync/atomic"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
 )
 
 type WorkStealer struct {
 	workerID string
-	redis    *redis.Client
+	broker   broker.Broker
 	metrics  *WorkerMetrics
+	accept   func(context.Context, *task.Task) bool
 }
 
-func NewWorkStealer(workerID string, redis *redis.Client, metrics *WorkerMetrics) *WorkStealer {
+// NewWorkStealer creates a stealer that only takes tasks accept allows,
+// so placement rules hold for stolen work too.
+func NewWorkStealer(workerID string, b broker.Broker, metrics *WorkerMetrics, accept func(context.Context, *task.Task) bool) *WorkStealer {
 	return &WorkStealer{
 		workerID: workerID,
-		redis:    redis,
+		broker:   b,
 		metrics:  metrics,
+		accept:   accept,
 	}
 }
 
@@ -45,42 +53,64 @@ func (ws *WorkStealer) monitorAndSteal(ctx context.Context) {
 
 func (ws *WorkStealer) attemptSteal(ctx context.Context) error {
 	// Get all workers
-	workers, err := ws.redis.HGetAll(ctx, "workers").Result()
+	workers, err := ws.broker.HashGetAll(ctx, broker.WorkersKey)
 	if err != nil {
 		return err
 	}
 
+	type victim struct {
+		id      string
+		queued  int
+		load    int
+		overcap bool
+	}
+
 	// Find busy workers
-	for workerID, _ := range workers {
+	var victims []victim
+	for workerID := range workers {
 		if workerID == ws.workerID {
 			continue
 		}
 
-		// Check their queue length
-		queueKey := fmt.Sprintf("worker:%s:tasks", workerID)
-		queueLen, err := ws.redis.HLen(ctx, queueKey).Result()
+		holding, err := LoadHolding(ctx, ws.broker, workerID)
 		if err != nil {
 			continue
 		}
+		queued, err := ws.broker.HashLen(ctx, broker.WorkerTasksKey(workerID))
+		if err != nil || queued == 0 {
+			continue
+		}
 
-		// If they have more than 2 tasks, try to steal
-		if queueLen > 2 {
-			ws.stealTasks(ctx, workerID, queueKey)
+		capacity := 0
+		if record, err := registry.Get(ctx, ws.broker, workerID); err == nil && record != nil {
+			capacity = record.CapacityUnits()
+		}
+
+		// Steal from workers with a backlog or holding more than they can run
+		overcap := capacity > 0 && holding.Load > capacity
+		if queued > 2 || overcap {
+			victims = append(victims, victim{id: workerID, queued: int(queued), load: holding.Load, overcap: overcap})
 		}
 	}
 
+	// Relieve the most loaded workers first
+	sort.Slice(victims, func(i, j int) bool {
+		return victims[i].load > victims[j].load
+	})
+	for _, v := range victims {
+		ws.stealTasks(ctx, v.id, broker.WorkerTasksKey(v.id), max(v.queued/2, 1))
+	}
+
 	return nil
 }
 
-func (ws *WorkStealer) stealTasks(ctx context.Context, targetWorker, queueKey string) {
+func (ws *WorkStealer) stealTasks(ctx context.Context, targetWorker, queueKey string, stealCount int) {
 	// Get tasks from target worker
-	tasks, err := ws.redis.HGetAll(ctx, queueKey).Result()
+	tasks, err := ws.broker.HashGetAll(ctx, queueKey)
 	if err != nil {
 		return
 	}
 
-	// Try to steal half of their tasks
-	stealCount := len(tasks) / 2
 	stolen := 0
 
 	for taskID, taskData := range tasks {
@@ -88,17 +118,23 @@ func (ws *WorkStealer) stealTasks(ctx context.Context, targetWorker, queueKey st
 			break
 		}
 
+		// Leave tasks we are not allowed to run or have no room for
+		var t task.Task
+		if err := json.Unmarshal([]byte(taskData), &t); err != nil || !ws.accept(ctx, &t) {
+			continue
+		}
+
 		// Try to move task to our queue
-		err = ws.redis.HSetNX(ctx,
-			fmt.Sprintf("worker:%s:tasks", ws.workerID),
-			taskID,
-			taskData,
-		).Err()
+		_, err = ws.broker.HashSetNew(ctx, broker.WorkerTasksKey(ws.workerID), taskID, taskData)
 
 		if err == nil {
 			// If successful, remove from original worker
-			ws.redis.HDel(ctx, queueKey, taskID)
+			ws.broker.HashDelete(ctx, queueKey, taskID)
 			stolen++
 		}
 	}
+
+	if stolen > 0 {
+		events.Publish(ctx, ws.broker, events.Event{Type: events.TaskAssigned, WorkerID: ws.workerID})
+	}
 }
@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+)
+
+const (
+	// How often a worker renews its claim on the entries it holds and
+	// looks for entries left behind by dead consumers
+	claimInterval = 10 * time.Second
+	// How long an entry must go unclaimed before another worker takes it
+	claimIdle = time.Minute
+)
+
+// streamEntry locates the stream entry a task was delivered in, so it can
+// be acknowledged once the task is done.
+type streamEntry struct {
+	stream string
+	id     string
+}
+
+// resolveBackend returns the configured queue backend, or the cluster's
+// when none was set.
+func (w *Worker) resolveBackend(ctx context.Context) string {
+	if w.backend != "" {
+		return w.backend
+	}
+	return queue.Current(ctx, w.broker)
+}
+
+// consumeStreams reads tasks from the priority streams through the
+// worker's consumer group whenever a processor is free.
+func (w *Worker) consumeStreams(ctx context.Context) {
+	defer close(w.fetchDone)
+
+	var keys []string
+	var lastSample, lastRefresh time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.shutdown:
+			return
+		default:
+		}
+		if w.isDraining() {
+			return
+		}
+		if time.Since(lastRefresh) > tenantRefresh {
+			// Join the group on the streams of new tenants first
+			tenants := w.admittedTenants(ctx)
+			next := queue.StreamKeys(queue.CurrentClasses(ctx, w.broker).Levels(), tenants)
+			if len(next) != len(keys) {
+				if err := queue.EnsureGroups(ctx, w.broker, w.group, tenants); err != nil {
+					w.logger.Printf("Failed to join consumer group: %v", err)
+				}
+			}
+			keys = next
+			lastRefresh = time.Now()
+		}
+		if len(keys) == 0 {
+			w.pause(tenantRefresh)
+			continue
+		}
+
+		free := int(atomic.LoadInt32(&w.metrics.IdleWorkers)) - len(w.tasks)
+		if free <= 0 {
+			if time.Since(lastSample) > time.Second {
+				w.sampleBacklog(ctx)
+				lastSample = time.Now()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.shutdown:
+				return
+			case <-w.slotFreed:
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		// Take from the highest priority stream that has entries first
+		accepted, rejected := 0, 0
+		for _, key := range keys {
+			if accepted >= free {
+				break
+			}
+			entries, err := w.broker.GroupRead(ctx, w.group, w.id, []string{key}, int64(free-accepted), 0)
+			if err != nil {
+				w.logger.Printf("Failed to read %s: %v", key, err)
+				w.rejoinGroup(ctx, err)
+				break
+			}
+			a, r := w.handleEntries(ctx, entries)
+			accepted, rejected = accepted+a, rejected+r
+		}
+		if accepted > 0 {
+			continue
+		}
+
+		// Nothing ready, wait for the next entry on any stream
+		if rejected == 0 {
+			entries, err := w.broker.GroupRead(ctx, w.group, w.id, keys, 1, pullTimeout)
+			if err != nil {
+				w.logger.Printf("Failed to read task streams: %v", err)
+				w.rejoinGroup(ctx, err)
+				w.pause(time.Second)
+				continue
+			}
+			accepted, rejected = w.handleEntries(ctx, entries)
+		}
+
+		// Give workers that can run the rejected tasks a chance
+		if accepted == 0 && rejected > 0 {
+			w.pause(pullBackoff)
+		}
+	}
+}
+
+// rejoinGroup recreates the consumer group after the streams were reset,
+// or joins it on the streams of a new tenant.
+func (w *Worker) rejoinGroup(ctx context.Context, err error) {
+	if !errors.Is(err, broker.ErrNoGroup) {
+		return
+	}
+	if err := queue.EnsureGroups(ctx, w.broker, w.group, w.loadTenants(ctx)); err != nil {
+		w.logger.Printf("Failed to rejoin consumer group: %v", err)
+	}
+}
+
+func (w *Worker) handleEntries(ctx context.Context, entries []broker.Entry) (accepted, rejected int) {
+	for _, entry := range entries {
+		if w.handleEntry(ctx, entry) {
+			accepted++
+		} else {
+			rejected++
+		}
+	}
+	return accepted, rejected
+}
+
+// handleEntry accepts a delivered entry, or hands it back to the group if
+// this worker cannot run it. It reports whether the task was accepted.
+func (w *Worker) handleEntry(ctx context.Context, entry broker.Entry) bool {
+	var t task.Task
+	if err := json.Unmarshal([]byte(entry.Task), &t); err != nil {
+		w.logger.Printf("Dropping unreadable entry %s from %s: %v", entry.ID, entry.Stream, err)
+		w.broker.GroupAck(ctx, entry.Stream, w.group, entry.ID)
+		return false
+	}
+
+	w.entriesMu.Lock()
+	w.entries[t.ID] = streamEntry{stream: entry.Stream, id: entry.ID}
+	w.entriesMu.Unlock()
+
+	if !w.canAccept(ctx, &t) {
+		// Re-add it for the rest of the group and acknowledge our copy
+		if err := w.requeueTask(ctx, &t); err != nil {
+			w.logger.Printf("Failed to return task %s: %v", t.ID, err)
+		}
+		return false
+	}
+
+	w.acceptTask(ctx, &t, entry.Task)
+	return true
+}
+
+// ackTask acknowledges the stream entry a task was delivered in.
+func (w *Worker) ackTask(ctx context.Context, taskID string) {
+	w.entriesMu.Lock()
+	entry, ok := w.entries[taskID]
+	delete(w.entries, taskID)
+	w.entriesMu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := w.broker.GroupAck(ctx, entry.stream, w.group, entry.id); err != nil {
+		w.logger.Printf("Failed to acknowledge task %s: %v", taskID, err)
+	}
+}
+
+// maintainClaims keeps the entries this worker holds from looking
+// abandoned, and takes over entries whose consumer stopped renewing them.
+func (w *Worker) maintainClaims(ctx context.Context) {
+	ticker := time.NewTicker(claimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.shutdown:
+			return
+		case <-ticker.C:
+			w.renewClaims(ctx)
+
+			free := int(atomic.LoadInt32(&w.metrics.IdleWorkers)) - len(w.tasks)
+			if free > 0 {
+				w.reclaimAbandoned(ctx, free)
+			}
+		}
+	}
+}
+
+// renewClaims resets the idle time of the entries this worker holds.
+func (w *Worker) renewClaims(ctx context.Context) {
+	byStream := make(map[string][]string)
+	w.entriesMu.Lock()
+	for _, entry := range w.entries {
+		byStream[entry.stream] = append(byStream[entry.stream], entry.id)
+	}
+	w.entriesMu.Unlock()
+
+	for stream, ids := range byStream {
+		if err := w.broker.GroupClaim(ctx, stream, w.group, w.id, ids...); err != nil {
+			w.logger.Printf("Failed to renew claims on %s: %v", stream, err)
+		}
+	}
+}
+
+// reclaimAbandoned takes over entries that have been pending longer than
+// claimIdle, which only happens when their consumer died.
+func (w *Worker) reclaimAbandoned(ctx context.Context, free int) {
+	levels := queue.CurrentClasses(ctx, w.broker).Levels()
+	for _, key := range queue.StreamKeys(levels, w.loadTenants(ctx)) {
+		if free <= 0 {
+			return
+		}
+
+		entries, err := w.broker.GroupAutoClaim(ctx, key, w.group, w.id, claimIdle, int64(free))
+		if err != nil {
+			w.logger.Printf("Failed to reclaim entries from %s: %v", key, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			// Entries trimmed in the meantime come back empty
+			if entry.Task == "" {
+				w.broker.GroupAck(ctx, key, w.group, entry.ID)
+				continue
+			}
+			w.logger.Printf("Reclaimed entry %s from %s", entry.ID, key)
+			if w.handleEntry(ctx, entry) {
+				free--
+			}
+		}
+	}
+}
+
+// leaveGroup removes this worker's consumers, unless it still holds
+// entries that would be lost with them.
+func (w *Worker) leaveGroup(ctx context.Context) {
+	w.entriesMu.Lock()
+	held := len(w.entries)
+	w.entriesMu.Unlock()
+	if held > 0 {
+		w.logger.Printf("Keeping consumer for %d unacknowledged entries", held)
+		return
+	}
+
+	levels := queue.CurrentClasses(ctx, w.broker).Levels()
+	for _, key := range queue.StreamKeys(levels, w.loadTenants(ctx)) {
+		w.broker.GroupRemoveConsumer(ctx, key, w.group, w.id)
+	}
+}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/events"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/registry"
 	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/tenant"
 	"github.com/google/uuid"
 )
 
@@ -25,15 +31,48 @@ type WorkerMetrics struct {
 }
 
 type Worker struct {
-	id       string
-	logger   *log.Logger
-	redis    *redis.Client
-	poolSize int
-	tasks    chan *task.Task
-	results  chan *task.Result
-	metrics  *WorkerMetrics
-	wg       sync.WaitGroup
-	shutdown chan struct{}
+	id           string
+	logger       *log.Logger
+	broker       broker.Broker
+	scheduler    *task.Scheduler
+	poolSize     int
+	drainTimeout time.Duration
+	enableSteal  bool
+	minWorkers   int
+	maxWorkers   int
+	handlers     map[string]Handler
+	labels       map[string]string
+	capacity     int
+	reservation  *registry.Reservation
+	dispatch     string
+	backend      string
+	group        string
+	version      string
+	record       *registry.WorkerRecord
+	recordMu     sync.Mutex
+	tasks        chan *task.Task
+	metrics      *WorkerMetrics
+	wg           sync.WaitGroup
+	workCtx      context.Context
+	retire       chan struct{}
+	shutdown     chan struct{}
+	draining     int32
+	drainReq     chan struct{}
+	drainOnce    sync.Once
+	done         chan struct{}
+	fetchDone    chan struct{}
+	slotFreed    chan struct{}
+
+	// Stream entries of the tasks held, by task ID
+	entriesMu sync.Mutex
+	entries   map[string]streamEntry
+
+	// Tasks being run, by task ID
+	runningMu sync.Mutex
+	running   map[string]*runningTask
+
+	// Processors running tasks below the reserved priority
+	lowRunning int32
 }
 
 type Option func(*Worker)
@@ -44,11 +83,26 @@ func WithLogger(logger *log.Logger) Option {
 	}
 }
 
-func WithRedis(url string) Option {
+// WithRedis connects to Redis, see broker.ParseRedisURL for building the
+// options from a URL.
+func WithRedis(opts *broker.RedisOptions) Option {
 	return func(w *Worker) {
-		w.redis = redis.NewClient(&redis.Options{
-			Addr: url,
-		})
+		w.broker = broker.NewRedis(opts)
+	}
+}
+
+// WithBroker shares an existing broker, e.g. to run the worker in the same
+// process as the coordinator on an in-memory broker.
+func WithBroker(b broker.Broker) Option {
+	return func(w *Worker) {
+		w.broker = b
+	}
+}
+
+// WithID reuses a known worker ID, e.g. when restarting a worker in place.
+func WithID(id string) Option {
+	return func(w *Worker) {
+		w.id = id
 	}
 }
 
@@ -58,16 +112,111 @@ func WithPoolSize(size int) Option {
 	}
 }
 
+// WithDrainTimeout sets how long a drain waits for in-flight tasks before
+// returning them to their queues.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(w *Worker) {
+		w.drainTimeout = timeout
+	}
+}
+
+func WithWorkStealing(enabled bool) Option {
+	return func(w *Worker) {
+		w.enableSteal = enabled
+	}
+}
+
+// WithAutoScaling lets the pool grow and shrink between min and max
+// processors depending on the queue length.
+func WithAutoScaling(min, max int) Option {
+	return func(w *Worker) {
+		w.minWorkers = min
+		w.maxWorkers = max
+	}
+}
+
+// WithHandler registers the handler for a task type. Once any handler is
+// registered, tasks of other types fail instead of being simulated.
+func WithHandler(taskType string, handler Handler) Option {
+	return func(w *Worker) {
+		if w.handlers == nil {
+			w.handlers = make(map[string]Handler)
+		}
+		w.handlers[taskType] = handler
+	}
+}
+
+func WithLabels(labels map[string]string) Option {
+	return func(w *Worker) {
+		w.labels = labels
+	}
+}
+
+// WithCapacity sets the total task demand the worker takes on at once.
+// By default every processor slot counts for registry.DefaultUnitsPerSlot.
+func WithCapacity(units int) Option {
+	return func(w *Worker) {
+		w.capacity = units
+	}
+}
+
+// WithReservation keeps slots of the pool for tasks at or above priority.
+// Lower priority tasks only ever use the rest.
+func WithReservation(slots, priority int) Option {
+	return func(w *Worker) {
+		if slots > 0 {
+			w.reservation = &registry.Reservation{Slots: slots, Priority: priority}
+		}
+	}
+}
+
+// WithDispatchMode selects push or pull dispatch. Without it the worker
+// follows the mode the coordinator publishes.
+func WithDispatchMode(mode string) Option {
+	return func(w *Worker) {
+		w.dispatch = mode
+	}
+}
+
+// WithQueueBackend selects the queue layout to consume. Without it the
+// worker follows the backend the coordinator publishes.
+func WithQueueBackend(backend string) Option {
+	return func(w *Worker) {
+		w.backend = backend
+	}
+}
+
+// WithConsumerGroup sets the consumer group the worker reads task streams
+// in. Workers in the same group share the tasks.
+func WithConsumerGroup(group string) Option {
+	return func(w *Worker) {
+		w.group = group
+	}
+}
+
+func WithVersion(version string) Option {
+	return func(w *Worker) {
+		w.version = version
+	}
+}
+
 func NewWorker(opts ...Option) *Worker {
 	w := &Worker{
-		id:       uuid.New().String(),
-		poolSize: 1,
-		tasks:    make(chan *task.Task, 1000),
-		results:  make(chan *task.Result, 1000),
-		metrics: &WorkerMetrics{
-			IdleWorkers: 1,
-		},
-		shutdown: make(chan struct{}),
+		id:           uuid.New().String(),
+		poolSize:     1,
+		drainTimeout: 30 * time.Second,
+		version:      "dev",
+		group:        queue.DefaultGroup,
+		tasks:        make(chan *task.Task, 1000),
+		metrics:      &WorkerMetrics{},
+		retire:       make(chan struct{}),
+		shutdown:     make(chan struct{}),
+		drainReq:     make(chan struct{}),
+		done:         make(chan struct{}),
+		fetchDone:    make(chan struct{}),
+		slotFreed:    make(chan struct{}, 1),
+		entries:      make(map[string]streamEntry),
+		running:      make(map[string]*runningTask),
 	}
 
 	for _, opt := range opts {
@@ -77,54 +226,181 @@ func NewWorker(opts ...Option) *Worker {
 	if w.logger == nil {
 		w.logger = log.New(os.Stdout, fmt.Sprintf("[Worker %s] ", w.id), log.LstdFlags)
 	}
+	w.scheduler = task.NewScheduler(w.broker)
 
 	return w
 }
 
+func (w *Worker) ID() string {
+	return w.id
+}
+
 func (w *Worker) Start(ctx context.Context) error {
+	defer close(w.done)
 	w.logger.Printf("Starting worker with pool size %d", w.poolSize)
 
+	w.backend = w.resolveBackend(ctx)
+	mode := w.resolveDispatchMode(ctx)
+	if w.backend == queue.Streams {
+		if err := queue.EnsureGroups(ctx, w.broker, w.group, w.loadTenants(ctx)); err != nil {
+			return err
+		}
+	}
+
 	err := w.register(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to register worker: %w", err)
 	}
 
-	atomic.StoreInt32(&w.metrics.IdleWorkers, int32(w.poolSize))
-	atomic.StoreInt32(&w.metrics.ActiveWorkers, int32(w.poolSize))
+	// Run on a context that outlives ctx so that cancellation drains the
+	// worker instead of abandoning buffered and in-flight tasks
+	workCtx, stopWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWork()
+	w.workCtx = workCtx
+
+	// Stealing and scaling stop as soon as a drain begins
+	scaleCtx, stopScaling := context.WithCancel(workCtx)
+	defer stopScaling()
 
-	for i := 0; i < w.poolSize; i++ {
-		w.wg.Add(1)
-		go w.processTask(ctx)
+	poolSize := w.poolSize
+	if w.maxWorkers > 0 {
+		poolSize = max(min(poolSize, w.maxWorkers), w.minWorkers)
+	}
+	for i := 0; i < poolSize; i++ {
+		w.spawnProcessor()
 	}
 
-	go w.sendHeartbeat(ctx)
-	go w.checkForWork(ctx)
-	go w.submitResults(ctx)
+	if w.maxWorkers > 0 {
+		NewAutoScaler(int32(w.minWorkers), int32(w.maxWorkers), w.metrics).Start(scaleCtx, w)
+	}
+	// Stealing only applies to tasks pushed to other workers
+	if w.enableSteal && w.backend == queue.SortedSets && mode == DispatchPush {
+		NewWorkStealer(w.id, w.broker, w.metrics, w.canAccept).Start(scaleCtx)
+	}
 
-	<-ctx.Done()
-	w.logger.Printf("Context cancelled, initiating shutdown")
-	close(w.shutdown)
-	w.wg.Wait()
+	go w.sendHeartbeat(workCtx)
+	switch {
+	case w.backend == queue.Streams:
+		w.logger.Printf("Consuming task streams in group %s", w.group)
+		go w.consumeStreams(workCtx)
+		go w.maintainClaims(workCtx)
+	case mode == DispatchPull:
+		w.logger.Printf("Pulling tasks from the priority queues")
+		go w.pullWork(workCtx)
+	default:
+		go w.checkForWork(workCtx)
+		go w.watchPreemptions(workCtx)
+	}
+	go w.watchDrainRequests(workCtx)
+
+	select {
+	case <-ctx.Done():
+		w.logger.Printf("Context cancelled, draining worker")
+	case <-w.drainReq:
+		w.logger.Printf("Drain requested, draining worker")
+	}
+
+	stopScaling()
+	w.drain(workCtx)
 	return ctx.Err()
 }
 
-func (w *Worker) register(ctx context.Context) error {
-	pipe := w.redis.Pipeline()
+func (w *Worker) spawnProcessor() {
+	w.wg.Add(1)
+	atomic.AddInt32(&w.metrics.ActiveWorkers, 1)
+	atomic.AddInt32(&w.metrics.IdleWorkers, 1)
+	go w.processTask(w.workCtx)
+	w.notifySlotFreed()
+}
 
-	// Register worker
-	pipe.HSet(ctx, "workers", w.id, time.Now().Unix())
+// retireProcessor stops one idle processor, giving up after timeout.
+func (w *Worker) retireProcessor(timeout time.Duration) bool {
+	select {
+	case w.retire <- struct{}{}:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Drain asks a running worker to stop pulling work, hand back unstarted
+// tasks, finish what is in flight and deregister. It blocks until the
+// worker has stopped or ctx is done.
+func (w *Worker) Drain(ctx context.Context) error {
+	w.drainOnce.Do(func() {
+		close(w.drainReq)
+	})
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once Start has returned.
+func (w *Worker) Done() <-chan struct{} {
+	return w.done
+}
+
+// Draining reports whether the worker has started draining.
+func (w *Worker) Draining() bool {
+	return w.isDraining()
+}
 
-	// Clean up any previous state
-	pipe.Del(ctx, fmt.Sprintf("worker:%s:tasks", w.id))
-	pipe.Del(ctx, fmt.Sprintf("worker:%s:results", w.id))
-	pipe.Del(ctx, fmt.Sprintf("worker:%s:processing", w.id))
+func (w *Worker) isDraining() bool {
+	return atomic.LoadInt32(&w.draining) == 1
+}
 
-	_, err := pipe.Exec(ctx)
+func (w *Worker) register(ctx context.Context) error {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	w.record = &registry.WorkerRecord{
+		ID:        w.id,
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		Version:   w.version,
+		PoolSize:  w.poolSize,
+		TaskTypes: w.taskTypes(),
+		Labels:    w.labels,
+		Capacity: registry.Capacity{
+			Slots: max(w.poolSize, w.maxWorkers),
+			CPUs:  runtime.NumCPU(),
+			Units: w.capacity,
+		},
+		StartedAt:     now,
+		LastHeartbeat: now,
+		Reservation:   w.reservation,
+	}
+	if err := w.validateReservation(ctx); err != nil {
+		return err
+	}
+	if err := registry.Publish(ctx, w.broker, w.record); err != nil {
+		return err
+	}
+
+	// Clean up any previous state. Results of an earlier run under the same
+	// ID are left for the coordinator to collect.
+	err := w.broker.Delete(ctx, broker.WorkerTasksKey(w.id), broker.WorkerProcessingKey(w.id), broker.WorkerPreemptKey(w.id))
 	if err != nil {
+		return fmt.Errorf("failed to clear worker state: %w", err)
+	}
+	if err := w.broker.SetRemove(ctx, DrainingKey, w.id); err != nil {
+		return fmt.Errorf("failed to clear drain request: %w", err)
+	}
+
+	// Register worker
+	if err := w.broker.HashSet(ctx, broker.WorkersKey, w.id, heartbeat()); err != nil {
 		return fmt.Errorf("failed to register worker: %w", err)
 	}
 
-	w.logger.Printf("Worker registered successfully")
+	events.Publish(ctx, w.broker, events.Event{Type: events.WorkerJoined, WorkerID: w.id})
+
+	w.logger.Printf("Worker registered successfully (host %s, pid %d, version %s)", hostname, w.record.PID, w.version)
+	if r := w.reservation; r != nil {
+		w.logger.Printf("Reserving %d slots for priority %d and above", r.Slots, r.Priority)
+	}
 	return nil
 }
 
@@ -139,16 +415,66 @@ func (w *Worker) sendHeartbeat(ctx context.Context) {
 		case <-w.shutdown:
 			return
 		case <-ticker.C:
-			err := w.redis.HSet(ctx, "workers", w.id, time.Now().Unix()).Err()
+			err := w.broker.HashSet(ctx, broker.WorkersKey, w.id, heartbeat())
 			if err != nil {
 				w.logger.Printf("Failed to send heartbeat: %v", err)
 			}
+			if err := w.publishRecord(ctx); err != nil {
+				w.logger.Printf("Failed to refresh registration: %v", err)
+			}
 		}
 	}
 }
 
+// validateReservation checks the reservation against the pool and the
+// cluster's priority classes.
+func (w *Worker) validateReservation(ctx context.Context) error {
+	r := w.reservation
+	if r == nil {
+		return nil
+	}
+	if r.Slots > w.record.Capacity.Slots {
+		return fmt.Errorf("cannot reserve %d of %d slots", r.Slots, w.record.Capacity.Slots)
+	}
+	if _, ok := queue.CurrentClasses(ctx, w.broker).Class(r.Priority); !ok {
+		return fmt.Errorf("reserved priority %d is not the level of a priority class", r.Priority)
+	}
+	return nil
+}
+
+// publishRecord refreshes the dynamic parts of the registration record.
+func (w *Worker) publishRecord(ctx context.Context) error {
+	w.recordMu.Lock()
+	defer w.recordMu.Unlock()
+
+	w.record.PoolSize = int(atomic.LoadInt32(&w.metrics.ActiveWorkers))
+	w.record.Draining = w.isDraining()
+	w.record.LastHeartbeat = time.Now()
+	return registry.Publish(ctx, w.broker, w.record)
+}
+
+// heartbeat is the value workers record in broker.WorkersKey.
+func heartbeat() string {
+	return strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+// taskTypes lists the task types this worker has handlers for.
+func (w *Worker) taskTypes() []string {
+	if len(w.handlers) == 0 {
+		return []string{registry.AnyTaskType}
+	}
+	return handlerNames(w.handlers)
+}
+
 func (w *Worker) checkForWork(ctx context.Context) {
-	ticker := time.NewTicker(100 * time.Millisecond)
+	defer close(w.fetchDone)
+
+	// Woken when the coordinator assigns us tasks, polling only as a
+	// safety net
+	listener := events.Listen(ctx, w.broker, events.Channel(events.TaskAssigned, w.id))
+	defer listener.Close()
+
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -157,37 +483,65 @@ func (w *Worker) checkForWork(ctx context.Context) {
 			return
 		case <-w.shutdown:
 			return
+		case <-listener.C:
 		case <-ticker.C:
-			tasks, err := w.redis.HGetAll(ctx, fmt.Sprintf("worker:%s:tasks", w.id)).Result()
-			if err != nil {
-				w.logger.Printf("Failed to fetch tasks: %v", err)
+		}
+
+		if w.isDraining() {
+			return
+		}
+
+		tasks, err := w.broker.HashGetAll(ctx, broker.WorkerTasksKey(w.id))
+		if err != nil {
+			w.logger.Printf("Failed to fetch tasks: %v", err)
+			continue
+		}
+
+		if len(tasks) > 0 {
+			w.logger.Printf("Found %d tasks to process", len(tasks))
+		}
+
+		atomic.StoreInt64(&w.metrics.QueueLength, int64(len(tasks)))
+
+		// Low priority tasks wait in the assigned hash while accepting
+		// them would eat into the reserved slots
+		holding, err := w.processingHolding(ctx)
+		if err != nil {
+			w.logger.Printf("Failed to fetch processing tasks: %v", err)
+			continue
+		}
+
+		for taskID, taskStr := range tasks {
+			var t task.Task
+			if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
+				w.logger.Printf("Failed to unmarshal task %s: %v", taskID, err)
+				// Move to failed tasks
+				w.broker.HashSet(ctx, tenant.FailedKey(tenant.Default), taskID, taskStr)
+				w.broker.HashDelete(ctx, broker.WorkerTasksKey(w.id), taskID)
 				continue
 			}
 
-			if len(tasks) > 0 {
-				w.logger.Printf("Found %d tasks to process", len(tasks))
+			if w.reserves(holding, &t) {
+				continue
 			}
 
-			atomic.StoreInt64(&w.metrics.QueueLength, int64(len(tasks)))
-
-			for taskID, taskStr := range tasks {
-				var t task.Task
-				if err := json.Unmarshal([]byte(taskStr), &t); err != nil {
-					w.logger.Printf("Failed to unmarshal task %s: %v", taskID, err)
-					// Move to failed tasks
-					w.redis.HSet(ctx, "failed_tasks", taskID, taskStr)
-					w.redis.HDel(ctx, fmt.Sprintf("worker:%s:tasks", w.id), taskID)
-					continue
-				}
+			// Accepted tasks count as processing until they finish, so the
+			// coordinator sees everything this worker holds. The entry is
+			// moved before a processor can pick the task up, so the
+			// processor's removal of it always comes last.
+			if err := w.broker.HashMove(ctx, broker.WorkerTasksKey(w.id), broker.WorkerProcessingKey(w.id), taskID, taskStr); err != nil {
+				w.logger.Printf("Failed to record task %s as processing: %v", t.ID, err)
+				continue
+			}
 
-				// Try to send task for processing
-				select {
-				case w.tasks <- &t:
-					w.logger.Printf("Task %s queued for processing", t.ID)
-					w.redis.HDel(ctx, fmt.Sprintf("worker:%s:tasks", w.id), taskID)
-				case <-time.After(100 * time.Millisecond):
-					w.logger.Printf("Failed to queue task %s - processing channel full", t.ID)
-				}
+			// Try to send task for processing
+			select {
+			case w.tasks <- &t:
+				holding.AddTask(&t)
+				w.logger.Printf("Task %s queued for processing", t.ID)
+			case <-time.After(100 * time.Millisecond):
+				w.logger.Printf("Failed to queue task %s - processing channel full", t.ID)
+				w.broker.HashMove(ctx, broker.WorkerProcessingKey(w.id), broker.WorkerTasksKey(w.id), taskID, taskStr)
 			}
 		}
 	}
@@ -196,6 +550,7 @@ func (w *Worker) checkForWork(ctx context.Context) {
 func (w *Worker) processTask(ctx context.Context) {
 	defer w.wg.Done()
 	defer atomic.AddInt32(&w.metrics.ActiveWorkers, -1)
+	defer atomic.AddInt32(&w.metrics.IdleWorkers, -1)
 
 	for {
 		select {
@@ -203,84 +558,147 @@ func (w *Worker) processTask(ctx context.Context) {
 			return
 		case <-w.shutdown:
 			return
+		case <-w.retire:
+			return
 		case t := <-w.tasks:
 			if t == nil {
 				continue
 			}
 
+			// Picked up after a drain started, hand it back unstarted
+			if w.isDraining() {
+				if err := w.returnTask(ctx, t); err != nil {
+					w.logger.Printf("Failed to requeue task %s: %v", t.ID, err)
+				}
+				continue
+			}
+
+			// Tasks below the reserved priority stay off the reserved
+			// slots, one that finds them all taken goes back to its queue
+			low := w.belowReservation(t)
+			if low && !w.startLow() {
+				w.logger.Printf("Task %s would take a reserved slot, requeueing it", t.ID)
+				if err := w.returnTask(ctx, t); err != nil {
+					w.logger.Printf("Failed to requeue task %s: %v", t.ID, err)
+				}
+				continue
+			}
+
 			atomic.AddInt32(&w.metrics.IdleWorkers, -1)
 			w.logger.Printf("Processing task %s", t.ID)
 
 			result := &task.Result{
 				TaskID:    t.ID,
+				Tenant:    t.Tenant,
+				Type:      t.Type,
 				StartTime: time.Now(),
 				WorkerID:  w.id,
 				Status:    task.StatusProcessing,
+
+				Priority:          t.Priority,
+				EffectivePriority: t.QueuePriority(),
+				Preemptions:       t.Preemptions,
 			}
 
-			// Mark task as processing
+			var throttled time.Duration
+			var err error
+
+			// Mark task as processing, from when on it can be preempted
+			taskCtx := w.startRunning(ctx, t.ID)
 			t.Status = task.StatusProcessing
 			taskBytes, _ := json.Marshal(t)
-			w.redis.HSet(ctx, fmt.Sprintf("worker:%s:processing", w.id), t.ID, taskBytes)
-
-			// Simulate work
-			time.Sleep(time.Duration(t.ComplexityScore) * time.Second)
-
-			result.EndTime = time.Now()
-			result.Status = task.StatusCompleted
-
-			atomic.AddUint64(&w.metrics.TasksProcessed, 1)
-			atomic.AddInt32(&w.metrics.IdleWorkers, 1)
-
-			// Remove from processing set
-			w.redis.HDel(ctx, fmt.Sprintf("worker:%s:processing", w.id), t.ID)
-
-			// Queue the result
-			select {
-			case w.results <- result:
-				w.logger.Printf("Task %s completed and result queued", t.ID)
-			case <-time.After(100 * time.Millisecond):
-				w.logger.Printf("Failed to queue result for task %s", t.ID)
+			w.broker.HashSet(ctx, broker.WorkerProcessingKey(w.id), t.ID, string(taskBytes))
+
+			if t.IsOverdue() && t.OnDeadlineMiss() == task.DeadlineDrop {
+				// Too late to be of use, don't spend a slot on it
+				result.Status = task.StatusExpired
+				result.Error = fmt.Sprintf("deadline passed at %s before the task started", t.Deadline.Format(time.RFC3339))
+			} else if handler, ok := w.handlerFor(t.Type); !ok {
+				result.Status = task.StatusFailed
+				result.Error = fmt.Sprintf("no handler for task type %q", t.Type)
+			} else if throttled, err = w.throttle(taskCtx, t); err != nil {
+				result.Status = task.StatusFailed
+				result.Error = err.Error()
+			} else if output, err := handler(taskCtx, t); err != nil {
+				result.Status = task.StatusFailed
+				result.Error = err.Error()
+			} else {
+				result.Status = task.StatusCompleted
+				result.Output = output
+			}
+			preempted, abandoned := w.stopRunning(t.ID)
+			if low {
+				atomic.AddInt32(&w.lowRunning, -1)
 			}
-		}
-	}
-}
 
-func (w *Worker) submitResults(ctx context.Context) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-w.shutdown:
-			return
-		case result := <-w.results:
-			if result == nil {
+			// A task handed back while it ran is someone else's now, so
+			// its result is dropped
+			if abandoned || w.handedBack(ctx, t.ID) {
+				w.logger.Printf("Task %s was handed back while it ran, dropping its result", t.ID)
+				w.forgetRunning(t.ID)
+				atomic.AddInt32(&w.metrics.IdleWorkers, 1)
+				w.notifySlotFreed()
 				continue
 			}
+			result.EndTime = time.Now()
+			result.DeadlineMissed = t.Deadline != nil && result.EndTime.After(*t.Deadline)
+			result.Metrics = &task.TaskMetrics{
+				ProcessingTime: result.EndTime.Sub(result.StartTime) - throttled,
+				QueueWaitTime:  result.StartTime.Sub(t.CreatedAt),
+				ThrottleTime:   throttled,
+			}
 
-			w.logger.Printf("Submitting result for task %s", result.TaskID)
-			resultBytes, err := json.Marshal(result)
-			if err != nil {
-				w.logger.Printf("Failed to marshal result for task %s: %v", result.TaskID, err)
+			// A preempted task that did not finish anyway goes back to
+			// its queue instead of producing a result
+			if preempted && result.Status != task.StatusCompleted {
+				w.requeuePreempted(ctx, t)
+				w.forgetRunning(t.ID)
+				atomic.AddInt32(&w.metrics.IdleWorkers, 1)
+				w.notifySlotFreed()
 				continue
 			}
 
-			err = w.redis.HSet(ctx,
-				fmt.Sprintf("worker:%s:results", w.id),
-				result.TaskID,
-				resultBytes,
-			).Err()
+			atomic.AddUint64(&w.metrics.TasksProcessed, 1)
 
-			if err != nil {
-				w.logger.Printf("Failed to store result for task %s: %v", result.TaskID, err)
-				continue
+			// The task only stops counting as processing once its result
+			// is stored, and goes back to its queue if it cannot be
+			if err := w.storeResult(ctx, result); err != nil {
+				w.logger.Printf("Failed to store result for task %s, requeueing it: %v", t.ID, err)
+				if err := w.returnTask(ctx, t); err != nil {
+					w.logger.Printf("Failed to requeue task %s: %v", t.ID, err)
+				}
+			} else {
+				w.broker.HashDelete(ctx, broker.WorkerProcessingKey(w.id), t.ID)
+				w.logger.Printf("Task %s completed and result stored", t.ID)
 			}
+			w.forgetRunning(t.ID)
 
-			w.logger.Printf("Successfully submitted result for task %s", result.TaskID)
+			atomic.AddInt32(&w.metrics.IdleWorkers, 1)
+			w.notifySlotFreed()
 		}
 	}
 }
 
+func (w *Worker) storeResult(ctx context.Context, result *task.Result) error {
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	err = w.broker.HashSet(ctx, broker.WorkerResultsKey(w.id), result.TaskID, string(resultBytes))
+	if err != nil {
+		return err
+	}
+
+	// Only now is the task done as far as the stream is concerned
+	if w.backend == queue.Streams {
+		w.ackTask(ctx, result.TaskID)
+	}
+
+	events.Publish(ctx, w.broker, events.Event{Type: events.TaskCompleted, WorkerID: w.id, TaskID: result.TaskID})
+	return nil
+}
+
 func (w *Worker) GetMetrics() *WorkerMetrics {
 	return w.metrics
 }
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/task"
+)
+
+// TestReservedProcessors runs a pool of 3 with one slot reserved for
+// priority 10 and tasks so cheap that capacity units alone would let low
+// priority tasks take every processor. Only 2 may run at once, and a
+// priority 10 task still starts while they block.
+func TestReservedProcessors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b := broker.NewMemory()
+	defer b.Close()
+
+	var running, most int32
+	release := make(chan struct{})
+	urgentRan := make(chan struct{})
+	low := func(ctx context.Context, t *task.Task) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, nil
+	}
+	urgent := func(ctx context.Context, t *task.Task) ([]byte, error) {
+		close(urgentRan)
+		return nil, nil
+	}
+
+	w := NewWorker(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithBroker(b),
+		WithPoolSize(3),
+		WithReservation(1, 10),
+		WithDispatchMode(DispatchPull),
+		WithQueueBackend(queue.SortedSets),
+		WithHandler("low", low),
+		WithHandler("urgent", urgent),
+	)
+	go w.Start(ctx)
+	defer close(release)
+
+	scheduler := task.NewScheduler(b)
+	submit := func(taskType string, priority int) {
+		tk := task.NewTask(taskType, nil)
+		tk.Cost = 1
+		if err := scheduler.ScheduleTask(ctx, tk, &task.ScheduleOptions{Priority: priority}); err != nil {
+			t.Fatalf("ScheduleTask: %v", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		submit("low", 5)
+	}
+
+	for atomic.LoadInt32(&running) < 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("got %d low priority tasks running, want 2", atomic.LoadInt32(&running))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// Give a third one the chance to start
+	time.Sleep(500 * time.Millisecond)
+	if n := atomic.LoadInt32(&most); n != 2 {
+		t.Fatalf("at most %d low priority tasks ran at once, want 2", n)
+	}
+
+	submit("urgent", 10)
+	select {
+	case <-urgentRan:
+	case <-ctx.Done():
+		t.Fatal("priority 10 task did not start on the reserved slot")
+	}
+}
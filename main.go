@@ -3,52 +3,188 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/api"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/broker"
 	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/coordinator"
-	"github.com/go-redis/redis/v8"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/queue"
+	"github.com/NotMalek/DistributedTaskProcessingSystem/internal/worker"
 )
 
 type Config struct {
-	RedisURL string
-	APIPort  string
+	RedisURL        string
+	Broker          string
+	DataDir         string
+	Namespace       string
+	ListKeys        bool
+	Purge           bool
+	APIPort         string
+	AdminToken      string
+	Strategy        string
+	Ordering        string
+	EDFSlack        time.Duration
+	PriorityClasses string
+	Fairness        string
+	AgingRate       time.Duration
+	AgingCap        int
+	PreemptLevel    int
+	Dispatch        string
+	QueueBackend    string
+	ShutdownTimeout time.Duration
 }
 
 func main() {
 	cfg := &Config{}
-	flag.StringVar(&cfg.RedisURL, "redis", "localhost:6379", "Redis connection URL")
+	flag.StringVar(&cfg.RedisURL, "redis", "localhost:6379", "Redis address list or redis[s]:// URL (Sentinel, Cluster, auth, TLS)")
+	flag.StringVar(&cfg.Broker, "broker", broker.Redis,
+		"Storage backend ("+strings.Join(broker.Kinds(), ", ")+"); memory and file run everything in this process")
+	flag.StringVar(&cfg.DataDir, "data-dir", "data", "Directory the file broker keeps its state in")
+	flag.StringVar(&cfg.Namespace, "namespace", os.Getenv("DTPS_NAMESPACE"), "Prefix for every key, to share one Redis between clusters")
+	flag.BoolVar(&cfg.ListKeys, "list-keys", false, "List the keys in the namespace and exit")
+	flag.BoolVar(&cfg.Purge, "purge", false, "Delete every key in the namespace and exit")
 	flag.StringVar(&cfg.APIPort, "port", "8080", "API server port")
+	flag.StringVar(&cfg.AdminToken, "admin-token", os.Getenv("DTPS_ADMIN_TOKEN"), "Token for the admin endpoints across tenants; disabled when empty")
+	flag.StringVar(&cfg.Strategy, "strategy", coordinator.StrategyRoundRobin,
+		"Task assignment strategy ("+strings.Join(coordinator.Strategies(), ", ")+")")
+	flag.StringVar(&cfg.Ordering, "ordering", queue.FIFO,
+		"Order of tasks within a priority ("+strings.Join(queue.Orderings(), ", ")+")")
+	flag.DurationVar(&cfg.EDFSlack, "edf-slack", queue.DefaultSlack, "Under edf ordering, how long after submission tasks without a deadline are due")
+	flag.StringVar(&cfg.PriorityClasses, "priority-classes", queue.DefaultClasses().String(),
+		"Priority classes as comma separated name=level pairs, higher levels are served first")
+	flag.StringVar(&cfg.Fairness, "fairness", coordinator.FairNone,
+		"How tasks of the same priority share the workers ("+strings.Join(coordinator.FairnessModes(), ", ")+"); needs push dispatch")
+	flag.DurationVar(&cfg.AgingRate, "aging-rate", 0, "Raise a queued task's priority by one for every this much it waits; 0 disables aging")
+	flag.IntVar(&cfg.AgingCap, "aging-cap", 0, "Level of the highest priority class aging raises tasks to; 0 for the highest class")
+	flag.IntVar(&cfg.PreemptLevel, "preempt-priority", 0,
+		"Level of the lowest priority class whose tasks preempt running tasks of lower priority when workers are full; 0 disables preemption")
+	flag.StringVar(&cfg.Dispatch, "dispatch", worker.DispatchPush,
+		"How tasks reach workers ("+strings.Join(worker.DispatchModes(), ", ")+")")
+	flag.StringVar(&cfg.QueueBackend, "queue", queue.SortedSets,
+		"Queue backend ("+strings.Join(queue.Backends(), ", ")+")")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", time.Minute, "Time allowed for draining workers on shutdown")
 	flag.Parse()
 
 	// Setup logger
 	logger := log.New(os.Stdout, "[Server] ", log.LstdFlags)
 
+	if err := broker.ValidateKind(cfg.Broker); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	strategy, err := coordinator.NewStrategy(cfg.Strategy)
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := worker.ValidateDispatchMode(cfg.Dispatch); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := queue.ValidateBackend(cfg.QueueBackend); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := queue.ValidateOrdering(cfg.Ordering); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.Ordering != queue.FIFO && cfg.QueueBackend != queue.SortedSets {
+		logger.Fatalf("Invalid configuration: %s ordering needs the %s queue, streams are ordered by arrival", cfg.Ordering, queue.SortedSets)
+	}
+	if err := coordinator.ValidateFairness(cfg.Fairness); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.Fairness != coordinator.FairNone && (cfg.Dispatch != worker.DispatchPush || cfg.QueueBackend != queue.SortedSets) {
+		logger.Fatalf("Invalid configuration: fair queuing needs push dispatch with the %s queue, workers choose their own tasks otherwise", queue.SortedSets)
+	}
+	classes, err := queue.ParseClasses(cfg.PriorityClasses)
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if _, ok := classes.Class(cfg.AgingCap); cfg.AgingCap != 0 && !ok {
+		logger.Fatalf("Invalid configuration: aging cap %d is not the level of a priority class (%s)", cfg.AgingCap, classes)
+	}
+	if _, ok := classes.Class(cfg.PreemptLevel); cfg.PreemptLevel != 0 && !ok {
+		logger.Fatalf("Invalid configuration: preemption priority %d is not the level of a priority class (%s)", cfg.PreemptLevel, classes)
+	}
+	if cfg.PreemptLevel != 0 && (cfg.Dispatch != worker.DispatchPush || cfg.QueueBackend != queue.SortedSets) {
+		logger.Fatalf("Invalid configuration: preemption needs push dispatch with the %s queue, workers choose their own tasks otherwise", queue.SortedSets)
+	}
+	if cfg.AgingRate > 0 && cfg.QueueBackend != queue.SortedSets {
+		logger.Fatalf("Invalid configuration: priority aging needs the %s queue, stream entries cannot move between streams", queue.SortedSets)
+	}
+
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Initialize Redis client
-	rdb := redis.NewClient(&redis.Options{
-		Addr: cfg.RedisURL,
+	// Connect to the broker. The API server, coordinator and API-managed
+	// workers all share it.
+	b, err := broker.Open(broker.Options{
+		Kind:      cfg.Broker,
+		RedisURL:  cfg.RedisURL,
+		Dir:       cfg.DataDir,
+		Namespace: cfg.Namespace,
 	})
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	defer b.Close()
 
-	// Test Redis connection
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		logger.Fatalf("Failed to connect to Redis: %v", err)
+	if err := b.Ping(ctx); err != nil {
+		logger.Fatalf("Failed to connect to %s broker: %v", cfg.Broker, err)
+	}
+
+	// Maintenance commands run against the namespace and exit
+	switch {
+	case cfg.ListKeys:
+		keys, err := b.Keys(ctx, "")
+		if err != nil {
+			logger.Fatalf("Failed to list keys: %v", err)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return
+	case cfg.Purge:
+		deleted, err := broker.Purge(ctx, b)
+		if err != nil {
+			logger.Fatalf("Failed to purge namespace %q: %v", cfg.Namespace, err)
+		}
+		logger.Printf("Deleted %d keys from namespace %q", deleted, cfg.Namespace)
+		return
+	}
+	if cfg.Namespace != "" {
+		logger.Printf("Using namespace %q", cfg.Namespace)
+	}
+	switch cfg.Broker {
+	case broker.Memory:
+		logger.Printf("Running with the in-memory broker, only API-managed workers can join")
+	case broker.File:
+		logger.Printf("Running with the file broker in %s, only API-managed workers can join", cfg.DataDir)
 	}
 
 	// Create API server
-	apiServer := api.NewServer(rdb)
+	apiServer := api.NewServer(b, api.WithAdminToken(cfg.AdminToken))
 
 	// Create coordinator
 	coord := coordinator.New(
 		coordinator.WithLogger(log.New(os.Stdout, "[Coordinator] ", log.LstdFlags)),
-		coordinator.WithRedis(cfg.RedisURL),
+		coordinator.WithBroker(b),
+		coordinator.WithRecovery(cfg.Broker == broker.File),
+		coordinator.WithStrategy(strategy),
+		coordinator.WithOrdering(queue.Ordering{Mode: cfg.Ordering, SlackSeconds: int64(cfg.EDFSlack.Seconds())}),
+		coordinator.WithPriorityClasses(classes),
+		coordinator.WithFairness(cfg.Fairness),
+		coordinator.WithAging(cfg.AgingRate, cfg.AgingCap),
+		coordinator.WithPreemption(cfg.PreemptLevel),
+		coordinator.WithDispatchMode(cfg.Dispatch),
+		coordinator.WithQueueBackend(cfg.QueueBackend),
 	)
 
 	// WaitGroup to manage components
@@ -80,6 +216,13 @@ func main() {
 	go func() {
 		<-sigChan
 		logger.Println("Shutdown signal received, gracefully stopping...")
+
+		// Drain workers before the coordinator goes away
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer shutdownCancel()
+		if err := apiServer.Shutdown(shutdownCtx); err != nil {
+			logger.Printf("API server shutdown error: %v", err)
+		}
 		cancel()
 	}()
 